@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// chatConfig maps chat labels to Telegram chats.
+// It is updated in place when Telegram reports a chat migration.
+type chatConfig struct {
+	mu          sync.RWMutex
+	path        string
+	defaultChat chatEntry
+	chats       map[string]chatEntry
+}
+
+// chatEntry is a labelled chat: either a "chat_id[:topic]" string
+// or an object with delivery settings.
+type chatEntry struct {
+	ChatID      string `json:"chat_id"`
+	Topic       int    `json:"topic,omitempty"`
+	Silent      bool   `json:"silent,omitempty"`
+	LinkPreview *bool  `json:"link_preview,omitempty"`
+	// Notify is the delivery policy of messages with a level, e.g. "watch:act-now".
+	Notify *delivery.Policy `json:"notify,omitempty"`
+	// QuietHours defers messages below Act Now, e.g. "22:00-07:00 Europe/Berlin".
+	QuietHours *delivery.QuietHours `json:"quiet_hours,omitempty"`
+}
+
+func parseChatEntry(s string) (chatEntry, error) {
+	target, err := tg.ParseTarget(s)
+	if err != nil {
+		return chatEntry{}, err
+	}
+	return chatEntry{ChatID: target.ChatID, Topic: target.ThreadID}, nil
+}
+
+func (e *chatEntry) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		entry, err := parseChatEntry(s)
+		if err != nil {
+			return err
+		}
+		*e = entry
+		return nil
+	}
+
+	type plain chatEntry
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if e.ChatID == "" {
+		return errors.New("chat_id is empty")
+	}
+	return nil
+}
+
+func (e chatEntry) MarshalJSON() ([]byte, error) {
+	if !e.Silent && e.LinkPreview == nil && e.Notify == nil && e.QuietHours == nil {
+		return json.Marshal(tg.Target{ChatID: e.ChatID, ThreadID: e.Topic}.String())
+	}
+	type plain chatEntry
+	return json.Marshal(plain(e))
+}
+
+func (e chatEntry) info(label string) chatInfo {
+	info := chatInfo{Label: label, ID: e.ChatID, Silent: e.Silent, Policy: delivery.DefaultPolicy, Quiet: e.QuietHours}
+	if e.Notify != nil {
+		info.Policy = *e.Notify
+	}
+	info.Options = []tg.SendOption{tg.InThread(e.Topic)}
+	if e.LinkPreview != nil {
+		info.Options = append(info.Options, tg.WithoutLinkPreview(!*e.LinkPreview))
+	}
+	return info
+}
+
+func loadChatConfig(path, defaultChat string) (*chatConfig, error) {
+	defaultEntry, err := parseChatEntry(defaultChat)
+	if err != nil {
+		return nil, fmt.Errorf("default chat: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Chats map[string]chatEntry `json:"chats"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if cfg.Chats == nil {
+		cfg.Chats = map[string]chatEntry{}
+	}
+	return &chatConfig{
+		path:        path,
+		defaultChat: defaultEntry,
+		chats:       cfg.Chats,
+	}, nil
+}
+
+func (c *chatConfig) defaultInfo() chatInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.defaultChat.info("default")
+}
+
+func (c *chatConfig) lookup(label string) (chatInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if entry, ok := c.chats[label]; ok && label != "" {
+		return entry.info(label), nil
+	}
+	return chatInfo{}, chatLookupError{Label: label, Labels: slices.Sorted(maps.Keys(c.chats))}
+}
+
+// resolve returns the chat with the label of a chatInfo, "default" is the default chat.
+func (c *chatConfig) resolve(label string) (chatInfo, error) {
+	if label == "default" {
+		return c.defaultInfo(), nil
+	}
+	return c.lookup(label)
+}
+
+// hasQuietHours reports whether any labelled chat has quiet hours.
+func (c *chatConfig) hasQuietHours() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, entry := range c.chats {
+		if entry.QuietHours != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// migrate replaces chat ID from with to and persists labelled chats into
+// the config file. It returns the labels which were updated.
+func (c *chatConfig) migrate(from, to string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var labels []string
+	if c.defaultChat.ChatID == from {
+		c.defaultChat.ChatID = to
+		labels = append(labels, "default")
+	}
+
+	persist := false
+	for label, entry := range c.chats {
+		if entry.ChatID == from {
+			entry.ChatID = to
+			c.chats[label] = entry
+			labels = append(labels, label)
+			persist = true
+		}
+	}
+	slices.Sort(labels)
+
+	if !persist {
+		return labels, nil
+	}
+	if err := c.save(); err != nil {
+		return labels, fmt.Errorf("save chat config %s: %w", c.path, err)
+	}
+	return labels, nil
+}
+
+// save rewrites the config file, keeping unknown top-level keys.
+func (c *chatConfig) save() error {
+	cfg := map[string]json.RawMessage{}
+	if data, err := os.ReadFile(c.path); err == nil {
+		_ = json.Unmarshal(data, &cfg)
+	}
+
+	chats, err := json.Marshal(c.chats)
+	if err != nil {
+		return err
+	}
+	cfg["chats"] = chats
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+func newDefaultResolver(chats *chatConfig) chatResolverFunc {
+	return func(*http.Request) (chatInfo, error) {
+		return chats.defaultInfo(), nil
+	}
+}
+
+func newChatResolver(chats *chatConfig) chatResolverFunc {
+	return func(r *http.Request) (chatInfo, error) {
+		return chats.lookup(r.PathValue("label"))
+	}
+}
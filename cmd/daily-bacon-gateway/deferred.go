@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+const (
+	// deferredTick is how often due deferred messages are delivered.
+	deferredTick = time.Minute
+	// deferredExpiry is how long after its due time a failing deferred
+	// message is retried.
+	deferredExpiry = 24 * time.Hour
+)
+
+// deferredPosts keeps messages deferred by quiet hours in the state file,
+// with their uploads in a directory, and delivers them when they are due.
+type deferredPosts struct {
+	logger *slog.Logger
+	client *tg.Client
+	chats  *chatConfig
+	store  *state.Store
+	dir    string
+}
+
+// save keeps the message until the end of quiet hours.
+func (d *deferredPosts) save(msg outgoing, until time.Time) error {
+	post := state.Deferred{
+		Chat:   msg.chat.Label,
+		Due:    until,
+		Text:   msg.text,
+		Silent: msg.notification.Silent,
+		Pin:    msg.notification.Pin,
+	}
+	for _, upload := range msg.uploads {
+		path, err := d.saveUpload(upload)
+		if err != nil {
+			removeFiles(post.Files)
+			return fmt.Errorf("save %s: %w", upload.FileName, err)
+		}
+		post.Files = append(post.Files, state.DeferredFile{
+			Path:        path,
+			Name:        upload.FileName,
+			ContentType: upload.ContentType,
+		})
+	}
+
+	if _, err := d.store.AddDeferred(post); err != nil {
+		removeFiles(post.Files)
+		return err
+	}
+	return nil
+}
+
+// saveUpload copies the upload into the directory and returns the file path.
+func (d *deferredPosts) saveUpload(upload collectedUpload) (string, error) {
+	file, err := os.CreateTemp(d.dir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, upload.Reader); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// run delivers due messages right away and then every deferredTick until ctx is done.
+func (d *deferredPosts) run(ctx context.Context) {
+	ticker := time.NewTicker(deferredTick)
+	defer ticker.Stop()
+
+	for {
+		d.flush(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush delivers messages due at now by the current settings of their chats.
+// Delivered messages are dropped, failed ones are retried by the next
+// flush until they expire. Only the text is retried if the media went out.
+func (d *deferredPosts) flush(ctx context.Context, now time.Time) {
+	for _, post := range d.store.DueDeferred(now) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := d.send(ctx, post)
+		if errors.Is(err, errTextAfterMedia) {
+			post = d.dropFiles(post)
+		}
+		switch {
+		case err == nil:
+		case now.Sub(post.Due) < deferredExpiry:
+			continue
+		default:
+			d.logger.Warn("deferred message expired", "err", err, "chat_label", post.Chat, "due", post.Due)
+		}
+
+		if _, err := d.store.DeleteDeferred(post.ID); err != nil {
+			d.logger.Error("delete deferred message", "err", err, "chat_label", post.Chat)
+			continue
+		}
+		removeFiles(post.Files)
+	}
+}
+
+func (d *deferredPosts) send(ctx context.Context, post state.Deferred) error {
+	chat, err := d.chats.resolve(post.Chat)
+	if err != nil {
+		d.logger.Error("resolve deferred message chat", "err", err, "chat_label", post.Chat)
+		return err
+	}
+
+	msg := outgoing{
+		chat:         chat,
+		text:         post.Text,
+		notification: delivery.Notification{Silent: post.Silent, Pin: post.Pin},
+	}
+	for _, file := range post.Files {
+		reader, err := os.Open(file.Path)
+		if err != nil {
+			closeUploads(msg.uploads)
+			d.logger.Error("open deferred upload", "err", err, "chat_label", post.Chat)
+			return err
+		}
+		msg.uploads = append(msg.uploads, collectedUpload{
+			Reader:      reader,
+			FileName:    file.Name,
+			ContentType: file.ContentType,
+		})
+	}
+	defer closeUploads(msg.uploads)
+
+	ctx, cancel := context.WithTimeout(ctx, deferredTimeout)
+	defer cancel()
+	return msg.send(ctx, d.logger, d.client)
+}
+
+// dropFiles keeps only the text of a post whose media was delivered,
+// so the media is not sent again by the retries.
+func (d *deferredPosts) dropFiles(post state.Deferred) state.Deferred {
+	if _, err := d.store.UpdateDeferred(post.ID, func(post *state.Deferred) { post.Files = nil }); err != nil {
+		d.logger.Error("drop delivered deferred media", "err", err, "chat_label", post.Chat)
+		return post
+	}
+	removeFiles(post.Files)
+	post.Files = nil
+	return post
+}
+
+func removeFiles(files []state.DeferredFile) {
+	for _, file := range files {
+		_ = os.Remove(file.Path)
+	}
+}
@@ -1,24 +1,31 @@
 package main
 
 import (
-	"bytes"
 	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"maps"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"unicode/utf8"
 
 	"golang.org/x/time/rate"
 
+	"github.com/ninedraft/daily-bacon/internal/bot"
+	"github.com/ninedraft/daily-bacon/internal/client"
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/redact"
+	"github.com/ninedraft/daily-bacon/internal/state"
 	"github.com/ninedraft/daily-bacon/internal/tg"
 
 	_ "golang.org/x/crypto/x509roots/fallback"
@@ -27,17 +34,35 @@ import (
 const (
 	envChatID      = "DAILY_BACON_CHAT_ID"
 	envTokenFile   = "TELEGRAM_TOKEN_FILE"
+	envAPIURL      = "TELEGRAM_API_URL"
 	envGatewayAddr = "DAILY_BACON_GATEWAY_ADDR"
 	envChatsConfig = "DAILY_BACON_CHAT_CONFIG"
+	envWebhook     = "DAILY_BACON_WEBHOOK_SECRET"
+	envLatitude    = "DAILY_BACON_LATITUDE"
+	envLongitude   = "DAILY_BACON_LONGITUDE"
+	envPlace       = "DAILY_BACON_PLACE"
+	envState       = "DAILY_BACON_STATE"
 
 	defaultGatewayAddr = ":8080"
 	maxMultipartMemory = 64 << 20 // 64MB
-	maxCaptionRunes    = 1024
+	shutdownTimeout    = 30 * time.Second
+	deferredTimeout    = time.Minute
+
+	defaultLatitude  = 34.707130
+	defaultLongitude = 33.022617
 )
 
 type chatInfo struct {
-	Label string
-	ID    string
+	Label   string
+	ID      string
+	Options []tg.SendOption
+	// Silent delivers all messages without a notification sound,
+	// regardless of the policy.
+	Silent bool
+	// Policy delivers messages with a level by its severity.
+	Policy delivery.Policy
+	// Quiet defers messages below Act Now, nil if the chat has no quiet hours.
+	Quiet *delivery.QuietHours
 }
 
 type chatResolverFunc func(*http.Request) (chatInfo, error)
@@ -52,7 +77,7 @@ func (e chatLookupError) Error() string {
 }
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger := slog.New(redact.New().Handler(slog.NewTextHandler(os.Stdout, nil)))
 	if err := run(logger); err != nil {
 		logger.Error("gateway failed to start", "err", err)
 		os.Exit(1)
@@ -77,36 +102,67 @@ func run(logger *slog.Logger) error {
 	if token == "" {
 		return fmt.Errorf("token file %s is empty", tokenFile)
 	}
-	if err := os.Setenv("TELEGRAM_TOKEN", token); err != nil {
-		return fmt.Errorf("set TELEGRAM_TOKEN: %w", err)
-	}
+	webhookSecret := os.Getenv(envWebhook)
+	logger = slog.New(redact.New(token, webhookSecret).Handler(logger.Handler()))
 
 	configPath := os.Getenv(envChatsConfig)
 	if configPath == "" {
 		return fmt.Errorf("missing configmap from %q", envChatsConfig)
 	}
 
-	chatMap, err := loadChatConfig(configPath)
+	chats, err := loadChatConfig(configPath, chatID)
 	if err != nil {
 		return fmt.Errorf("load chat config %s: %w", configPath, err)
 	}
 
-	client := tg.New(http.DefaultClient)
+	client := tg.New(
+		tg.WithToken(token),
+		tg.WithAPIURL(cmp.Or(os.Getenv(envAPIURL), tg.DefaultAPIURL)),
+		tg.WithLogger(logger),
+		tg.WithFileCache(tg.NewFileCache()),
+		tg.WithMigrationHandler(func(from, to string) {
+			labels, err := chats.migrate(from, to)
+			if err != nil {
+				logger.Error("persist chat migration", "err", err, "from", from, "to", to, "chat_labels", labels)
+				return
+			}
+			logger.Warn("chat was upgraded to a supergroup", "from", from, "to", to, "chat_labels", labels)
+			if slices.Contains(labels, "default") {
+				logger.Warn("update "+envChatID+" to keep the default chat after restart", "to", to)
+			}
+		}),
+	)
 
 	addr := os.Getenv(envGatewayAddr)
 	if addr == "" {
 		addr = defaultGatewayAddr
 	}
 
+	deferred, err := openDeferredPosts(logger, client, chats)
+	if err != nil {
+		return err
+	}
+
 	limiter := rate.NewLimiter(rate.Every(time.Second/2), 1)
 
 	mux := http.NewServeMux()
-	defaultResolver := func(*http.Request) (chatInfo, error) {
-		return chatInfo{Label: "default", ID: chatID}, nil
-	}
+	mux.HandleFunc("/message", messageHandler(logger, client, limiter, newDefaultResolver(chats), deferred))
+	mux.HandleFunc("/message/{label}", messageHandler(logger, client, limiter, newChatResolver(chats), deferred))
 
-	mux.HandleFunc("/message", messageHandler(logger, client, limiter, defaultResolver))
-	mux.HandleFunc("/message/{label}", messageHandler(logger, client, limiter, newChatResolver(chatMap)))
+	if webhookSecret != "" {
+		hook, err := newWebhook(logger, webhookSecret)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envWebhook, err)
+		}
+		defer hook.close()
+		b, err := newBot(logger, client)
+		if err != nil {
+			return err
+		}
+		hook.handle(b.Handle)
+		mux.Handle("/telegram/webhook/{secret}", hook)
+		logger.Info("telegram webhook enabled")
+	}
 
 	server := &http.Server{
 		Addr:         addr,
@@ -116,11 +172,106 @@ func run(logger *slog.Logger) error {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if deferred != nil {
+		flushed := make(chan struct{})
+		go func() {
+			defer close(flushed)
+			deferred.run(ctx)
+		}()
+		defer func() {
+			stop()
+			<-flushed
+		}()
+	}
+
 	logger.Info("gateway starting", "addr", addr, "chat", chatID)
-	return server.ListenAndServe()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	// requests in flight are finished, then the webhook handles queued updates
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	logger.Info("gateway stopped")
+	return nil
+}
+
+// openDeferredPosts opens the state file keeping messages deferred by quiet
+// hours, uploads are kept in a directory next to it. It returns nil if the
+// state file is not set and no chat has quiet hours.
+func openDeferredPosts(logger *slog.Logger, client *tg.Client, chats *chatConfig) (*deferredPosts, error) {
+	path := os.Getenv(envState)
+	if path == "" {
+		if chats.hasQuietHours() {
+			return nil, fmt.Errorf("%s must be set to defer messages of chats with quiet_hours", envState)
+		}
+		return nil, nil
+	}
+
+	store, err := state.Open(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	dir := path + ".uploads"
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create uploads directory: %w", err)
+	}
+	return &deferredPosts{logger: logger, client: client, chats: chats, store: store, dir: dir}, nil
+}
+
+// newBot returns the bot answering updates received by the webhook.
+func newBot(logger *slog.Logger, tgClient *tg.Client) (*bot.Bot, error) {
+	latitude, err := envFloat(envLatitude, defaultLatitude)
+	if err != nil {
+		return nil, err
+	}
+	longitude, err := envFloat(envLongitude, defaultLongitude)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bot.New(bot.Config{
+		Client:  tgClient,
+		Fetcher: meteo.New(client.New(http.DefaultClient.Transport)),
+		Logger:  logger,
+		Location: bot.Location{
+			Name:      os.Getenv(envPlace),
+			Latitude:  latitude,
+			Longitude: longitude,
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := b.Init(ctx); err != nil {
+		return nil, fmt.Errorf("init bot: %w", err)
+	}
+	return b, nil
+}
+
+func envFloat(name string, fallback float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return parsed, nil
 }
 
-func messageHandler(logger *slog.Logger, client *tg.Client, limiter *rate.Limiter, resolver chatResolverFunc) http.HandlerFunc {
+func messageHandler(logger *slog.Logger, client *tg.Client, limiter *rate.Limiter, resolver chatResolverFunc, deferred *deferredPosts) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := limiter.Wait(r.Context()); err != nil {
 			slog.Error("waiting for limit", "error", err)
@@ -154,87 +305,153 @@ func messageHandler(logger *slog.Logger, client *tg.Client, limiter *rate.Limite
 			return
 		}
 
-		text := strings.TrimSpace(r.FormValue("text"))
-		captionText := text
-		needsSeparateText := false
-		if text != "" && utf8.RuneCountInString(text) > maxCaptionRunes {
-			captionText = ""
-			needsSeparateText = true
+		// messages with a level are delivered by the chat's policy,
+		// messages without one are not critical
+		msg := outgoing{chat: chat}
+		level := meteo.LevelGood
+		if formLevel := r.FormValue("level"); formLevel != "" {
+			level, err = meteo.ParseLevel(formLevel)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			msg.notification = chat.Policy.Notify(level)
 		}
-		uploads, err := collectUploads(r.MultipartForm)
+
+		msg.text = strings.TrimSpace(r.FormValue("text"))
+		msg.uploads, err = collectUploads(r.MultipartForm)
 		if err != nil {
 			logger.Error("collect uploads", "err", err)
 			http.Error(w, "failed to read uploads", http.StatusBadRequest)
 			return
 		}
+		defer closeUploads(msg.uploads)
 
-		logger.Info("incoming request", "remote", r.RemoteAddr, "files", len(uploads), "has_text", text != "", "chat_label", chat.Label)
+		logger.Info("incoming request", "remote", r.RemoteAddr, "files", len(msg.uploads), "has_text", msg.text != "", "chat_label", chat.Label)
 
-		switch {
-		case len(uploads) == 0 && text == "":
+		if len(msg.uploads) == 0 && msg.text == "" {
 			logger.Info("nothing to send, skipping", "chat_label", chat.Label)
 			w.WriteHeader(http.StatusNoContent)
 			return
-		case len(uploads) == 0:
-			if err := client.SendMessage(r.Context(), chat.ID, text); err != nil {
-				logger.Error("send text message", "err", err, "chat_label", chat.Label)
-				http.Error(w, "failed to deliver message", http.StatusInternalServerError)
+		}
+
+		if until, ok := chat.Quiet.Defer(time.Now(), level); ok {
+			if err := deferred.save(msg, until); err != nil {
+				logger.Error("defer message", "err", err, "chat_label", chat.Label)
+				http.Error(w, "failed to defer message", http.StatusInternalServerError)
 				return
 			}
+			logger.Info("quiet hours, deferred", "chat_label", chat.Label, "until", until)
+
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"deferred_until": until,
+			})
 			return
 		}
 
-		type fileEntry struct {
-			ContentType string
+		if err := msg.send(r.Context(), logger, client); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		logEntry := map[string]fileEntry{}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
 
-		media := make([]tg.MediaUpload, len(uploads))
-		for i, upload := range uploads {
-			var caption string
-			if i == 0 {
-				caption = captionText
-			}
-			media[i] = tg.MediaUpload{
-				FileName:    upload.FileName,
-				Reader:      upload.Reader,
-				ContentType: upload.ContentType,
-				Caption:     caption,
-			}
+// outgoing is a message for a chat.
+type outgoing struct {
+	chat         chatInfo
+	text         string
+	uploads      []collectedUpload
+	notification delivery.Notification
+}
 
-			logEntry[upload.FileName] = fileEntry{
-				ContentType: upload.ContentType,
-			}
+// errTextAfterMedia is returned by send when the media was delivered
+// and the text following it was not.
+var errTextAfterMedia = errors.New("failed to deliver media text")
+
+// send delivers the message. Failures are logged, the returned error
+// describes the failed stage for the client.
+func (m outgoing) send(ctx context.Context, logger *slog.Logger, client *tg.Client) error {
+	chat, text := m.chat, m.text
+	// silent chats stay silent whatever the notification
+	opts := append(slices.Clip(chat.Options), tg.Silent(chat.Silent || m.notification.Silent))
+	if len(m.uploads) == 0 {
+		sent, err := client.SendText(ctx, chat.ID, text, opts...)
+		if err != nil {
+			logger.Error("send text message", "err", err, "chat_label", chat.Label, "sent", len(sent))
+			return errors.New("failed to deliver message")
 		}
+		m.notification.PinAlert(ctx, logger, client, chat.ID, sent[0].MessageID)
+		return nil
+	}
 
-		logger.Info("sending files", "files", logEntry, "chat_label", chat.Label)
+	captionText := text
+	needsSeparateText := false
+	if tg.UTF16Len(text) > tg.MaxCaptionLength {
+		captionText = ""
+		needsSeparateText = true
+	}
 
-		if err := client.SendMediaGroup(r.Context(), chat.ID, media); err != nil {
-			logger.Error("send media group", "err", err, "chat_label", chat.Label)
-			http.Error(w, "failed to deliver media group", http.StatusInternalServerError)
-			return
+	type fileEntry struct {
+		ContentType string
+	}
+	logEntry := map[string]fileEntry{}
+
+	media := make([]tg.MediaUpload, len(m.uploads))
+	for i, upload := range m.uploads {
+		var caption string
+		if i == 0 {
+			caption = captionText
+		}
+		media[i] = tg.MediaUpload{
+			FileName:    upload.FileName,
+			Reader:      upload.Reader,
+			ContentType: upload.ContentType,
+			Caption:     caption,
 		}
 
-		if needsSeparateText {
-			if err := client.SendMessage(r.Context(), chat.ID, text); err != nil {
-				logger.Error("send text message after media group", "err", err, "chat_label", chat.Label)
-				http.Error(w, "failed to deliver media group text", http.StatusInternalServerError)
-				return
-			}
+		logEntry[upload.FileName] = fileEntry{
+			ContentType: upload.ContentType,
 		}
+	}
 
-		logger.Info("delivered", "chat_label", chat.Label, "chat_id", chat.ID)
-		w.WriteHeader(http.StatusAccepted)
+	logger.Info("sending files", "files", logEntry, "chat_label", chat.Label)
+
+	sent, err := client.SendMedia(ctx, chat.ID, media, opts...)
+	if err != nil {
+		logger.Error("send media", "err", err, "chat_label", chat.Label, "sent", len(sent))
+		return errors.New("failed to deliver media")
 	}
+
+	if needsSeparateText {
+		if sent, err := client.SendText(ctx, chat.ID, text, opts...); err != nil {
+			logger.Error("send text message after media", "err", err, "chat_label", chat.Label, "sent", len(sent))
+			return errTextAfterMedia
+		}
+	}
+
+	m.notification.PinAlert(ctx, logger, client, chat.ID, sent[0].MessageID)
+
+	logger.Info("delivered", "chat_label", chat.Label, "chat_id", chat.ID)
+	return nil
 }
 
 type collectedUpload struct {
-	Reader      io.Reader
+	// Reader is seekable, so the upload can be repeated
+	// when the chat was migrated to a supergroup.
+	Reader      multipart.File
 	FileName    string
 	ContentType string
 }
 
+func closeUploads(uploads []collectedUpload) {
+	for _, upload := range uploads {
+		_ = upload.Reader.Close()
+	}
+}
+
 func collectUploads(form *multipart.Form) ([]collectedUpload, error) {
 	if form == nil {
 		return nil, nil
@@ -247,15 +464,15 @@ func collectUploads(form *multipart.Form) ([]collectedUpload, error) {
 		if err != nil {
 			return collectedUpload{}, fmt.Errorf("open %s: %w", fileheader.Filename, err)
 		}
-		defer file.Close()
 
-		re, detectedContentType, err := detectContentType(file)
+		detectedContentType, err := detectContentType(file)
 		if err != nil {
+			_ = file.Close()
 			return collectedUpload{}, err
 		}
 
 		return collectedUpload{
-			Reader:      re,
+			Reader:      file,
 			FileName:    fileheader.Filename,
 			ContentType: cmp.Or(fileheader.Header.Get("Content-Type"), detectedContentType),
 		}, nil
@@ -265,6 +482,7 @@ func collectUploads(form *multipart.Form) ([]collectedUpload, error) {
 		for _, fh := range list {
 			upload, err := handleFile(fh)
 			if err != nil {
+				closeUploads(uploads)
 				return nil, fmt.Errorf("handling file %q: %w", fh.Filename, err)
 			}
 			uploads = append(uploads, upload)
@@ -274,43 +492,30 @@ func collectUploads(form *multipart.Form) ([]collectedUpload, error) {
 	return uploads, nil
 }
 
-func detectContentType(re io.Reader) (_ io.Reader, contentType string, _ error) {
+func detectContentType(file io.ReadSeeker) (contentType string, _ error) {
 	head := make([]byte, 512)
 
-	n, err := re.Read(head)
-	if err != nil && !errors.Is(err, io.EOF) {
-		return nil, "", err
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
-	head = head[:n]
 
-	return io.MultiReader(bytes.NewReader(head), re), http.DetectContentType(head), nil
+	return http.DetectContentType(head[:n]), nil
 }
 
 func mwLog(log *slog.Logger, next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("request",
 			"method", r.Method,
-			"URL", r.URL)
+			"URL", redact.URL(r.URL))
 
 		next.ServeHTTP(w, r)
 	}
 }
 
-func newChatResolver(chats map[string]string) chatResolverFunc {
-	labels := slices.Sorted(maps.Keys(chats))
-
-	return func(r *http.Request) (chatInfo, error) {
-		label := r.PathValue("label")
-		if label == "" {
-			return chatInfo{}, chatLookupError{Label: label, Labels: labels}
-		}
-		if chatID, ok := chats[label]; ok {
-			return chatInfo{Label: label, ID: chatID}, nil
-		}
-		return chatInfo{}, chatLookupError{Label: label, Labels: labels}
-	}
-}
-
 func writeChatLookupError(w http.ResponseWriter, err chatLookupError) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusBadRequest)
@@ -319,20 +524,3 @@ func writeChatLookupError(w http.ResponseWriter, err chatLookupError) {
 		"labels": err.Labels,
 	})
 }
-
-func loadChatConfig(path string) (map[string]string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var cfg struct {
-		Chats map[string]string `json:"chats"`
-	}
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
-	}
-	if cfg.Chats == nil {
-		cfg.Chats = map[string]string{}
-	}
-	return cfg.Chats, nil
-}
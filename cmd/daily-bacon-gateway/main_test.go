@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
+)
+
+const testSecret = "test_secret-1"
+
+func newTestWebhook(t *testing.T) (*webhook, *http.ServeMux) {
+	hook, err := newWebhook(slog.New(slog.DiscardHandler), testSecret)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/telegram/webhook/{secret}", hook)
+	return hook, mux
+}
+
+func postUpdate(mux *http.ServeMux, pathSecret, headerSecret, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook/"+pathSecret, strings.NewReader(body))
+	if headerSecret != "" {
+		req.Header.Set(secretTokenHeader, headerSecret)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhook(t *testing.T) {
+	hook, mux := newTestWebhook(t)
+
+	var received []tg.Update
+	hook.handle(func(_ context.Context, update tg.Update) {
+		received = append(received, update)
+	})
+
+	const update = `{"update_id": 7, "message": {"message_id": 1, "chat": {"id": 42}, "text": "/now"}}`
+	tests := []struct {
+		name         string
+		pathSecret   string
+		headerSecret string
+		body         string
+		status       int
+	}{
+		{name: "missing header", pathSecret: testSecret, body: update, status: http.StatusForbidden},
+		{name: "wrong header", pathSecret: testSecret, headerSecret: "wrong", body: update, status: http.StatusForbidden},
+		{name: "wrong path", pathSecret: "wrong", headerSecret: testSecret, body: update, status: http.StatusNotFound},
+		{name: "malformed body", pathSecret: testSecret, headerSecret: testSecret, body: `{"update_id":`, status: http.StatusBadRequest},
+		{name: "update", pathSecret: testSecret, headerSecret: testSecret, body: update, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := postUpdate(mux, test.pathSecret, test.headerSecret, test.body)
+			require.Equal(t, test.status, rec.Code)
+		})
+	}
+
+	// close waits for the queued update to be handled
+	hook.close()
+	require.Len(t, received, 1)
+	require.Equal(t, 7, received[0].UpdateID)
+	require.Equal(t, "/now", received[0].Message.Text)
+	require.Equal(t, int64(42), received[0].Message.Chat.ID)
+}
+
+func TestWebhook_QueueFull(t *testing.T) {
+	hook, mux := newTestWebhook(t)
+
+	release := make(chan struct{})
+	hook.handle(func(context.Context, tg.Update) { <-release })
+
+	// busy workers and a full queue reject updates, Telegram redelivers them
+	var rejected int
+	for range webhookWorkers + webhookQueue + 1 {
+		rec := postUpdate(mux, testSecret, testSecret, `{"update_id": 1}`)
+		if rec.Code == http.StatusServiceUnavailable {
+			rejected++
+		}
+	}
+	require.Positive(t, rejected)
+
+	close(release)
+	hook.close()
+}
+
+func TestWebhook_Method(t *testing.T) {
+	hook, mux := newTestWebhook(t)
+	defer hook.close()
+
+	req := httptest.NewRequest(http.MethodGet, "/telegram/webhook/"+testSecret, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "method not allowed")
+}
+
+func postMessage(t *testing.T, handler http.Handler, path string, fields, files map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		require.NoError(t, form.WriteField(name, value))
+	}
+	for name, content := range files {
+		file, err := form.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMessageHandler_Silent(t *testing.T) {
+	tests := []struct {
+		name   string
+		entry  chatEntry
+		level  string
+		silent bool
+	}{
+		{name: "no level", entry: chatEntry{ChatID: "1"}},
+		{name: "policy", entry: chatEntry{ChatID: "1"}, level: "good", silent: true},
+		{name: "audible level", entry: chatEntry{ChatID: "1"}, level: "watch"},
+		{name: "silent chat", entry: chatEntry{ChatID: "1", Silent: true}, silent: true},
+		{name: "silent chat with audible level", entry: chatEntry{ChatID: "1", Silent: true}, level: "act-now", silent: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := tgtest.NewServer(t)
+			client := tg.New(tg.WithToken("tok"), tg.WithAPIURL(srv.URL), tg.WithDoer(srv.Client()))
+			resolver := func(*http.Request) (chatInfo, error) {
+				return test.entry.info("test"), nil
+			}
+			handler := messageHandler(slog.New(slog.DiscardHandler), client, rate.NewLimiter(rate.Inf, 1), resolver, nil)
+
+			rec := postMessage(t, handler, "/message", map[string]string{"text": "report", "level": test.level}, nil)
+			require.Equal(t, http.StatusAccepted, rec.Code)
+
+			messages := srv.Messages("1")
+			require.Len(t, messages, 1)
+			require.Equal(t, test.silent, messages[0].Silent)
+		})
+	}
+}
+
+func TestDeferredPosts(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	client := tg.New(tg.WithToken("tok"), tg.WithAPIURL(srv.URL), tg.WithDoer(srv.Client()))
+	logger := slog.New(slog.DiscardHandler)
+
+	// quiet hours around now
+	now := time.Now().UTC()
+	window := now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04") + " UTC"
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "chats.json")
+	config := `{"chats": {"night": {"chat_id": "1", "quiet_hours": "` + window + `"}}}`
+	require.NoError(t, os.WriteFile(configPath, []byte(config), 0o600))
+	chats, err := loadChatConfig(configPath, "2")
+	require.NoError(t, err)
+
+	statePath := filepath.Join(dir, "state.json")
+	t.Setenv(envState, statePath)
+	deferred, err := openDeferredPosts(logger, client, chats)
+	require.NoError(t, err)
+
+	newMux := func(deferred *deferredPosts) *http.ServeMux {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/message/{label}", messageHandler(logger, client, rate.NewLimiter(rate.Inf, 1), newChatResolver(chats), deferred))
+		return mux
+	}
+	mux := newMux(deferred)
+
+	rec := postMessage(t, mux, "/message/night", map[string]string{"text": "report", "level": "watch"}, map[string]string{"chart.bin": "data"})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var body struct {
+		DeferredUntil time.Time `json:"deferred_until"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	until := body.DeferredUntil
+	require.True(t, until.After(now))
+
+	rec = postMessage(t, mux, "/message/night", map[string]string{"text": "alert", "level": "act-now"}, nil)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Len(t, srv.Messages("1"), 1, "act now messages are not deferred")
+
+	// deferred messages survive restarts of the gateway
+	deferred, err = openDeferredPosts(logger, client, chats)
+	require.NoError(t, err)
+	mux = newMux(deferred)
+	deferred.flush(t.Context(), now)
+	require.Len(t, srv.Messages("1"), 1)
+
+	deferred.flush(t.Context(), until)
+	messages := srv.Messages("1")
+	require.Len(t, messages, 2)
+	require.Equal(t, "report", messages[1].Caption)
+	require.Equal(t, "chart.bin", messages[1].FileName)
+	require.True(t, messages[1].Uploaded)
+
+	uploads, err := os.ReadDir(deferred.dir)
+	require.NoError(t, err)
+	require.Empty(t, uploads, "uploads of delivered messages are removed")
+	require.Empty(t, deferred.store.DueDeferred(until))
+
+	// only the text is retried when it fails after the media
+	long := strings.Repeat("a", tg.MaxCaptionLength+1)
+	rec = postMessage(t, mux, "/message/night", map[string]string{"text": long}, map[string]string{"chart.bin": "data"})
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	srv.Fail("sendMessage", tgtest.BotBlocked())
+
+	deferred.flush(t.Context(), until)
+	messages = srv.Messages("1")
+	require.Len(t, messages, 3)
+	require.Equal(t, "chart.bin", messages[2].FileName)
+	due := deferred.store.DueDeferred(until)
+	require.Len(t, due, 1)
+	require.Empty(t, due[0].Files)
+	uploads, err = os.ReadDir(deferred.dir)
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+
+	deferred.flush(t.Context(), until)
+	messages = srv.Messages("1")
+	require.Len(t, messages, 4, "media is not sent again")
+	require.Equal(t, long, messages[3].Text)
+	require.Empty(t, deferred.store.DueDeferred(until))
+
+	// failed messages are retried until they expire
+	rec = postMessage(t, mux, "/message/night", map[string]string{"text": "late"}, nil)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	srv.Block("1")
+
+	deferred.flush(t.Context(), until)
+	require.Len(t, deferred.store.DueDeferred(until), 1)
+	deferred.flush(t.Context(), until.Add(deferredExpiry))
+	require.Empty(t, deferred.store.DueDeferred(until.Add(deferredExpiry)))
+}
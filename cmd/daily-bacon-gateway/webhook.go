@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+const (
+	// secretTokenHeader carries the secret_token passed to setWebhook.
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxSecretLength   = 256
+	maxUpdateBytes    = 1 << 20 // 1MB
+	// webhookHandlerTimeout bounds handling of a single update after it was acknowledged.
+	webhookHandlerTimeout = 30 * time.Second
+	webhookWorkers        = 4
+	// webhookQueue is the number of acknowledged updates waiting for a worker,
+	// Telegram redelivers updates rejected when it is full.
+	webhookQueue = 64
+)
+
+// updateHandler handles a single update, e.g. (*bot.Bot).Handle.
+type updateHandler func(context.Context, tg.Update)
+
+// webhook receives updates pushed by Telegram and dispatches them to registered handlers
+// by a fixed pool of workers.
+type webhook struct {
+	logger  *slog.Logger
+	secret  string
+	updates chan tg.Update
+	workers sync.WaitGroup
+
+	mu       sync.RWMutex
+	handlers []updateHandler
+}
+
+// newWebhook returns a webhook accepting requests carrying the secret in the path
+// and in the X-Telegram-Bot-Api-Secret-Token header. Stop it with close.
+func newWebhook(logger *slog.Logger, secret string) (*webhook, error) {
+	if err := validateSecret(secret); err != nil {
+		return nil, err
+	}
+	h := &webhook{
+		logger:  logger,
+		secret:  secret,
+		updates: make(chan tg.Update, webhookQueue),
+	}
+	for range webhookWorkers {
+		h.workers.Add(1)
+		go h.work()
+	}
+	return h, nil
+}
+
+// validateSecret checks the secret is accepted by setWebhook as secret_token.
+func validateSecret(secret string) error {
+	if secret == "" || len(secret) > maxSecretLength {
+		return fmt.Errorf("webhook secret must be 1-%d characters long", maxSecretLength)
+	}
+	for _, ru := range secret {
+		switch {
+		case ru >= 'a' && ru <= 'z', ru >= 'A' && ru <= 'Z', ru >= '0' && ru <= '9', ru == '_', ru == '-':
+		default:
+			return fmt.Errorf("webhook secret may contain only A-Z, a-z, 0-9, _ and -, got %q", ru)
+		}
+	}
+	return nil
+}
+
+// handle registers a handler called with every received update.
+func (h *webhook) handle(handler updateHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.handlers = append(h.handlers, handler)
+}
+
+// close waits for the acknowledged updates to be handled.
+// The webhook must not receive requests after it.
+func (h *webhook) close() {
+	close(h.updates)
+	h.workers.Wait()
+}
+
+func (h *webhook) work() {
+	defer h.workers.Done()
+	for update := range h.updates {
+		h.mu.RLock()
+		handlers := h.handlers
+		h.mu.RUnlock()
+
+		for _, handler := range handlers {
+			ctx, cancel := context.WithTimeout(context.Background(), webhookHandlerTimeout)
+			handler(ctx, update)
+			cancel()
+		}
+	}
+}
+
+// ServeHTTP acknowledges the update at once and queues it for the workers,
+// so slow handlers don't make Telegram redeliver it.
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// both secrets are checked, the path one keeps the endpoint hidden from scanners
+	if !h.validSecret(r.PathValue("secret")) {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.validSecret(r.Header.Get(secretTokenHeader)) {
+		h.logger.Warn("webhook request with invalid secret token", "remote", r.RemoteAddr)
+		http.Error(w, "invalid secret token", http.StatusForbidden)
+		return
+	}
+
+	var update tg.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBytes)).Decode(&update); err != nil {
+		h.logger.Error("decode update", "err", err)
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case h.updates <- update:
+		h.logger.Info("update received", "update_id", update.UpdateID)
+		w.WriteHeader(http.StatusOK)
+	default:
+		h.logger.Warn("webhook queue is full", "update_id", update.UpdateID)
+		http.Error(w, "too many updates", http.StatusServiceUnavailable)
+	}
+}
+
+func (h *webhook) validSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(h.secret)) == 1
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/bot"
+	"github.com/ninedraft/daily-bacon/internal/client"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+const defaultPollTimeout = 30 * time.Second
+
+// runBot answers bot commands until SIGINT or SIGTERM.
+func runBot(logger *slog.Logger, args []string) int {
+	flags := flag.NewFlagSet("bot", flag.ExitOnError)
+
+	var (
+		latitude    = flags.Float64("latitude", defaultLatitude, "air quality latitude")
+		longitude   = flags.Float64("longitude", defaultLongitude, "air quality longitude")
+		place       = flags.String("place", "", "name of the place shown in replies")
+		statePath   = flags.String("state", "", "path to the state file keeping the updates offset (disabled if empty)")
+		pollTimeout = flags.Duration("poll-timeout", defaultPollTimeout, "long polling timeout")
+	)
+	_ = flags.Parse(args)
+
+	tgOpts, err := telegramOptions()
+	if err != nil {
+		logger.Error("setup token", slog.Any("err", err))
+		return exitSetup
+	}
+
+	var store *state.Store
+	if *statePath != "" {
+		store, err = state.Open(*statePath, 0)
+		if err != nil {
+			logger.Error("open state", slog.Any("err", err))
+			return exitSetup
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	b := bot.New(bot.Config{
+		Client:  tg.New(append(tgOpts, tg.WithLogger(logger))...),
+		Fetcher: meteo.New(client.New(http.DefaultClient.Transport)),
+		Store:   store,
+		Logger:  logger,
+		Location: bot.Location{
+			Name:      *place,
+			Latitude:  *latitude,
+			Longitude: *longitude,
+		},
+		PollTimeout: *pollTimeout,
+	})
+	if err := b.Run(ctx); err != nil {
+		logger.Error("run bot", slog.Any("err", err))
+		return exitSetup
+	}
+
+	logger.Info("bot stopped")
+	return exitOK
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// deferredExpiry is how long after its due time a failing deferred report
+// is retried by later runs.
+const deferredExpiry = 24 * time.Hour
+
+// flushDeferred delivers reports deferred by quiet hours which are due at now,
+// except to the covered groups: the run replaces their reports with its own.
+// Groups in quiet hours again keep their reports until a run replaces them.
+// Delivered reports are dropped from the store, failed ones are retried
+// by the next run until they expire.
+func flushDeferred(logger *slog.Logger, store *state.Store, cfg deliveryConfig, posts poster, quietHours perGroup[*delivery.QuietHours], covered []string, now time.Time) []delivery.Result {
+	due := map[string]state.Deferred{}
+	var groupIDs []string
+	for _, post := range store.DueDeferred(now) {
+		if slices.Contains(covered, post.Chat) {
+			continue
+		}
+		if _, quiet := quietHours.of(post.Chat).Until(now); quiet {
+			continue
+		}
+		if _, ok := due[post.Chat]; !ok {
+			groupIDs = append(groupIDs, post.Chat)
+		}
+		due[post.Chat] = post
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+
+	logger.Info("delivering deferred reports", slog.Any("chats", groupIDs))
+	results := cfg.deliver(groupIDs, func(ctx context.Context, groupID string) error {
+		post := due[groupID]
+		notification := delivery.Notification{Silent: post.Silent, Pin: post.Pin}
+		return posts.post(ctx, groupID, post.Text, notification, postOptions(post)...)
+	})
+
+	for _, res := range results {
+		post := due[res.ChatID]
+		recordDelivery(logger, store, res, post.Text)
+		if res.Err != nil {
+			logger.Error("send deferred report", slog.String("chat", res.ChatID), slog.Int("attempts", res.Attempts), slog.Any("err", res.Err))
+			if now.Sub(post.Due) < deferredExpiry {
+				continue
+			}
+			logger.Warn("deferred report expired", slog.String("chat", res.ChatID), slog.Time("due", post.Due))
+		}
+		if _, err := store.DeleteDeferred(post.ID); err != nil {
+			logger.Error("delete deferred report", slog.String("chat", res.ChatID), slog.Any("err", err))
+		}
+	}
+	return results
+}
+
+// deferReports saves the report for the groups until the end of their quiet
+// hours, replacing reports deferred to them by earlier runs. It returns
+// failed results of groups whose report was not saved.
+func deferReports(logger *slog.Logger, store *state.Store, post state.Deferred, batch deferredGroups, policies perGroup[delivery.Policy], level meteo.Level) []delivery.Result {
+	logger.Info("quiet hours, deferred", slog.Time("until", batch.until), slog.Any("chats", batch.groupIDs))
+
+	var failed []delivery.Result
+	for _, groupID := range batch.groupIDs {
+		notification := policies.of(groupID).Notify(level)
+		post.Chat, post.Due = groupID, batch.until
+		post.Silent, post.Pin = notification.Silent, notification.Pin
+		if _, err := store.ReplaceDeferred(post); err != nil {
+			logger.Error("save deferred report", slog.String("chat", groupID), slog.Any("err", err))
+			failed = append(failed, delivery.Result{ChatID: groupID, Attempts: 1, Started: time.Now(), Err: err})
+		}
+	}
+	return failed
+}
+
+// postOptions returns the entities and keyboard of the report.
+func postOptions(post state.Deferred) []tg.SendOption {
+	opts := []tg.SendOption{tg.WithEntities(post.Entities)}
+	if post.Keyboard != nil {
+		opts = append(opts, tg.WithKeyboard(*post.Keyboard))
+	}
+	return opts
+}
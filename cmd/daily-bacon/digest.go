@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/client"
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/digest"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/view"
+)
+
+var digestVars = append([]string{
+	meteo.PM2_5,
+	meteo.PM10,
+	meteo.Dust,
+	meteo.Ozone,
+	meteo.NitrogenDioxide,
+	meteo.SulphurDioxide,
+}, digest.Pollen...)
+
+func runDigest(logger *slog.Logger, args []string) int {
+	result := newRunResult("digest")
+	flags := flag.NewFlagSet("digest", flag.ExitOnError)
+
+	var (
+		periodName = flags.String("period", string(digest.Week), "digest period: week or month")
+		latitude   = flags.Float64("latitude", defaultLatitude, "air quality latitude")
+		longitude  = flags.Float64("longitude", defaultLongitude, "air quality longitude")
+		timezone   = flags.String("timezone", "auto", "timezone of the digest days")
+		timeout    = flags.Duration("timeout", defaultTimeout, "air quality request timeout")
+		statePath  = flags.String("state", "", "read archived reports from the state file instead of fetching history")
+		withChart  = flags.Bool("chart", true, "attach PNG chart")
+		report     = flags.String("report", "", "write JSON run report to the file")
+	)
+
+	var groupIDs []string
+	bindGroupIDs(flags, &groupIDs)
+
+	var deliveryCfg deliveryConfig
+	bindDeliveryFlags(flags, &deliveryCfg)
+
+	_ = flags.Parse(args)
+	deliveryCfg.engine = delivery.New(deliveryCfg.options)
+
+	period, err := digest.ParsePeriod(*periodName)
+	if err != nil {
+		logger.Error("parse period", slog.Any("err", err))
+		result.fail(exitSetup, err)
+		return result.finish(logger, *report)
+	}
+
+	tgOpts, err := telegramOptions()
+	if err != nil {
+		logger.Error("setup token", slog.Any("err", err))
+		result.fail(exitSetup, err)
+		return result.finish(logger, *report)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var summary digest.Summary
+	if *statePath != "" {
+		summary, err = archivedDigest(*statePath, period)
+	} else {
+		summary, err = fetchedDigest(ctx, period, meteo.Params{
+			Latitude:     *latitude,
+			Longitude:    *longitude,
+			Hourly:       digestVars,
+			Timezone:     *timezone,
+			PastDays:     period.Days(),
+			ForecastDays: 1,
+		})
+	}
+	if err != nil {
+		logger.Error("build digest", slog.Any("err", err))
+		result.fail(exitFetch, err)
+		return result.finish(logger, *report)
+	}
+
+	var buf bytes.Buffer
+	if err := view.Digest(&buf, summary); err != nil {
+		logger.Error("format digest", slog.Any("err", err))
+		result.fail(exitRender, err)
+		return result.finish(logger, *report)
+	}
+	msg := buf.String()
+
+	var chart []byte
+	if *withChart {
+		var chartBuf bytes.Buffer
+		if err := view.DigestChart(&chartBuf, summary); err != nil {
+			logger.Warn("render chart", slog.Any("err", err))
+		} else {
+			chart = chartBuf.Bytes()
+		}
+	}
+
+	// the chart is uploaded once and reused by file_id for other chats
+	tgClient := tg.New(append(tgOpts,
+		tg.WithLogger(logger),
+		tg.WithMigrationHandler(logMigration(logger)),
+		tg.WithFileCache(tg.NewFileCache()))...)
+	results := deliveryCfg.deliver(groupIDs, func(ctx context.Context, chatID string) error {
+		chatID, opts := groupTarget(chatID)
+		return sendDigest(ctx, tgClient, chatID, msg, chart, opts...)
+	})
+	for _, res := range results {
+		if res.Err != nil {
+			logger.Error("send digest", slog.String("chat", res.ChatID), slog.Int("attempts", res.Attempts), slog.Any("err", res.Err))
+		}
+		result.addChat(res)
+	}
+
+	logger.Info("digest built", slog.String("period", string(period)), slog.Int("days", len(summary.Days)))
+	return result.finish(logger, *report)
+}
+
+func fetchedDigest(ctx context.Context, period digest.Period, params meteo.Params) (digest.Summary, error) {
+	meteoClient := meteo.New(client.New(http.DefaultClient.Transport))
+	resp, err := meteoClient.AirQuality(ctx, params)
+	if err != nil {
+		return digest.Summary{}, fmt.Errorf("fetch air quality: %w", err)
+	}
+
+	samples, err := digest.FromHourly(resp)
+	if err != nil {
+		return digest.Summary{}, fmt.Errorf("read hourly series: %w", err)
+	}
+
+	loc := time.FixedZone(resp.Timezone, resp.UTCOffsetSeconds)
+	return digest.Summarize(period, today(loc), digestVars, samples, meteo.HourlyUnits(resp.HourlyUnits)), nil
+}
+
+func archivedDigest(path string, period digest.Period) (digest.Summary, error) {
+	store, err := state.Open(path, 0)
+	if err != nil {
+		return digest.Summary{}, fmt.Errorf("open state: %w", err)
+	}
+
+	to := today(time.Local)
+	units := map[string]string{}
+	var samples []digest.Sample
+	for _, report := range store.Reports(to.AddDate(0, 0, -period.Days())) {
+		if report.Response.Current == nil {
+			continue
+		}
+		samples = append(samples, digest.Sample{
+			Time:   report.FetchedAt.In(time.Local),
+			Values: meteo.Values(report.Response.Current),
+		})
+		for key, unit := range meteo.CurrentUnits(report.Response.CurrentUnits) {
+			if unit != "" {
+				units[key] = unit
+			}
+		}
+	}
+	return digest.Summarize(period, to, digestVars, samples, units), nil
+}
+
+func sendDigest(ctx context.Context, tgClient *tg.Client, chatID, msg string, chart []byte, opts ...tg.SendOption) error {
+	if len(chart) == 0 {
+		_, err := tgClient.SendText(ctx, chatID, msg, opts...)
+		return err
+	}
+
+	caption := msg
+	if tg.UTF16Len(msg) > tg.MaxCaptionLength {
+		caption = ""
+	}
+	_, err := tgClient.SendPhoto(ctx, chatID, tg.MediaUpload{
+		FileName:    "digest.png",
+		Reader:      bytes.NewReader(chart),
+		ContentType: "image/png",
+		Caption:     caption,
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	if caption == "" {
+		_, err = tgClient.SendText(ctx, chatID, msg, opts...)
+	}
+	return err
+}
+
+func today(loc *time.Location) time.Time {
+	year, month, day := time.Now().In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
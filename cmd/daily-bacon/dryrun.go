@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// errInvalidPayload fails sends of payloads Telegram would reject.
+// It is not retried: the same payload fails every time.
+var errInvalidPayload = errors.New("dry-run: invalid payload")
+
+// dryRunDoer captures Telegram requests instead of sending them.
+// Every payload is validated and printed to out or written to dir.
+type dryRunDoer struct {
+	mu  sync.Mutex
+	out io.Writer
+	dir string
+	seq int
+}
+
+type dryRunPayload struct {
+	Method string            `json:"method"`
+	Fields map[string]string `json:"fields"`
+	Files  []dryRunFile      `json:"files,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+type dryRunFile struct {
+	Field       string `json:"field"`
+	FileName    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Path        string `json:"path,omitempty"`
+
+	data []byte
+}
+
+func (d *dryRunDoer) Do(req *http.Request) (*http.Response, error) {
+	payload, err := readPayload(req)
+	if err != nil {
+		return nil, fmt.Errorf("dry-run: read payload: %w", err)
+	}
+
+	validationErr := validatePayload(payload)
+	if validationErr != nil {
+		payload.Error = validationErr.Error()
+	}
+
+	seq, err := d.write(payload)
+	if err != nil {
+		return nil, fmt.Errorf("dry-run: write payload: %w", err)
+	}
+	if validationErr != nil {
+		return nil, fmt.Errorf("%w %s: %w", errInvalidPayload, payload.Method, validationErr)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"ok":     true,
+		"result": fakeResult(payload, seq),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dry-run: marshal result: %w", err)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// fakeResult builds a plausible Bot API result for the payload.
+func fakeResult(payload dryRunPayload, seq int) any {
+	chatID, _ := strconv.ParseInt(payload.Fields["chat_id"], 10, 64)
+	threadID, _ := strconv.Atoi(payload.Fields["message_thread_id"])
+	msg := tg.Message{
+		MessageID:       seq,
+		MessageThreadID: threadID,
+		Date:            time.Now().Unix(),
+		Chat:            tg.Chat{ID: chatID},
+		Text:            payload.Fields["text"],
+		Caption:         payload.Fields["caption"],
+	}
+	if payload.Method != "sendMediaGroup" {
+		return msg
+	}
+	msgs := make([]tg.Message, len(payload.Files))
+	for i := range msgs {
+		msgs[i] = msg
+		msgs[i].MessageID = seq*tg.MaxMediaGroup + i
+	}
+	return msgs
+}
+
+func (d *dryRunDoer) write(payload dryRunPayload) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+
+	if d.dir == "" {
+		enc := json.NewEncoder(d.out)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return d.seq, enc.Encode(payload)
+	}
+
+	prefix := fmt.Sprintf("%03d-%s-%s", d.seq, payload.Method, sanitizeFileName(payload.Fields["chat_id"]))
+	for i, file := range payload.Files {
+		name := prefix + "-" + sanitizeFileName(file.FileName)
+		if err := os.WriteFile(filepath.Join(d.dir, name), file.data, 0o600); err != nil {
+			return d.seq, err
+		}
+		payload.Files[i].Path = name
+	}
+
+	raw, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return d.seq, err
+	}
+	return d.seq, os.WriteFile(filepath.Join(d.dir, prefix+".json"), raw, 0o600)
+}
+
+func readPayload(req *http.Request) (dryRunPayload, error) {
+	payload := dryRunPayload{
+		Method: path.Base(req.URL.Path),
+		Fields: map[string]string{},
+	}
+	if req.Body == nil {
+		return payload, nil
+	}
+	defer func() { _ = req.Body.Close() }()
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return payload, fmt.Errorf("parse content type: %w", err)
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			return payload, fmt.Errorf("parse form: %w", err)
+		}
+		for key := range req.PostForm {
+			payload.Fields[key] = req.PostForm.Get(key)
+		}
+	case "multipart/form-data":
+		reader := multipart.NewReader(req.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return payload, fmt.Errorf("read part: %w", err)
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return payload, fmt.Errorf("read part %s: %w", part.FormName(), err)
+			}
+			if part.FileName() == "" {
+				payload.Fields[part.FormName()] = string(data)
+				continue
+			}
+			payload.Files = append(payload.Files, dryRunFile{
+				Field:       part.FormName(),
+				FileName:    part.FileName(),
+				ContentType: part.Header.Get("Content-Type"),
+				Size:        len(data),
+				data:        data,
+			})
+		}
+	default:
+		return payload, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	return payload, nil
+}
+
+func validatePayload(payload dryRunPayload) error {
+	var errs []error
+	if payload.Fields["chat_id"] == "" {
+		errs = append(errs, errors.New("chat_id is empty"))
+	}
+	if mode := payload.Fields["parse_mode"]; mode != "" && !tg.IsParseMode(mode) {
+		errs = append(errs, fmt.Errorf("unknown parse_mode %q", mode))
+	}
+	if markup := payload.Fields["reply_markup"]; markup != "" {
+		errs = append(errs, validateKeyboard(markup)...)
+	}
+
+	switch payload.Method {
+	case "pinChatMessage":
+		errs = append(errs, validateMessageID(payload)...)
+	case "editMessageText":
+		errs = append(errs, validateMessageID(payload)...)
+		fallthrough
+	case "sendMessage":
+		text := payload.Fields["text"]
+		switch n := tg.UTF16Len(text); {
+		case strings.TrimSpace(text) == "":
+			errs = append(errs, errors.New("text is empty"))
+		case n > tg.MaxMessageLength:
+			errs = append(errs, fmt.Errorf("text is %d UTF-16 units long, limit is %d", n, tg.MaxMessageLength))
+		}
+		errs = append(errs, validateEntities("entities", payload.Fields["entities"], text)...)
+	case "sendMediaGroup":
+		errs = append(errs, validateMediaGroup(payload)...)
+	case "sendPhoto", "sendDocument", "sendVideo", "sendAudio", "sendAnimation":
+		errs = append(errs, validateFile(payload)...)
+	default:
+		errs = append(errs, fmt.Errorf("unexpected method %q", payload.Method))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateKeyboard(markup string) []error {
+	var keyboard tg.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(markup), &keyboard); err != nil {
+		return []error{fmt.Errorf("parse reply_markup: %w", err)}
+	}
+
+	var errs []error
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			switch {
+			case strings.TrimSpace(button.Text) == "":
+				errs = append(errs, errors.New("button text is empty"))
+			case button.CallbackData == "" && button.URL == "":
+				errs = append(errs, fmt.Errorf("button %q has no action", button.Text))
+			case len(button.CallbackData) > tg.MaxCallbackData:
+				errs = append(errs, fmt.Errorf("button %q: callback data is %d bytes long, limit is %d",
+					button.Text, len(button.CallbackData), tg.MaxCallbackData))
+			}
+		}
+	}
+	return errs
+}
+
+// validateEntities checks that entities in the field are within the text.
+func validateEntities(field, raw, text string) []error {
+	if raw == "" {
+		return nil
+	}
+	var entities []tg.MessageEntity
+	if err := json.Unmarshal([]byte(raw), &entities); err != nil {
+		return []error{fmt.Errorf("parse %s: %w", field, err)}
+	}
+
+	var errs []error
+	n := tg.UTF16Len(text)
+	for i, entity := range entities {
+		if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > n {
+			errs = append(errs, fmt.Errorf("%s %d: [%d, %d) is out of the text of %d UTF-16 units",
+				field, i, entity.Offset, entity.Offset+entity.Length, n))
+		}
+	}
+	return errs
+}
+
+func validateMessageID(payload dryRunPayload) []error {
+	if id, err := strconv.Atoi(payload.Fields["message_id"]); err != nil || id <= 0 {
+		return []error{fmt.Errorf("invalid message_id %q", payload.Fields["message_id"])}
+	}
+	return nil
+}
+
+func validateFile(payload dryRunPayload) []error {
+	var errs []error
+	field := strings.ToLower(strings.TrimPrefix(payload.Method, "send"))
+	if payload.Fields[field] == "" && !payload.attached(field) {
+		errs = append(errs, fmt.Errorf("%s is missing", field))
+	}
+	if n := tg.UTF16Len(payload.Fields["caption"]); n > tg.MaxCaptionLength {
+		errs = append(errs, fmt.Errorf("caption is %d UTF-16 units long, limit is %d", n, tg.MaxCaptionLength))
+	}
+	errs = append(errs, validateEntities("caption_entities", payload.Fields["caption_entities"], payload.Fields["caption"])...)
+	if thumbnail, ok := strings.CutPrefix(payload.Fields["thumbnail"], "attach://"); ok && !payload.attached(thumbnail) {
+		errs = append(errs, fmt.Errorf("thumbnail attachment %q is missing", thumbnail))
+	}
+	return errs
+}
+
+func validateMediaGroup(payload dryRunPayload) []error {
+	var media []struct {
+		Type            string          `json:"type"`
+		Media           string          `json:"media"`
+		Caption         string          `json:"caption"`
+		CaptionEntities json.RawMessage `json:"caption_entities"`
+		Thumbnail       string          `json:"thumbnail"`
+	}
+	if err := json.Unmarshal([]byte(payload.Fields["media"]), &media); err != nil {
+		return []error{fmt.Errorf("parse media: %w", err)}
+	}
+
+	var errs []error
+	types := make([]string, len(media))
+	for i, item := range media {
+		types[i] = item.Type
+	}
+	if err := tg.ValidateMediaGroup(types); err != nil {
+		errs = append(errs, err)
+	}
+	for i, item := range media {
+		if n := tg.UTF16Len(item.Caption); n > tg.MaxCaptionLength {
+			errs = append(errs, fmt.Errorf("media %d: caption is %d UTF-16 units long, limit is %d", i, n, tg.MaxCaptionLength))
+		}
+		for _, err := range validateEntities("caption_entities", string(item.CaptionEntities), item.Caption) {
+			errs = append(errs, fmt.Errorf("media %d: %w", i, err))
+		}
+		for _, ref := range []string{item.Media, item.Thumbnail} {
+			field, ok := strings.CutPrefix(ref, "attach://")
+			if ok && !payload.attached(field) {
+				errs = append(errs, fmt.Errorf("media %d: attachment %q is missing", i, field))
+			}
+		}
+	}
+	return errs
+}
+
+func (payload dryRunPayload) attached(field string) bool {
+	return slices.ContainsFunc(payload.Files, func(file dryRunFile) bool {
+		return file.Field == field
+	})
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// liveMessage keeps one pinned message per chat, edited on every run.
+type liveMessage struct {
+	logger *slog.Logger
+	client *tg.Client
+	store  *state.Store
+}
+
+// update edits the live message of the group. If there is none yet or it
+// was deleted, a new message is posted, pinned and remembered in the store.
+// Edits don't notify anyone, so notifications with Pin always post a new
+// message pinned with a notification.
+func (l *liveMessage) update(ctx context.Context, groupID, msg string, notification delivery.Notification, extra ...tg.SendOption) error {
+	chatID, opts := groupTarget(groupID)
+	opts = append(opts, tg.Silent(notification.Silent))
+	opts = append(opts, extra...)
+
+	if l.store != nil && !notification.Pin {
+		if chat, ok := l.store.LastMessage(groupID); ok && chat.LiveMessageID != 0 {
+			_, err := l.client.EditMessageText(ctx, chatID, chat.LiveMessageID, msg, extra...)
+			switch {
+			case err == nil, errors.Is(err, tg.ErrMessageNotModified):
+				return nil
+			case !errors.Is(err, tg.ErrMessageNotFound):
+				return err
+			}
+			l.logger.Info("live message is gone, posting a new one",
+				slog.String("chat", groupID), slog.Int("message_id", chat.LiveMessageID))
+		}
+	}
+
+	sent, err := l.client.SendMessage(ctx, chatID, msg, opts...)
+	if err != nil {
+		return err
+	}
+
+	if l.store != nil {
+		if err := l.store.SetLiveMessage(groupID, sent.MessageID); err != nil {
+			l.logger.Error("save live message", slog.String("chat", groupID), slog.Any("err", err))
+		}
+	}
+	delivery.Pin(ctx, l.logger, l.client, chatID, sent.MessageID, notification.Pin)
+	return nil
+}
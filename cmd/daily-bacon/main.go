@@ -1,19 +1,26 @@
 package main
 
 import (
-	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 
+	"github.com/ninedraft/daily-bacon/internal/bot"
 	"github.com/ninedraft/daily-bacon/internal/client"
+	"github.com/ninedraft/daily-bacon/internal/delivery"
 	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/redact"
+	"github.com/ninedraft/daily-bacon/internal/state"
 	"github.com/ninedraft/daily-bacon/internal/tg"
 	"github.com/ninedraft/daily-bacon/internal/view"
 )
@@ -22,46 +29,123 @@ const (
 	defaultLatitude  = 34.707130
 	defaultLongitude = 33.022617
 	defaultTimeout   = 10 * time.Second
+
+	defaultDeliveryTimeout = time.Minute
 )
 
 func main() {
-	start := time.Now()
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger := newLogger(os.Stdout)
+
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var code int
+	switch command {
+	case "digest":
+		code = runDigest(logger, os.Args[2:])
+	case "bot":
+		code = runBot(logger, os.Args[2:])
+	default:
+		code = runDaily(logger)
+	}
+	os.Exit(code)
+}
+
+// newLogger returns a logger writing to w with the bot token masked.
+func newLogger(w io.Writer) *slog.Logger {
+	// commands failing to read the token report it themselves,
+	// anything looking like a bot token is masked anyway
+	token, _ := readToken()
+	return slog.New(redact.New(token).Handler(slog.NewTextHandler(w, nil)))
+}
+
+func runDaily(logger *slog.Logger) int {
+	result := newRunResult("daily")
 
 	var (
 		latitude  = flag.Float64("latitude", defaultLatitude, "air quality latitude")
 		longitude = flag.Float64("longitude", defaultLongitude, "air quality longitude")
-		timeout   = flag.Duration("timeout", defaultTimeout, "request timeout")
+		timeout   = flag.Duration("timeout", defaultTimeout, "air quality request timeout")
+		statePath = flag.String("state", "", "path to the state file (disabled if empty)")
+		retention = flag.Duration("state-retention", state.DefaultRetention, "how long to keep records in the state file")
+		dryRun    = flag.Bool("dry-run", false, "print telegram payloads instead of sending them")
+		dryRunDir = flag.String("dry-run-dir", "", "write dry-run payloads and attachments to the directory instead of stdout")
+		report    = flag.String("report", "", "write JSON run report to the file")
+		live      = flag.Bool("live", false, "keep one pinned message per chat and edit it on every run (requires -state)")
+		keyboard  = flag.Bool("keyboard", false, "attach report navigation buttons, handled by a running daily-bacon bot")
 	)
 
 	var groupIDs []string
-	flag.Func("group-id", "telegram group id (can be set multiple times, comma/space/| separated)", func(value string) error {
-		fields := strings.FieldsFuncSeq(value, flagSliceField)
-		for field := range fields {
-			if field != "" {
-				groupIDs = append(groupIDs, field)
-			}
-		}
-		return nil
-	})
+	bindGroupIDs(flag.CommandLine, &groupIDs)
+
+	var deliveryCfg deliveryConfig
+	bindDeliveryFlags(flag.CommandLine, &deliveryCfg)
+
+	policies := perGroup[delivery.Policy]{all: delivery.DefaultPolicy}
+	bindPerGroup(flag.CommandLine, "notify", "delivery by the worst level as audible[:pinned] levels, e.g. watch or good:act-now", &policies, delivery.ParsePolicy)
+
+	var quietHours perGroup[*delivery.QuietHours]
+	bindPerGroup(flag.CommandLine, "quiet-hours", `daily window in the group's timezone when only Act Now reports are sent right away and others are deferred to its end (requires -state), e.g. "22:00-07:00 Europe/Berlin"`, &quietHours, delivery.ParseQuietHours)
 
 	flag.Parse()
+	deliveryCfg.engine = delivery.New(deliveryCfg.options)
 
-	tokenFile := os.Getenv("TELEGRAM_TOKEN_FILE")
-	if tokenFile == "" {
-		logger.Error("TELEGRAM_TOKEN_FILE is not set")
-		return
+	var (
+		tgOpts = []tg.Option{tg.WithLogger(logger), tg.WithMigrationHandler(logMigration(logger))}
+		store  *state.Store
+	)
+	if *dryRun {
+		tgOpts = append(tgOpts,
+			tg.WithToken("dry-run"),
+			tg.WithDoer(&dryRunDoer{out: os.Stdout, dir: *dryRunDir}))
+		if *statePath != "" {
+			logger.Info("dry run: state file is ignored")
+			*statePath = ""
+		}
+	} else {
+		opts, err := telegramOptions()
+		if err != nil {
+			logger.Error("setup token", slog.Any("err", err))
+			result.fail(exitSetup, err)
+			return result.finish(logger, *report)
+		}
+		tgOpts = append(tgOpts, opts...)
 	}
 
-	tokenBytes, err := os.ReadFile(tokenFile)
-	if err != nil {
-		logger.Error("read token file", slog.Any("err", err))
-		return
+	var err error
+	if *statePath != "" {
+		store, err = state.Open(*statePath, *retention)
+		if err != nil {
+			logger.Error("open state", slog.Any("err", err))
+			result.fail(exitSetup, err)
+			return result.finish(logger, *report)
+		}
+	} else if *live && !*dryRun {
+		err := errors.New("-live requires -state to remember message IDs")
+		logger.Error("setup live message", slog.Any("err", err))
+		result.fail(exitSetup, err)
+		return result.finish(logger, *report)
+	} else if (quietHours.all != nil || len(quietHours.groups) > 0) && !*dryRun {
+		err := errors.New("-quiet-hours requires -state to keep deferred reports")
+		logger.Error("setup quiet hours", slog.Any("err", err))
+		result.fail(exitSetup, err)
+		return result.finish(logger, *report)
 	}
-	token := strings.TrimSpace(string(tokenBytes))
-	if err := os.Setenv("TELEGRAM_TOKEN", token); err != nil {
-		logger.Error("set TELEGRAM_TOKEN", slog.Any("err", err))
-		return
+
+	tgClient := tg.New(tgOpts...)
+	posts := poster{logger: logger, client: tgClient}
+	if *live {
+		posts.live = &liveMessage{logger: logger, client: tgClient, store: store}
+	}
+
+	// groups of this run get its fresh report, deferred reports are only
+	// delivered to groups of other runs sharing the state file
+	if store != nil {
+		for _, res := range flushDeferred(logger, store, deliveryCfg, posts, quietHours, groupIDs, time.Now()) {
+			result.addChat(res)
+		}
 	}
 
 	params := meteo.Params{
@@ -92,31 +176,276 @@ func main() {
 	resp, err := meteoClient.AirQuality(ctx, params)
 	if err != nil {
 		logger.Error("fetch air quality", slog.Any("err", err))
-		return
+		result.fail(exitFetch, err)
+		return result.finish(logger, *report)
+	}
+	logger.Info("fetched", slog.Duration("fetch", time.Since(fetchStart)))
+
+	if store != nil {
+		fetched := state.Report{
+			FetchedAt: fetchStart,
+			Latitude:  params.Latitude,
+			Longitude: params.Longitude,
+			Response:  resp,
+		}
+		if err := store.AddReport(fetched); err != nil {
+			logger.Error("save report", slog.Any("err", err))
+		}
+	}
+
+	level := meteo.Worst(meteo.Values(resp.Current))
+	logger.Info("worst level", slog.String("level", level.String()))
+
+	text := view.AirQualityText(resp)
+	msg := text.String()
+
+	post := state.Deferred{Text: msg, Entities: text.Entities()}
+	if *keyboard {
+		markup, err := bot.Keyboard(bot.Location{Latitude: *latitude, Longitude: *longitude}, bot.ReportNow)
+		if err != nil {
+			logger.Error("build keyboard", slog.Any("err", err))
+			result.fail(exitRender, err)
+			return result.finish(logger, *report)
+		}
+		post.Keyboard = &markup
+	}
+
+	send := func(ctx context.Context, groupID string) error {
+		return posts.post(ctx, groupID, msg, policies.of(groupID).Notify(level), postOptions(post)...)
+	}
+
+	ready, deferred := splitQuiet(groupIDs, quietHours, level, time.Now())
+	if store != nil {
+		// reports deferred by earlier runs are older than this one
+		for _, groupID := range ready {
+			if _, err := store.DeleteDeferredTo(groupID); err != nil {
+				logger.Error("delete deferred report", slog.String("chat", groupID), slog.Any("err", err))
+			}
+		}
+	}
+	results := deliveryCfg.deliver(ready, send)
+	for _, batch := range deferred {
+		if *dryRun {
+			logger.Info("dry run: not deferring until the end of quiet hours", slog.Time("until", batch.until), slog.Any("chats", batch.groupIDs))
+			results = append(results, deliveryCfg.deliver(batch.groupIDs, send)...)
+			continue
+		}
+		for _, res := range deferReports(logger, store, post, batch, policies, level) {
+			result.addChat(res)
+		}
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			logger.Error("send message", slog.String("chat", res.ChatID), slog.Int("attempts", res.Attempts), slog.Any("err", res.Err))
+		}
+		result.addChat(res)
+		recordDelivery(logger, store, res, msg)
+	}
+
+	if store != nil {
+		if err := store.Compact(time.Now()); err != nil {
+			logger.Error("compact state", slog.Any("err", err))
+		}
 	}
-	fetchDur := time.Since(fetchStart)
 
-	var buf bytes.Buffer
-	if err := view.AirQuality(&buf, resp); err != nil {
-		logger.Error("format air quality", slog.Any("err", err))
+	return result.finish(logger, *report)
+}
+
+func recordDelivery(logger *slog.Logger, store *state.Store, res delivery.Result, msg string) {
+	if store == nil {
 		return
 	}
-	msg := buf.String()
+	attempt := state.Delivery{ChatID: res.ChatID, At: res.Started}
+	if res.Err != nil {
+		attempt.Error = res.Err.Error()
+	}
+	if err := store.AddDelivery(attempt, msg); err != nil {
+		logger.Error("save delivery", slog.String("chat", res.ChatID), slog.Any("err", err))
+	}
+}
 
-	tgClient := tg.New(http.DefaultClient)
-	var wg sync.WaitGroup
-	wg.Add(len(groupIDs))
-	for _, id := range groupIDs {
-		go func(id string) {
-			defer wg.Done()
-			if err := tgClient.SendMessage(ctx, id, msg); err != nil {
-				logger.Error("send message", slog.String("chat", id), slog.Any("err", err))
+// poster delivers reports to groups, as live messages with -live.
+type poster struct {
+	logger *slog.Logger
+	client *tg.Client
+	live   *liveMessage
+}
+
+func (p poster) post(ctx context.Context, groupID, msg string, notification delivery.Notification, extra ...tg.SendOption) error {
+	if p.live != nil {
+		return p.live.update(ctx, groupID, msg, notification, extra...)
+	}
+	chatID, opts := groupTarget(groupID)
+	opts = append(opts, tg.Silent(notification.Silent))
+	sent, err := p.client.SendText(ctx, chatID, msg, append(opts, extra...)...)
+	if err != nil {
+		return err
+	}
+	notification.PinAlert(ctx, p.logger, p.client, chatID, sent[0].MessageID)
+	return nil
+}
+
+// logMigration tells the operator how to fix group IDs of upgraded groups.
+// Messages are still delivered to the new chat in the meantime.
+func logMigration(logger *slog.Logger) tg.MigrateFunc {
+	return func(from, to string) {
+		logger.Warn("group was upgraded to a supergroup, update the -group-id flag",
+			slog.String("from", from),
+			slog.String("to", to),
+			slog.String("fix", fmt.Sprintf("replace -group-id %s with -group-id %s", from, to)))
+	}
+}
+
+type deliveryConfig struct {
+	timeout time.Duration
+	options delivery.Options
+	// engine is built from options after parsing flags and shared by
+	// all deliveries of the process, so they share the rate limits.
+	engine *delivery.Engine
+}
+
+func bindDeliveryFlags(flags *flag.FlagSet, cfg *deliveryConfig) {
+	flags.DurationVar(&cfg.timeout, "delivery-timeout", defaultDeliveryTimeout, "deadline for delivering to all chats")
+	flags.IntVar(&cfg.options.Workers, "workers", 8, "number of chats served concurrently")
+	flags.IntVar(&cfg.options.MaxAttempts, "max-attempts", 3, "attempts of every Telegram API call")
+}
+
+// deliver sends to all chats within the delivery deadline,
+// independent from the fetch timeout.
+func (cfg deliveryConfig) deliver(chatIDs []string, send delivery.SendFunc) []delivery.Result {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	return cfg.engine.Deliver(ctx, chatIDs, send)
+}
+
+func bindGroupIDs(flags *flag.FlagSet, groupIDs *[]string) {
+	flags.Func("group-id", "telegram group id with optional forum topic, e.g. -100123:45 (can be set multiple times, comma/space/| separated)", func(value string) error {
+		fields := strings.FieldsFuncSeq(value, flagSliceField)
+		for field := range fields {
+			if field == "" {
+				continue
+			}
+			if _, err := tg.ParseTarget(field); err != nil {
+				return err
+			}
+			*groupIDs = append(*groupIDs, field)
+		}
+		return nil
+	})
+}
+
+// perGroup is a setting of all groups, overridden for some of them.
+type perGroup[T any] struct {
+	all    T
+	groups map[string]T
+}
+
+// bindPerGroup binds a flag setting a value for all groups, or for one group like -100123:45=value.
+func bindPerGroup[T any](flags *flag.FlagSet, name, usage string, setting *perGroup[T], parse func(string) (T, error)) {
+	usage += ", for all groups or one group like -100123:45=value (can be set multiple times)"
+	flags.Func(name, usage, func(value string) error {
+		groupID, spec, ok := strings.Cut(value, "=")
+		if !ok {
+			parsed, err := parse(value)
+			if err != nil {
+				return err
 			}
-		}(id)
+			setting.all = parsed
+			return nil
+		}
+		if _, err := tg.ParseTarget(groupID); err != nil {
+			return err
+		}
+		parsed, err := parse(spec)
+		if err != nil {
+			return err
+		}
+		if setting.groups == nil {
+			setting.groups = map[string]T{}
+		}
+		setting.groups[groupID] = parsed
+		return nil
+	})
+}
+
+// of returns the value of the group.
+func (p perGroup[T]) of(groupID string) T {
+	if value, ok := p.groups[groupID]; ok {
+		return value
+	}
+	return p.all
+}
+
+// deferredGroups wait for the end of their quiet hours.
+type deferredGroups struct {
+	until    time.Time
+	groupIDs []string
+}
+
+// splitQuiet returns groups ready for a report with the level and groups
+// in quiet hours, ordered by the end of the hours.
+func splitQuiet(groupIDs []string, quietHours perGroup[*delivery.QuietHours], level meteo.Level, now time.Time) ([]string, []deferredGroups) {
+	var (
+		ready    []string
+		deferred []deferredGroups
+	)
+	for _, groupID := range groupIDs {
+		until, ok := quietHours.of(groupID).Defer(now, level)
+		if !ok {
+			ready = append(ready, groupID)
+			continue
+		}
+		i := slices.IndexFunc(deferred, func(batch deferredGroups) bool { return batch.until.Equal(until) })
+		if i < 0 {
+			deferred = append(deferred, deferredGroups{until: until})
+			i = len(deferred) - 1
+		}
+		deferred[i].groupIDs = append(deferred[i].groupIDs, groupID)
+	}
+	slices.SortFunc(deferred, func(a, b deferredGroups) int { return a.until.Compare(b.until) })
+	return ready, deferred
+}
+
+// groupTarget splits a group ID validated by bindGroupIDs into chat ID and topic.
+func groupTarget(groupID string) (string, []tg.SendOption) {
+	target, err := tg.ParseTarget(groupID)
+	if err != nil {
+		return groupID, nil
+	}
+	return target.ChatID, []tg.SendOption{tg.InThread(target.ThreadID)}
+}
+
+// telegramOptions reads the bot token from TELEGRAM_TOKEN_FILE
+// and an optional Bot API server URL from TELEGRAM_API_URL.
+func telegramOptions() ([]tg.Option, error) {
+	token, err := readToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return []tg.Option{
+		tg.WithToken(token),
+		tg.WithAPIURL(cmp.Or(os.Getenv("TELEGRAM_API_URL"), tg.DefaultAPIURL)),
+	}, nil
+}
+
+// readToken reads the bot token from the file set by TELEGRAM_TOKEN_FILE.
+func readToken() (string, error) {
+	tokenFile := os.Getenv("TELEGRAM_TOKEN_FILE")
+	if tokenFile == "" {
+		return "", errors.New("TELEGRAM_TOKEN_FILE is not set")
 	}
-	wg.Wait()
 
-	logger.Info("done", slog.Duration("fetch", fetchDur), slog.Duration("total", time.Since(start)))
+	tokenBytes, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(tokenBytes))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
 }
 
 func flagSliceField(ru rune) bool {
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
+)
+
+func TestDeferredReports(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	srv := tgtest.NewServer(t)
+	srv.Block("-300")
+	client := tg.New(tg.WithToken("tok"), tg.WithAPIURL(srv.URL), tg.WithDoer(srv.Client()))
+	posts := poster{logger: logger, client: client}
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	require.NoError(t, err)
+
+	cfg := deliveryConfig{
+		timeout: time.Minute,
+		engine:  delivery.New(delivery.Options{MaxAttempts: 1, GlobalRate: rate.Inf, PerChatRate: rate.Inf}),
+	}
+	window, err := delivery.ParseQuietHours("22:00-07:00 UTC")
+	require.NoError(t, err)
+	quietHours := perGroup[*delivery.QuietHours]{all: window}
+	policies := perGroup[delivery.Policy]{
+		all:    delivery.DefaultPolicy,
+		groups: map[string]delivery.Policy{"-200": {Audible: meteo.LevelGood, Pinned: meteo.LevelWatch}},
+	}
+
+	night := time.Date(2025, 5, 1, 23, 0, 0, 0, time.UTC)
+	morning := time.Date(2025, 5, 2, 7, 0, 0, 0, time.UTC)
+	batch := deferredGroups{until: morning, groupIDs: []string{"-100:7", "-200", "-300"}}
+
+	report := func(text string) state.Deferred {
+		return state.Deferred{Text: text, Entities: []tg.MessageEntity{{Type: "bold", Length: 3}}}
+	}
+	require.Empty(t, deferReports(logger, store, report("old"), batch, policies, meteo.LevelWatch))
+	require.Empty(t, deferReports(logger, store, report("new"), batch, policies, meteo.LevelWatch))
+
+	require.Empty(t, flushDeferred(logger, store, cfg, posts, quietHours, nil, night))
+	require.Empty(t, flushDeferred(logger, store, cfg, posts, quietHours, nil, morning.Add(-time.Minute)), "reports wait for the end of quiet hours")
+	require.Empty(t, srv.Chats())
+
+	// groups of the run get its fresh report instead
+	require.Empty(t, flushDeferred(logger, store, cfg, posts, quietHours, batch.groupIDs, morning))
+	require.Empty(t, srv.Chats())
+
+	results := flushDeferred(logger, store, cfg, posts, quietHours, nil, morning)
+	require.Len(t, results, 3)
+
+	messages := srv.Messages("-100")
+	require.Len(t, messages, 1, "later reports replace deferred ones")
+	require.Equal(t, "new", messages[0].Text)
+	require.Equal(t, 7, messages[0].ThreadID)
+	require.Contains(t, messages[0].Entities, `"type":"bold"`)
+	require.False(t, messages[0].Silent)
+	require.False(t, messages[0].Pinned)
+
+	messages = srv.Messages("-200")
+	require.Len(t, messages, 1)
+	require.True(t, messages[0].Pinned, "reports are delivered by the policy of the group")
+
+	// failed reports are retried by the next runs until they expire
+	due := store.DueDeferred(morning)
+	require.Len(t, due, 1)
+	require.Equal(t, "-300", due[0].Chat)
+
+	require.Len(t, flushDeferred(logger, store, cfg, posts, quietHours, nil, morning.Add(time.Hour)), 1)
+	require.Len(t, store.DueDeferred(morning), 1)
+
+	require.Len(t, flushDeferred(logger, store, cfg, posts, quietHours, nil, morning.Add(deferredExpiry)), 1)
+	require.Empty(t, store.DueDeferred(morning.Add(deferredExpiry)))
+}
+
+// dryRunRequest builds a Bot API request with the fields and files,
+// a multipart one if there are files.
+func dryRunRequest(t *testing.T, method string, fields, files map[string]string) *http.Request {
+	t.Helper()
+	url := "https://api.telegram.org/bottok/" + method
+	if len(files) == 0 {
+		form := neturl.Values{}
+		for key, value := range fields {
+			form.Set(key, value)
+		}
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range fields {
+		require.NoError(t, form.WriteField(key, value))
+	}
+	for field, name := range files {
+		part, err := form.CreateFormFile(field, name)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("data of " + name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestDryRunDoer(t *testing.T) {
+	album := func(media string) map[string]string {
+		return map[string]string{"chat_id": "-100", "media": media}
+	}
+	photos := map[string]string{"file0": "a.png", "file1": "b.png"}
+	keyboard := func(markup string) map[string]string {
+		return map[string]string{"chat_id": "-100", "text": "report", "reply_markup": markup}
+	}
+	tests := []struct {
+		name   string
+		method string
+		fields map[string]string
+		files  map[string]string
+		// errs are parts of the validation error, none for valid payloads
+		errs []string
+	}{
+		{
+			name:   "text",
+			method: "sendMessage",
+			fields: map[string]string{"chat_id": "-100", "text": "report", "parse_mode": "HTML", "entities": `[{"type":"bold","offset":0,"length":6}]`},
+		},
+		{
+			name:   "empty text and chat",
+			method: "sendMessage",
+			fields: map[string]string{"text": " "},
+			errs:   []string{"chat_id is empty", "text is empty"},
+		},
+		{
+			name:   "long text",
+			method: "sendMessage",
+			fields: map[string]string{"chat_id": "-100", "text": strings.Repeat("a", tg.MaxMessageLength+1)},
+			errs:   []string{"text is 4097 UTF-16 units long, limit is 4096"},
+		},
+		{
+			name:   "unknown parse mode",
+			method: "sendMessage",
+			fields: map[string]string{"chat_id": "-100", "text": "report", "parse_mode": "BBCode"},
+			errs:   []string{`unknown parse_mode "BBCode"`},
+		},
+		{
+			name:   "entity out of text",
+			method: "sendMessage",
+			fields: map[string]string{"chat_id": "-100", "text": "report", "entities": `[{"type":"bold","offset":4,"length":3}]`},
+			errs:   []string{"entities 0: [4, 7) is out of the text of 6 UTF-16 units"},
+		},
+		{
+			name:   "broken entities",
+			method: "sendMessage",
+			fields: map[string]string{"chat_id": "-100", "text": "report", "entities": `{`},
+			errs:   []string{"parse entities"},
+		},
+		{
+			name:   "keyboard",
+			method: "sendMessage",
+			fields: keyboard(`{"inline_keyboard":[[{"text":"Forecast","callback_data":"forecast"},{"text":"Map","url":"https://example.com"}]]}`),
+		},
+		{
+			name:   "invalid buttons",
+			method: "sendMessage",
+			fields: keyboard(`{"inline_keyboard":[[{"text":" ","callback_data":"x"},{"text":"Noop"},{"text":"Long","callback_data":"` + strings.Repeat("x", tg.MaxCallbackData+1) + `"}]]}`),
+			errs: []string{
+				"button text is empty",
+				`button "Noop" has no action`,
+				`button "Long": callback data is 65 bytes long, limit is 64`,
+			},
+		},
+		{
+			name:   "broken keyboard",
+			method: "sendMessage",
+			fields: keyboard(`[`),
+			errs:   []string{"parse reply_markup"},
+		},
+		{
+			name:   "album",
+			method: "sendMediaGroup",
+			fields: album(`[{"type":"photo","media":"attach://file0","caption":"report","caption_entities":[{"type":"bold","offset":0,"length":6}]},{"type":"photo","media":"attach://file1"}]`),
+			files:  photos,
+		},
+		{
+			name:   "album of one",
+			method: "sendMediaGroup",
+			fields: album(`[{"type":"photo","media":"attach://file0"}]`),
+			files:  photos,
+			errs:   []string{"media group must have 2-10 uploads, got 1"},
+		},
+		{
+			name:   "mixed album",
+			method: "sendMediaGroup",
+			fields: album(`[{"type":"photo","media":"attach://file0"},{"type":"document","media":"attach://file1"}]`),
+			files:  photos,
+			errs:   []string{tg.ErrMixedMediaGroup.Error()},
+		},
+		{
+			name:   "album with invalid items",
+			method: "sendMediaGroup",
+			fields: album(`[{"type":"photo","media":"attach://file0","caption":"` + strings.Repeat("a", tg.MaxCaptionLength+1) + `"},{"type":"photo","media":"attach://file2","caption":"ab","caption_entities":[{"type":"bold","offset":1,"length":2}]}]`),
+			files:  photos,
+			errs: []string{
+				"media 0: caption is 1025 UTF-16 units long, limit is 1024",
+				"media 1: caption_entities 0: [1, 3) is out of the text of 2 UTF-16 units",
+				`media 1: attachment "file2" is missing`,
+			},
+		},
+		{
+			name:   "broken album",
+			method: "sendMediaGroup",
+			fields: album(`{}`),
+			errs:   []string{"parse media"},
+		},
+		{
+			name:   "photo",
+			method: "sendPhoto",
+			fields: map[string]string{"chat_id": "-100", "photo": "attach://photo", "caption": "report"},
+			files:  map[string]string{"photo": "a.png"},
+		},
+		{
+			name:   "document without file",
+			method: "sendDocument",
+			fields: map[string]string{"chat_id": "-100", "thumbnail": "attach://thumb"},
+			errs:   []string{"document is missing", `thumbnail attachment "thumb" is missing`},
+		},
+		{
+			name:   "pin",
+			method: "pinChatMessage",
+			fields: map[string]string{"chat_id": "-100", "message_id": "7"},
+		},
+		{
+			name:   "pin without message",
+			method: "pinChatMessage",
+			fields: map[string]string{"chat_id": "-100", "message_id": "0"},
+			errs:   []string{`invalid message_id "0"`},
+		},
+		{
+			name:   "unexpected method",
+			method: "sendSticker",
+			fields: map[string]string{"chat_id": "-100"},
+			errs:   []string{`unexpected method "sendSticker"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			doer := &dryRunDoer{out: &out}
+
+			resp, err := doer.Do(dryRunRequest(t, test.method, test.fields, test.files))
+
+			var payload dryRunPayload
+			require.NoError(t, json.Unmarshal(out.Bytes(), &payload), "every payload is printed")
+			require.Equal(t, test.method, payload.Method)
+			require.Len(t, payload.Files, len(test.files))
+
+			if len(test.errs) > 0 {
+				require.ErrorIs(t, err, errInvalidPayload)
+				for _, want := range test.errs {
+					require.ErrorContains(t, err, want)
+					require.Contains(t, payload.Error, want)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Empty(t, payload.Error)
+			defer func() { _ = resp.Body.Close() }()
+
+			var body struct {
+				OK     bool            `json:"ok"`
+				Result json.RawMessage `json:"result"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.True(t, body.OK)
+			require.NotEmpty(t, body.Result)
+		})
+	}
+}
+
+func TestFakeResult(t *testing.T) {
+	payload := dryRunPayload{
+		Method: "sendMessage",
+		Fields: map[string]string{"chat_id": "-100", "message_thread_id": "7", "text": "report"},
+	}
+	msg, ok := fakeResult(payload, 3).(tg.Message)
+	require.True(t, ok)
+	require.Equal(t, 3, msg.MessageID)
+	require.Equal(t, 7, msg.MessageThreadID)
+	require.Equal(t, int64(-100), msg.Chat.ID)
+	require.Equal(t, "report", msg.Text)
+
+	payload = dryRunPayload{
+		Method: "sendMediaGroup",
+		Fields: map[string]string{"chat_id": "-100", "caption": "report"},
+		Files:  []dryRunFile{{Field: "file0"}, {Field: "file1"}},
+	}
+	msgs, ok := fakeResult(payload, 3).([]tg.Message)
+	require.True(t, ok)
+	require.Len(t, msgs, 2)
+	for i, msg := range msgs {
+		require.Equal(t, 3*tg.MaxMediaGroup+i, msg.MessageID, "albums don't reuse identifiers of other messages")
+		require.Equal(t, "report", msg.Caption)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"chart.png":        "chart.png",
+		"-100":             "-100",
+		"../../etc/passwd": ".._.._etc_passwd",
+		`a\b:c`:            "a_b_c",
+		"line\nbreak":      "line_break",
+	}
+	for name, want := range tests {
+		require.Equal(t, want, sanitizeFileName(name), name)
+	}
+}
+
+func TestDryRunDoer_Dir(t *testing.T) {
+	dir := t.TempDir()
+	doer := &dryRunDoer{dir: dir}
+
+	media := `[{"type":"photo","media":"attach://file0"},{"type":"photo","media":"attach://file1"}]`
+	files := map[string]string{"file0": "a.png", "file1": "b:1.png"}
+	resp, err := doer.Do(dryRunRequest(t, "sendMediaGroup", map[string]string{"chat_id": "-100", "media": media}, files))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	_, err = doer.Do(dryRunRequest(t, "sendMessage", map[string]string{"chat_id": "-100:7"}, nil))
+	require.ErrorIs(t, err, errInvalidPayload)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{
+		"001-sendMediaGroup--100-a.png",
+		"001-sendMediaGroup--100-b_1.png",
+		"001-sendMediaGroup--100.json",
+		"002-sendMessage--100_7.json",
+	}, names)
+
+	data, err := os.ReadFile(filepath.Join(dir, "001-sendMediaGroup--100-a.png"))
+	require.NoError(t, err)
+	require.Equal(t, "data of a.png", string(data))
+
+	var payload dryRunPayload
+	raw, err := os.ReadFile(filepath.Join(dir, "001-sendMediaGroup--100.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, &payload))
+	require.Len(t, payload.Files, 2)
+	for _, file := range payload.Files {
+		require.Equal(t, "001-sendMediaGroup--100-"+sanitizeFileName(file.FileName), file.Path)
+		require.Equal(t, len("data of "+file.FileName), file.Size)
+	}
+
+	raw, err = os.ReadFile(filepath.Join(dir, "002-sendMessage--100_7.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, &payload))
+	require.Equal(t, "text is empty", payload.Error, "invalid payloads are written with their error")
+}
+
+func TestRunResult(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	delivered := delivery.Result{ChatID: "-100", Attempts: 1, Duration: 1500 * time.Millisecond}
+	blocked := delivery.Result{
+		ChatID:   "-200",
+		Attempts: 2,
+		Err:      fmt.Errorf("send: %w", &tg.APIError{Method: "sendMessage", Code: 403, Description: "Forbidden: bot was blocked by the user"}),
+	}
+	timeout := delivery.Result{ChatID: "-300", Attempts: 3, Err: context.DeadlineExceeded}
+
+	type chat struct {
+		ChatID     string `json:"chat_id"`
+		OK         bool   `json:"ok"`
+		Error      string `json:"error"`
+		ErrorCode  int    `json:"error_code"`
+		Attempts   int    `json:"attempts"`
+		DurationMS int64  `json:"duration_ms"`
+	}
+	tests := []struct {
+		name      string
+		results   []delivery.Result
+		exitCode  int
+		delivered int
+		failed    int
+		chats     []chat
+	}{
+		{
+			name:      "all delivered",
+			results:   []delivery.Result{delivered},
+			exitCode:  exitOK,
+			delivered: 1,
+			chats:     []chat{{ChatID: "-100", OK: true, Attempts: 1, DurationMS: 1500}},
+		},
+		{
+			name:      "partial",
+			results:   []delivery.Result{delivered, blocked},
+			exitCode:  exitPartialDelivery,
+			delivered: 1,
+			failed:    1,
+			chats: []chat{
+				{ChatID: "-100", OK: true, Attempts: 1, DurationMS: 1500},
+				{ChatID: "-200", Error: blocked.Err.Error(), ErrorCode: 403, Attempts: 2},
+			},
+		},
+		{
+			name:     "all failed",
+			results:  []delivery.Result{blocked, timeout},
+			exitCode: exitDelivery,
+			failed:   2,
+			chats: []chat{
+				{ChatID: "-200", Error: blocked.Err.Error(), ErrorCode: 403, Attempts: 2},
+				{ChatID: "-300", Error: "context deadline exceeded", Attempts: 3},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := newRunResult("daily")
+			for _, res := range test.results {
+				result.addChat(res)
+			}
+			path := filepath.Join(t.TempDir(), "report.json")
+			require.Equal(t, test.exitCode, result.finish(logger, path))
+
+			raw, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(raw, &fields))
+			require.ElementsMatch(t,
+				[]string{"command", "started", "duration_ms", "chats", "delivered", "failed", "exit_code"},
+				slices.Collect(maps.Keys(fields)), "error is omitted without a failure before delivery")
+
+			var report struct {
+				Command   string `json:"command"`
+				Chats     []chat `json:"chats"`
+				Delivered int    `json:"delivered"`
+				Failed    int    `json:"failed"`
+				ExitCode  int    `json:"exit_code"`
+			}
+			require.NoError(t, json.Unmarshal(raw, &report))
+			require.Equal(t, "daily", report.Command)
+			require.Equal(t, test.chats, report.Chats)
+			require.Equal(t, test.delivered, report.Delivered)
+			require.Equal(t, test.failed, report.Failed)
+			require.Equal(t, test.exitCode, report.ExitCode)
+		})
+	}
+}
+
+func TestRunResult_Fail(t *testing.T) {
+	result := newRunResult("daily")
+	result.fail(exitFetch, errors.New("fetch air quality: timeout"))
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.Equal(t, exitFetch, result.finish(slog.New(slog.DiscardHandler), path))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(raw, &report))
+	require.Equal(t, "fetch air quality: timeout", report["error"])
+	require.Equal(t, []any{}, report["chats"], "chats are an empty list, not null")
+	require.InDelta(t, exitFetch, report["exit_code"], 0)
+}
+
+func TestNewLogger(t *testing.T) {
+	const token = "short-token"
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(token+"\n"), 0o600))
+	t.Setenv("TELEGRAM_TOKEN_FILE", tokenFile)
+
+	var out bytes.Buffer
+	newLogger(&out).Error("send "+token, slog.Any("err", fmt.Errorf("post /bot%s/sendMessage", token)))
+	require.NotEmpty(t, out.String())
+	require.NotContains(t, out.String(), token)
+}
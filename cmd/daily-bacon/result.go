@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// Exit codes reported to cron monitoring.
+const (
+	exitOK              = 0
+	exitSetup           = 2
+	exitFetch           = 10
+	exitRender          = 11
+	exitPartialDelivery = 12
+	exitDelivery        = 13
+)
+
+// runResult is a structured outcome of a single run.
+type runResult struct {
+	mu sync.Mutex
+
+	Command    string       `json:"command"`
+	Started    time.Time    `json:"started"`
+	DurationMS int64        `json:"duration_ms"`
+	Error      string       `json:"error,omitempty"`
+	Chats      []chatResult `json:"chats"`
+	Delivered  int          `json:"delivered"`
+	Failed     int          `json:"failed"`
+	ExitCode   int          `json:"exit_code"`
+}
+
+// chatResult is an outcome of delivery to a single chat.
+type chatResult struct {
+	ChatID     string `json:"chat_id"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	ErrorCode  int    `json:"error_code,omitempty"`
+	Attempts   int    `json:"attempts"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func newRunResult(command string) *runResult {
+	return &runResult{
+		Command: command,
+		Started: time.Now(),
+		Chats:   []chatResult{},
+	}
+}
+
+// fail marks the run as failed before delivery.
+func (r *runResult) fail(code int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ExitCode = code
+	r.Error = err.Error()
+}
+
+func (r *runResult) addChat(res delivery.Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat := chatResult{
+		ChatID:     res.ChatID,
+		OK:         res.Err == nil,
+		Attempts:   res.Attempts,
+		DurationMS: res.Duration.Milliseconds(),
+	}
+	if res.Err != nil {
+		chat.Error = res.Err.Error()
+		var apiErr *tg.APIError
+		if errors.As(res.Err, &apiErr) {
+			chat.ErrorCode = apiErr.Code
+		}
+		r.Failed++
+	} else {
+		r.Delivered++
+	}
+	r.Chats = append(r.Chats, chat)
+}
+
+// finish computes the exit code, logs the summary and writes
+// the JSON report if reportPath is set.
+func (r *runResult) finish(logger *slog.Logger, reportPath string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.DurationMS = time.Since(r.Started).Milliseconds()
+	if r.ExitCode == exitOK && r.Failed > 0 {
+		r.ExitCode = exitPartialDelivery
+		if r.Delivered == 0 {
+			r.ExitCode = exitDelivery
+		}
+	}
+
+	logger.Info("run summary",
+		slog.String("command", r.Command),
+		slog.Int("chats", len(r.Chats)),
+		slog.Int("delivered", r.Delivered),
+		slog.Int("failed", r.Failed),
+		slog.Int("exit_code", r.ExitCode),
+		slog.Duration("total", time.Since(r.Started)),
+	)
+
+	if reportPath != "" {
+		if err := r.writeReport(reportPath); err != nil {
+			logger.Error("write report", slog.String("path", reportPath), slog.Any("err", err))
+		}
+	}
+	return r.ExitCode
+}
+
+func (r *runResult) writeReport(path string) error {
+	raw, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+// Package bot answers air quality commands sent to the Telegram bot.
+package bot
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/timezones"
+)
+
+// Fetcher fetches air quality data, e.g. *meteo.Client.
+type Fetcher interface {
+	AirQuality(ctx context.Context, p meteo.Params) (models.AirQualityResponse, error)
+}
+
+// Location is a place reports are made for.
+type Location struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// Timezone is the IANA timezone of the place. Empty means detected by Open-Meteo.
+	Timezone string
+}
+
+// Config of Bot.
+type Config struct {
+	Client  *tg.Client
+	Fetcher Fetcher
+	// Store persists the updates offset. Optional.
+	Store  *state.Store
+	Logger *slog.Logger
+	// Location is used by commands without a location.
+	Location Location
+	// PollTimeout of a single getUpdates request.
+	PollTimeout time.Duration
+}
+
+// Bot answers commands in any chat it is added to.
+type Bot struct {
+	client      *tg.Client
+	fetcher     Fetcher
+	store       *state.Store
+	logger      *slog.Logger
+	location    Location
+	pollTimeout time.Duration
+
+	inline   *inlineCache
+	username string
+	now      func() time.Time
+}
+
+// New creates Bot.
+func New(cfg Config) *Bot {
+	return &Bot{
+		client:      cfg.Client,
+		fetcher:     cfg.Fetcher,
+		store:       cfg.Store,
+		logger:      cmp.Or(cfg.Logger, slog.New(slog.DiscardHandler)),
+		location:    cfg.Location,
+		pollTimeout: cfg.PollTimeout,
+		inline:      newInlineCache(),
+		now:         time.Now,
+	}
+}
+
+// Init resolves the bot's username, so commands addressed to other bots are ignored.
+// Run calls it; call it before Handle when updates are received elsewhere, e.g. by a webhook.
+func (b *Bot) Init(ctx context.Context) error {
+	me, err := b.client.GetMe(ctx)
+	if err != nil {
+		return fmt.Errorf("get bot user: %w", err)
+	}
+	b.username = me.Username
+	return nil
+}
+
+// Run answers updates received with long polling and posts to subscribed chats
+// until ctx is canceled. Updates received before cancellation are still answered.
+func (b *Bot) Run(ctx context.Context) error {
+	if err := b.Init(ctx); err != nil {
+		return err
+	}
+
+	opts := tg.PollOptions{
+		Timeout:        b.pollTimeout,
+		AllowedUpdates: []string{"message", "callback_query", "inline_query"},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	if b.store != nil {
+		opts.Offset = b.store.UpdatesOffset()
+		opts.Commit = b.store.SetUpdatesOffset
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.runSchedule(ctx)
+		}()
+	}
+
+	b.logger.InfoContext(ctx, "bot started", "username", b.username, "offset", opts.Offset)
+	if err := b.client.Poll(ctx, opts, b.Handle); err != nil {
+		return fmt.Errorf("poll updates: %w", err)
+	}
+	return nil
+}
+
+// Handle answers a single update.
+func (b *Bot) Handle(ctx context.Context, update tg.Update) {
+	switch {
+	case update.CallbackQuery != nil:
+		b.handleCallback(ctx, update.CallbackQuery)
+		return
+	case update.InlineQuery != nil:
+		b.handleInline(ctx, update.InlineQuery)
+		return
+	}
+
+	msg := update.Message
+	if msg == nil {
+		return
+	}
+
+	resp, ok, err := b.answer(ctx, msg)
+	if err != nil {
+		b.logger.ErrorContext(ctx, "answer message", "chat", msg.Chat.ID, "err", err)
+		resp = response{text: "⚠️  Can't answer right now, try again later."}
+	} else if !ok {
+		return
+	}
+
+	b.reply(ctx, msg, resp)
+}
+
+// response is a reply text with send options, e.g. a keyboard.
+type response struct {
+	text string
+	opts []tg.SendOption
+	// level is the worst level of current air quality reports.
+	level meteo.Level
+}
+
+// answer returns the reply to the message. Messages which don't need a reply are reported with ok == false.
+func (b *Bot) answer(ctx context.Context, msg *tg.Message) (response, bool, error) {
+	if loc, ok := sharedLocation(msg); ok {
+		resp, err := b.report(ctx, ReportNow, loc, nil)
+		return resp, true, err
+	}
+
+	name, args, ok := parseCommand(msg.Text, b.username)
+	if !ok {
+		return response{}, false, nil
+	}
+
+	var (
+		resp response
+		err  error
+	)
+	switch name {
+	case "now", "forecast", "pollen", "tomorrow":
+		loc, vars := b.chatLocation(msg)
+		resp, err = b.report(ctx, Report(name), loc, vars)
+	case "subscribe", "unsubscribe", "time", "vars", "notify":
+		resp.text, err = b.configure(ctx, msg, name, args)
+	case "settings":
+		resp.text = b.settings(msg)
+	case "help", "start":
+		resp.text = helpText
+	default:
+		if msg.Chat.Type != "private" {
+			// other bots in the group may handle it
+			return response{}, false, nil
+		}
+		resp.text = helpText
+	}
+	if err != nil {
+		return response{}, true, fmt.Errorf("command %s: %w", name, err)
+	}
+	return resp, true, nil
+}
+
+// sharedLocation returns the place of a location or venue message,
+// with the timezone picked by coordinates.
+func sharedLocation(msg *tg.Message) (Location, bool) {
+	switch {
+	case msg.Venue != nil:
+		loc := pointLocation(msg.Venue.Location.Latitude, msg.Venue.Location.Longitude)
+		loc.Name = msg.Venue.Title
+		return loc, true
+	case msg.Location != nil:
+		return pointLocation(msg.Location.Latitude, msg.Location.Longitude), true
+	default:
+		return Location{}, false
+	}
+}
+
+// pointLocation returns a location named by its coordinates.
+func pointLocation(latitude, longitude float64) Location {
+	return Location{
+		Name:      fmt.Sprintf("%.4f, %.4f", latitude, longitude),
+		Latitude:  latitude,
+		Longitude: longitude,
+		Timezone:  timezones.Nearest(latitude, longitude),
+	}
+}
+
+func (b *Bot) reply(ctx context.Context, to *tg.Message, resp response) {
+	target := targetOf(to)
+	opts := append([]tg.SendOption{tg.InThread(target.ThreadID), tg.ReplyTo(to.MessageID)}, resp.opts...)
+	_, err := b.client.SendText(ctx, target.ChatID, resp.text, opts...)
+	if err != nil {
+		b.logger.ErrorContext(ctx, "send reply", "chat", target, "err", err)
+	}
+}
+
+// targetOf returns the chat and forum topic of the message.
+// Threads of replies in chats without topics are ignored.
+func targetOf(msg *tg.Message) tg.Target {
+	target := tg.Target{ChatID: strconv.FormatInt(msg.Chat.ID, 10)}
+	if msg.IsTopicMessage {
+		target.ThreadID = msg.MessageThreadID
+	}
+	return target
+}
+
+const helpText = `🥓  Daily Bacon air quality bot
+
+/now – current air quality
+/forecast – hourly forecast for the next 12 hours
+/pollen – pollen in the air
+/tomorrow – forecast for tomorrow
+/help – this message
+
+Share a location or a venue to get air quality there,
+or type the bot's @username and a city in any chat.
+
+Daily posts:
+/subscribe <city or lat,lon> – post air quality every day, reply to a shared location to use it
+/unsubscribe – stop daily posts
+/time 08:00 – local time of daily posts
+/vars pm2_5,dust – reported variables
+/notify watch – lowest level posted with a sound, Act Now is always pinned
+/settings – current settings`
+
+// parseCommand splits a message like "/now@bacon_bot args" into command name and arguments.
+// Commands addressed to other bots are skipped.
+func parseCommand(text, username string) (name, args string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	command := text[1:]
+	if i := strings.IndexFunc(command, unicode.IsSpace); i >= 0 {
+		command, args = command[:i], strings.TrimSpace(command[i:])
+	}
+
+	name, addressee, addressed := strings.Cut(command, "@")
+	if name == "" || addressed && !strings.EqualFold(addressee, username) {
+		return "", "", false
+	}
+	return strings.ToLower(name), args, true
+}
@@ -0,0 +1,547 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2025, 5, 1, 9, 30, 0, 0, time.UTC)
+
+type fakeFetcher struct {
+	mu     sync.Mutex
+	params []meteo.Params
+}
+
+func (f *fakeFetcher) AirQuality(_ context.Context, p meteo.Params) (models.AirQualityResponse, error) {
+	f.mu.Lock()
+	f.params = append(f.params, p)
+	f.mu.Unlock()
+
+	resp := models.AirQualityResponse{
+		Timezone:     "GMT",
+		Current:      &models.CurrentData{PM10: 12, PM25: 30},
+		CurrentUnits: &models.CurrentUnits{PM10: "μg/m³", PM25: "μg/m³"},
+		HourlyUnits:  &models.HourlyUnits{PM25: "μg/m³", BirchPollen: "grains/m³"},
+		Hourly:       &models.HourlyData{},
+	}
+	start := testNow.Truncate(24 * time.Hour)
+	for hour := range 48 {
+		resp.Hourly.Time = append(resp.Hourly.Time, start.Add(time.Duration(hour)*time.Hour).Format("2006-01-02T15:04"))
+		resp.Hourly.PM25 = append(resp.Hourly.PM25, float64(hour))
+		resp.Hourly.BirchPollen = append(resp.Hourly.BirchPollen, float64(hour%24))
+	}
+	return resp, nil
+}
+
+const (
+	adminID       = 1
+	blockedChatID = "403"
+)
+
+// newTestAPI starts a fake Bot API where only adminID administers the group -100
+// and messages to blockedChatID fail.
+func newTestAPI(t *testing.T) (*tgtest.Server, *tg.Client) {
+	srv := tgtest.NewServer(t)
+	srv.Promote("-100", adminID)
+	srv.Block(blockedChatID)
+	return srv, tg.New(tg.WithToken("123:abc"), tg.WithAPIURL(srv.URL))
+}
+
+// inbox returns messages sent to a chat one by one.
+type inbox struct {
+	srv    *tgtest.Server
+	chatID string
+	seen   int
+}
+
+func (in *inbox) next(t *testing.T) tgtest.Message {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return len(in.srv.Messages(in.chatID)) > in.seen
+	}, 5*time.Second, time.Millisecond, "no message sent")
+
+	msg := in.srv.Messages(in.chatID)[in.seen]
+	in.seen++
+	return msg
+}
+
+func (in *inbox) requireEmpty(t *testing.T) {
+	t.Helper()
+	require.Len(t, in.srv.Messages(in.chatID), in.seen, "unexpected messages")
+}
+
+// lastAnswer returns the last answer to a query.
+func lastAnswer(t *testing.T, srv *tgtest.Server) tgtest.Answer {
+	t.Helper()
+	answers := srv.Answers()
+	require.NotEmpty(t, answers, "query is not answered")
+	return answers[len(answers)-1]
+}
+
+func command(messageID int, chatType, text string) tg.Update {
+	return tg.Update{
+		Message: &tg.Message{
+			MessageID:       messageID,
+			MessageThreadID: 7,
+			IsTopicMessage:  true,
+			Chat:            tg.Chat{ID: -100, Type: chatType},
+			Text:            text,
+		},
+	}
+}
+
+func TestBot_Run(t *testing.T) {
+	srv, client := newTestAPI(t)
+	for _, update := range []tg.Update{
+		command(100, "group", "/now"),
+		command(101, "group", "/forecast@TgTest_Bot"),
+		command(102, "group", "/pollen@other_bot"),
+		command(103, "group", "/unknown"),
+		command(104, "private", "/pollen"),
+		command(105, "private", "/whatever"),
+		command(106, "private", "hello"),
+	} {
+		srv.AddUpdate(update)
+	}
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	require.NoError(t, err)
+
+	fetcher := &fakeFetcher{}
+	b := New(Config{
+		Client:      client,
+		Fetcher:     fetcher,
+		Store:       store,
+		Location:    Location{Name: "Limassol", Latitude: 34.7, Longitude: 33},
+		PollTimeout: time.Second,
+	})
+	b.now = func() time.Time { return testNow }
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+
+	chat := &inbox{srv: srv, chatID: "-100"}
+	now := chat.next(t)
+	require.Equal(t, 7, now.ThreadID)
+	require.Equal(t, 100, now.ReplyTo)
+	require.Contains(t, now.Text, "📍  Limassol")
+	require.Contains(t, now.Text, "Current Air Quality")
+	require.Contains(t, now.Entities, `"type":"bold"`, "reports are formatted like daily posts")
+
+	forecast := chat.next(t)
+	require.Contains(t, forecast.Text, "Forecast: Thu 01 May 09:00 – 20:00")
+
+	pollen := chat.next(t)
+	require.Contains(t, pollen.Text, "peak 20 grains/m³ at 20:00")
+
+	help := chat.next(t)
+	require.Contains(t, help.Text, "/forecast")
+
+	// update IDs are assigned by the server from 1
+	require.Eventually(t, func() bool {
+		return store.UpdatesOffset() == 8 && srv.Offset() == 8
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	chat.requireEmpty(t)
+	require.Len(t, fetcher.params, 3)
+	require.Equal(t, 34.7, fetcher.params[0].Latitude)
+}
+
+func TestBot_Run_ResumesFromStoredOffset(t *testing.T) {
+	srv, client := newTestAPI(t)
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	require.NoError(t, err)
+	require.NoError(t, store.SetUpdatesOffset(42))
+
+	b := New(Config{
+		Client:  client,
+		Fetcher: &fakeFetcher{},
+		Store:   store,
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return srv.Calls("getUpdates") > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	require.Equal(t, 42, srv.Offset())
+}
+
+func TestBot_Run_Conflict(t *testing.T) {
+	srv, client := newTestAPI(t)
+	srv.Fail("getUpdates", tgtest.Fault{
+		Code:        http.StatusConflict,
+		Description: "Conflict: can't use getUpdates method while webhook is active",
+	})
+
+	b := New(Config{
+		Client:  client,
+		Fetcher: &fakeFetcher{},
+	})
+	err := b.Run(t.Context())
+	require.ErrorContains(t, err, "webhook is active")
+}
+
+func TestBot_Handle_Location(t *testing.T) {
+	srv, client := newTestAPI(t)
+	fetcher := &fakeFetcher{}
+	b := New(Config{
+		Client:  client,
+		Fetcher: fetcher,
+	})
+
+	venue := tg.Location{Latitude: 34.67, Longitude: 33.04}
+	b.Handle(t.Context(), tg.Update{UpdateID: 1, Message: &tg.Message{
+		MessageID: 5,
+		Chat:      tg.Chat{ID: -100, Type: "supergroup"},
+		Location:  &venue,
+		Venue:     &tg.Venue{Location: venue, Title: "Limassol Marina"},
+	}})
+	reply := (&inbox{srv: srv, chatID: "-100"}).next(t)
+	require.Contains(t, reply.Text, "📍  Limassol Marina (Asia/Nicosia)")
+	require.Contains(t, reply.Text, "Current Air Quality")
+	require.Equal(t, 5, reply.ReplyTo)
+
+	b.Handle(t.Context(), tg.Update{UpdateID: 2, Message: &tg.Message{
+		MessageID: 6,
+		Chat:      tg.Chat{ID: 1, Type: "private"},
+		Location:  &tg.Location{Latitude: 52.52, Longitude: 13.405},
+	}})
+	reply = (&inbox{srv: srv, chatID: "1"}).next(t)
+	require.Contains(t, reply.Text, "📍  52.5200, 13.4050 (Europe/Berlin)")
+
+	require.Len(t, fetcher.params, 2)
+	require.Equal(t, "Asia/Nicosia", fetcher.params[0].Timezone)
+	require.Equal(t, 34.67, fetcher.params[0].Latitude)
+	require.Equal(t, "Europe/Berlin", fetcher.params[1].Timezone)
+	require.Equal(t, CurrentVars, fetcher.params[1].Current)
+}
+
+func TestBot_Subscriptions(t *testing.T) {
+	srv, client := newTestAPI(t)
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	require.NoError(t, err)
+
+	fetcher := &fakeFetcher{}
+	b := New(Config{
+		Client:   client,
+		Fetcher:  fetcher,
+		Store:    store,
+		Location: Location{Name: "Limassol", Latitude: 34.7, Longitude: 33},
+	})
+
+	chat := &inbox{srv: srv, chatID: "-100"}
+	send := func(userID int64, text string) string {
+		t.Helper()
+		update := command(1, "supergroup", text)
+		update.Message.From = &tg.User{ID: userID}
+		b.Handle(t.Context(), update)
+		return chat.next(t).Text
+	}
+
+	require.Equal(t, notAdminText, send(2, "/subscribe Nicosia"))
+	require.Equal(t, notSubscribeText, send(adminID, "/time 07:30"))
+	require.Equal(t, "✅  Daily air quality for Nicosia (Asia/Nicosia) at 08:00.", send(adminID, "/subscribe nicosia"))
+	require.Contains(t, send(adminID, "/subscribe Atlantis"), `Unknown place "Atlantis"`)
+	require.Contains(t, send(adminID, "/time 25:00"), "like /time 08:00")
+	require.Equal(t, "✅  Daily posts at 07:30.", send(adminID, "/time 7:30"))
+	require.Contains(t, send(adminID, "/vars pm2_5,bogus"), `Unknown variable "bogus"`)
+	require.Equal(t, "✅  Daily posts report PM₂.₅, Dust.", send(adminID, "/vars PM2_5 dust"))
+	require.Contains(t, send(adminID, "/notify loud"), "like /notify watch")
+	require.Equal(t, "✅  Daily posts sound from Act Now, pinned from Act Now.", send(adminID, "/notify act-now"))
+
+	settings := send(2, "/settings")
+	require.Contains(t, settings, "Place: Nicosia (Asia/Nicosia)")
+	require.Contains(t, settings, "Time: 07:30")
+	require.Contains(t, settings, "Variables: PM₂.₅, Dust")
+	require.Contains(t, settings, "Notifications: sound from Act Now")
+
+	send(2, "/now")
+	require.Equal(t, 35.166667, fetcher.params[0].Latitude)
+	require.Equal(t, []string{meteo.PM2_5, meteo.Dust}, fetcher.params[0].Current)
+
+	// 09:30 UTC is 12:30 in Nicosia
+	b.postDue(t.Context(), testNow)
+	post := chat.next(t)
+	require.Equal(t, 7, post.ThreadID)
+	require.Zero(t, post.ReplyTo)
+	require.Contains(t, post.Text, "📍  Nicosia (Asia/Nicosia)")
+	require.True(t, post.Silent, "limit exceeded posts are silent from act-now")
+	require.False(t, post.Pinned)
+
+	b.postDue(t.Context(), testNow.Add(time.Hour))
+	sub, ok := store.Subscription("-100:7")
+	require.True(t, ok)
+	require.Equal(t, testNow, sub.LastPostedAt)
+
+	require.Equal(t, "✅  Daily posts sound from Good, pinned from Limit Exceeded.", send(adminID, "/notify good:limit-exceeded"))
+	tomorrow := testNow.Add(24 * time.Hour)
+	b.postDue(t.Context(), tomorrow)
+	post = chat.next(t)
+	require.False(t, post.Silent)
+	require.True(t, post.Pinned)
+	require.False(t, post.PinnedSilently, "alerts are pinned with a notification")
+
+	require.Equal(t, "Daily posts are stopped.", send(adminID, "/unsubscribe"))
+	require.Equal(t, notSubscribeText, send(adminID, "/unsubscribe"))
+
+	// anonymous admins post on behalf of the group
+	shared := command(2, "supergroup", "/subscribe")
+	shared.Message.SenderChat = &tg.Chat{ID: -100}
+	shared.Message.ReplyToMessage = &tg.Message{Location: &tg.Location{Latitude: 52.52, Longitude: 13.405}}
+	b.Handle(t.Context(), shared)
+	require.Equal(t, "✅  Daily air quality for 52.5200, 13.4050 (Europe/Berlin) at 08:00.", chat.next(t).Text)
+
+	require.NoError(t, store.SetSubscription(blockedChatID, state.Subscription{
+		ChatID: blockedChatID, Place: "Berlin", Timezone: "Europe/Berlin", Time: "08:00",
+	}))
+	b.postDue(t.Context(), tomorrow.Add(24*time.Hour))
+	require.Contains(t, chat.next(t).Text, "📍  52.5200, 13.4050 (Europe/Berlin)")
+	_, ok = store.Subscription(blockedChatID)
+	require.False(t, ok, "subscriptions of blocked chats must be removed")
+
+	chat.requireEmpty(t)
+}
+
+func TestBot_Subscriptions_Private(t *testing.T) {
+	srv, client := newTestAPI(t)
+	b := New(Config{
+		Client:  client,
+		Fetcher: &fakeFetcher{},
+	})
+
+	chat := &inbox{srv: srv, chatID: "-100"}
+	update := command(1, "private", "/subscribe 34.7,33.02")
+	b.Handle(t.Context(), update)
+	require.Equal(t, noStoreText, chat.next(t).Text)
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	require.NoError(t, err)
+	b.store = store
+
+	// private chats need no admin check
+	b.Handle(t.Context(), update)
+	require.Equal(t, "✅  Daily air quality for 34.7000, 33.0200 (Asia/Nicosia) at 08:00.", chat.next(t).Text)
+}
+
+func TestBot_Callback(t *testing.T) {
+	srv, client := newTestAPI(t)
+	fetcher := &fakeFetcher{}
+	b := New(Config{
+		Client:   client,
+		Fetcher:  fetcher,
+		Location: Location{Name: "Limassol", Latitude: 34.70713, Longitude: 33.022617},
+	})
+	b.now = func() time.Time { return testNow }
+
+	chat := &inbox{srv: srv, chatID: "-100"}
+	b.Handle(t.Context(), command(1, "private", "/now"))
+	reply := chat.next(t)
+
+	var keyboard tg.InlineKeyboardMarkup
+	require.NoError(t, json.Unmarshal([]byte(reply.ReplyMarkup), &keyboard))
+	require.Len(t, keyboard.InlineKeyboard, 1)
+	buttons := keyboard.InlineKeyboard[0]
+	require.Len(t, buttons, 4)
+	require.Equal(t, "• Current", buttons[0].Text)
+	require.Equal(t, "Tomorrow", buttons[3].Text)
+	for _, button := range buttons {
+		require.LessOrEqual(t, len(button.CallbackData), tg.MaxCallbackData)
+	}
+
+	press := func(data string, msg *tg.Message) tgtest.Answer {
+		t.Helper()
+		b.Handle(t.Context(), tg.Update{UpdateID: 2, CallbackQuery: &tg.CallbackQuery{
+			ID: "q", Data: data, Message: msg,
+		}})
+		answer := lastAnswer(t, srv)
+		require.Equal(t, "answerCallbackQuery", answer.Method)
+		require.Equal(t, "q", answer.QueryID)
+		return answer
+	}
+
+	posted := &tg.Message{MessageID: reply.MessageID, Chat: tg.Chat{ID: -100}}
+	answer := press(buttons[3].CallbackData, posted)
+	require.Empty(t, answer.Text)
+
+	edit := srv.Messages("-100")[0]
+	require.True(t, edit.Edited)
+	require.Contains(t, edit.Text, "📍  Limassol (Asia/Nicosia)")
+	// tomorrow in the timezone of the response, every 3 hours
+	require.Contains(t, edit.Text, "Forecast: Fri 02 May 00:00 – 21:00")
+	require.Contains(t, edit.ReplyMarkup, "• Tomorrow")
+
+	params := fetcher.params[len(fetcher.params)-1]
+	require.Equal(t, 34.7071, params.Latitude)
+	require.Equal(t, "Asia/Nicosia", params.Timezone)
+
+	// switching back keeps the formatting of the current report
+	press(buttons[0].CallbackData, posted)
+	edit = srv.Messages("-100")[0]
+	require.Contains(t, edit.Text, "Current Air Quality")
+	require.Contains(t, edit.Entities, `"type":"code"`)
+
+	answer = press("r|bogus|1|2", posted)
+	require.Equal(t, "Unknown button.", answer.Text)
+
+	answer = press(buttons[1].CallbackData, nil)
+	require.Contains(t, answer.Text, "too old")
+
+	chat.requireEmpty(t)
+	require.Len(t, srv.Answers(), 4)
+}
+
+func TestBot_RenderHourly_HalfHourOffset(t *testing.T) {
+	b := New(Config{})
+	b.now = func() time.Time { return testNow }
+
+	// hourly samples of Asia/Kolkata are at :30 UTC
+	resp := models.AirQualityResponse{
+		Timezone:         "Asia/Kolkata",
+		UTCOffsetSeconds: 5*3600 + 30*60,
+		HourlyUnits:      &models.HourlyUnits{PM25: "μg/m³", BirchPollen: "grains/m³"},
+		Hourly:           &models.HourlyData{},
+	}
+	for hour := range 48 {
+		resp.Hourly.Time = append(resp.Hourly.Time, fmt.Sprintf("2025-05-%02dT%02d:00", 1+hour/24, hour%24))
+		resp.Hourly.PM25 = append(resp.Hourly.PM25, float64(hour))
+		resp.Hourly.BirchPollen = append(resp.Hourly.BirchPollen, float64(hour))
+	}
+
+	// 09:30 UTC is 15:00 in Asia/Kolkata
+	for report, want := range map[Report]string{
+		ReportForecast: "Forecast: Thu 01 May 15:00",
+		ReportPollen:   "now 15,",
+	} {
+		var out strings.Builder
+		require.NoError(t, b.renderHourly(&out, report, resp))
+		require.Contains(t, out.String(), want, report)
+	}
+}
+
+func TestBot_Inline(t *testing.T) {
+	srv, client := newTestAPI(t)
+	fetcher := &fakeFetcher{}
+	b := New(Config{
+		Client:   client,
+		Fetcher:  fetcher,
+		Location: Location{Name: "Limassol", Latitude: 34.7, Longitude: 33},
+	})
+	now := testNow
+	b.now = func() time.Time { return now }
+
+	ask := func(query string) []tg.InlineQueryResultArticle {
+		t.Helper()
+		b.Handle(t.Context(), tg.Update{UpdateID: 1, InlineQuery: &tg.InlineQuery{ID: "iq", Query: query}})
+		answer := lastAnswer(t, srv)
+		require.Equal(t, "answerInlineQuery", answer.Method)
+		require.Equal(t, "iq", answer.QueryID)
+		require.Equal(t, 300, answer.CacheTime)
+
+		var results []tg.InlineQueryResultArticle
+		require.NoError(t, json.Unmarshal([]byte(answer.Results), &results))
+		return results
+	}
+
+	results := ask(" nicosia ")
+	require.Len(t, results, 1)
+	article := results[0]
+	require.Equal(t, "article", article.Type)
+	require.Equal(t, "Nicosia (Asia/Nicosia)", article.Title)
+	require.Equal(t, "⚠️ Limit Exceeded · PM₂.₅ 30 μg/m³ · PM₁₀ 12 μg/m³", article.Description)
+	require.Contains(t, article.InputMessageContent.MessageText, "Current Air Quality")
+	require.NotEmpty(t, article.InputMessageContent.Entities)
+	require.NotNil(t, article.ReplyMarkup)
+	require.Len(t, fetcher.params, 1)
+
+	ask("Nicosia")
+	require.Len(t, fetcher.params, 1, "results must be cached")
+
+	now = now.Add(inlineCacheTTL)
+	ask("Nicosia")
+	require.Len(t, fetcher.params, 2, "expired results must be fetched again")
+
+	results = ask("")
+	require.Len(t, results, 1)
+	require.Equal(t, "Limassol", results[0].Title)
+
+	require.Len(t, ask("america"), maxInlinePlaces)
+	require.Empty(t, ask("Atlantis"))
+
+	// buttons of inline messages edit them by inline message ID
+	srv.AddInlineMessage("inline-1", article.InputMessageContent.MessageText)
+	b.Handle(t.Context(), tg.Update{UpdateID: 2, CallbackQuery: &tg.CallbackQuery{
+		ID: "q", InlineMessageID: "inline-1", Data: article.ReplyMarkup.InlineKeyboard[0][2].CallbackData,
+	}})
+	require.Empty(t, lastAnswer(t, srv).Text)
+	edit, ok := srv.InlineMessage("inline-1")
+	require.True(t, ok)
+	require.True(t, edit.Edited)
+	require.Contains(t, edit.Text, "Pollen")
+}
+
+func TestReportCallback(t *testing.T) {
+	loc := Location{Name: "Buenos Aires", Latitude: -34.6, Longitude: -58.45}
+	data, err := encodeReportCallback(ReportPollen, loc)
+	require.NoError(t, err)
+
+	report, decoded, ok := decodeReportCallback(data)
+	require.True(t, ok)
+	require.Equal(t, ReportPollen, report)
+	require.Equal(t, "Buenos Aires", decoded.Name)
+	require.Equal(t, "America/Argentina/Buenos_Aires", decoded.Timezone)
+
+	// names which don't fit are dropped
+	loc.Name = strings.Repeat("Llanfairpwllgwyngyll", 3)
+	data, err = encodeReportCallback(ReportTomorrow, loc)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(data), tg.MaxCallbackData)
+	_, decoded, ok = decodeReportCallback(data)
+	require.True(t, ok)
+	require.Equal(t, "-34.6000, -58.4500", decoded.Name)
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		text, name, args string
+		ok               bool
+	}{
+		{text: "/now", name: "now", ok: true},
+		{text: "/NOW@bacon_bot", name: "now", ok: true},
+		{text: "/subscribe  Limassol, Cyprus ", name: "subscribe", args: "Limassol, Cyprus", ok: true},
+		{text: "/time\n08:00", name: "time", args: "08:00", ok: true},
+		{text: "/now@other_bot", ok: false},
+		{text: "now", ok: false},
+		{text: "/", ok: false},
+		{text: "/@bacon_bot", ok: false},
+	} {
+		name, args, ok := parseCommand(tc.text, "bacon_bot")
+		require.Equal(t, tc.ok, ok, tc.text)
+		require.Equal(t, tc.name, name, tc.text)
+		require.Equal(t, tc.args, args, tc.text)
+	}
+}
@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/timezones"
+	"github.com/ninedraft/daily-bacon/internal/view"
+)
+
+const (
+	// maxInlinePlaces bounds air quality requests made for a single inline query.
+	maxInlinePlaces = 5
+	// inlineCacheTTL is how long fetched air quality is reused by inline queries.
+	inlineCacheTTL = 10 * time.Minute
+	// inlineCacheTime is how long Telegram may reuse an answer to the same query.
+	inlineCacheTime = 5 * time.Minute
+)
+
+// inlineCache keeps current air quality of places looked up by inline queries.
+// It is safe for concurrent use.
+type inlineCache struct {
+	mu      sync.Mutex
+	entries map[string]inlineEntry
+}
+
+type inlineEntry struct {
+	resp      models.AirQualityResponse
+	fetchedAt time.Time
+}
+
+func newInlineCache() *inlineCache {
+	return &inlineCache{entries: map[string]inlineEntry{}}
+}
+
+func (c *inlineCache) get(key string, now time.Time) (models.AirQualityResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.Sub(entry.fetchedAt) >= inlineCacheTTL {
+		return models.AirQualityResponse{}, false
+	}
+	return entry.resp, true
+}
+
+// put stores the response and drops expired entries.
+func (c *inlineCache) put(key string, resp models.AirQualityResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) >= inlineCacheTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = inlineEntry{resp: resp, fetchedAt: now}
+}
+
+// handleInline answers an inline query with current air quality of matching places.
+// Places which can't be fetched are left out.
+func (b *Bot) handleInline(ctx context.Context, query *tg.InlineQuery) {
+	places := inlinePlaces(query.Query, b.location)
+
+	results := make([]*tg.InlineQueryResultArticle, len(places))
+	var wg sync.WaitGroup
+	for i, loc := range places {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := b.inlineResult(ctx, loc)
+			if err != nil {
+				b.logger.ErrorContext(ctx, "inline result", "place", loc.Name, "err", err)
+				return
+			}
+			results[i] = &result
+		}()
+	}
+	wg.Wait()
+
+	var articles []tg.InlineQueryResultArticle
+	for _, result := range results {
+		if result != nil {
+			articles = append(articles, *result)
+		}
+	}
+
+	err := b.client.AnswerInlineQuery(ctx, query.ID, articles, tg.InlineAnswer{CacheTime: inlineCacheTime})
+	if err != nil {
+		b.logger.ErrorContext(ctx, "answer inline query", "query", query.Query, "err", err)
+	}
+}
+
+// inlinePlaces returns places matching the query: the default location
+// for an empty query, coordinates or cities of timezones.
+func inlinePlaces(query string, fallback Location) []Location {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []Location{fallback}
+	}
+	if loc, ok := parseCoordinates(query); ok {
+		return []Location{loc}
+	}
+
+	var places []Location
+	for _, place := range timezones.Places(query) {
+		if len(places) == maxInlinePlaces {
+			break
+		}
+		places = append(places, Location{
+			Name:      place.Name,
+			Latitude:  place.Latitude,
+			Longitude: place.Longitude,
+			Timezone:  place.Timezone,
+		})
+	}
+	return places
+}
+
+// inlineResult returns an article with the current air quality report about loc,
+// described by a one-line summary.
+func (b *Bot) inlineResult(ctx context.Context, loc Location) (tg.InlineQueryResultArticle, error) {
+	key := fmt.Sprintf("%.4f,%.4f", loc.Latitude, loc.Longitude)
+
+	resp, ok := b.inline.get(key, b.now())
+	if !ok {
+		var err error
+		resp, err = b.fetch(ctx, ReportNow, loc, nil)
+		if err != nil {
+			return tg.InlineQueryResultArticle{}, err
+		}
+		b.inline.put(key, resp, b.now())
+	}
+
+	text, err := b.format(ReportNow, loc, resp)
+	if err != nil {
+		return tg.InlineQueryResultArticle{}, err
+	}
+	var summary bytes.Buffer
+	if err := view.Summary(&summary, resp); err != nil {
+		return tg.InlineQueryResultArticle{}, err
+	}
+
+	title := loc.Name
+	if loc.Timezone != "" {
+		title += " (" + loc.Timezone + ")"
+	}
+	article := tg.NewArticle(key, title, summary.String(), text.String())
+	article.InputMessageContent.Entities = text.Entities()
+	if keyboard, err := Keyboard(loc, ReportNow); err == nil {
+		article.ReplyMarkup = &keyboard
+	}
+	return article, nil
+}
@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// reportCallback prefixes callback data of report buttons.
+const reportCallback = "r"
+
+var keyboardReports = []struct {
+	report Report
+	label  string
+}{
+	{ReportNow, "Current"},
+	{ReportForecast, "Next 12h"},
+	{ReportPollen, "Pollen"},
+	{ReportTomorrow, "Tomorrow"},
+}
+
+// Keyboard returns buttons switching between reports about loc, the active report is marked.
+func Keyboard(loc Location, active Report) (tg.InlineKeyboardMarkup, error) {
+	var row []tg.InlineKeyboardButton
+	for _, button := range keyboardReports {
+		data, err := encodeReportCallback(button.report, loc)
+		if err != nil {
+			return tg.InlineKeyboardMarkup{}, err
+		}
+		label := button.label
+		if button.report == active {
+			label = "• " + label
+		}
+		row = append(row, tg.InlineKeyboardButton{Text: label, CallbackData: data})
+	}
+	return tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{row}}, nil
+}
+
+// encodeReportCallback encodes the report and coordinates of loc.
+// The place name is kept as long as the data fits into tg.MaxCallbackData.
+func encodeReportCallback(report Report, loc Location) (string, error) {
+	fields := []string{
+		reportCallback,
+		string(report),
+		strconv.FormatFloat(loc.Latitude, 'f', 4, 64),
+		strconv.FormatFloat(loc.Longitude, 'f', 4, 64),
+	}
+	withName, err := tg.EncodeCallbackData(append(fields, loc.Name)...)
+	if err == nil {
+		return withName, nil
+	}
+	return tg.EncodeCallbackData(fields...)
+}
+
+// decodeReportCallback decodes data of a report button.
+func decodeReportCallback(data string) (Report, Location, bool) {
+	fields := tg.DecodeCallbackData(data)
+	if len(fields) < 4 || fields[0] != reportCallback {
+		return "", Location{}, false
+	}
+
+	report := Report(fields[1])
+	known := false
+	for _, button := range keyboardReports {
+		known = known || button.report == report
+	}
+	latitude, errLat := strconv.ParseFloat(fields[2], 64)
+	longitude, errLon := strconv.ParseFloat(fields[3], 64)
+	if !known || errLat != nil || errLon != nil {
+		return "", Location{}, false
+	}
+
+	loc := pointLocation(latitude, longitude)
+	if len(fields) > 4 && fields[4] != "" {
+		loc.Name = fields[4]
+	}
+	return report, loc, true
+}
+
+// handleCallback switches the report of the message with the pressed button.
+func (b *Bot) handleCallback(ctx context.Context, query *tg.CallbackQuery) {
+	notice := ""
+	defer func() {
+		if err := b.client.AnswerCallbackQuery(ctx, query.ID, notice, false); err != nil {
+			b.logger.ErrorContext(ctx, "answer callback query", "err", err)
+		}
+	}()
+
+	report, loc, ok := decodeReportCallback(query.Data)
+	if !ok {
+		notice = "Unknown button."
+		return
+	}
+	if query.Message == nil && query.InlineMessageID == "" {
+		notice = "The message is too old, send /" + string(report) + "."
+		return
+	}
+
+	resp, err := b.report(ctx, report, loc, nil)
+	if err != nil {
+		b.logger.ErrorContext(ctx, "render report", "report", report, "err", err)
+		notice = "⚠️  Can't get air quality right now, try again later."
+		return
+	}
+
+	if query.Message != nil {
+		chatID := strconv.FormatInt(query.Message.Chat.ID, 10)
+		_, err = b.client.EditMessageText(ctx, chatID, query.Message.MessageID, resp.text, resp.opts...)
+	} else {
+		// messages sent in inline mode are edited by their inline ID
+		err = b.client.EditInlineMessageText(ctx, query.InlineMessageID, resp.text, resp.opts...)
+	}
+	if err != nil && !errors.Is(err, tg.ErrMessageNotModified) {
+		b.logger.ErrorContext(ctx, "edit report", "err", err)
+		notice = "⚠️  Can't update the message."
+	}
+}
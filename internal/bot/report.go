@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/digest"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/view"
+)
+
+// Report is a kind of air quality report.
+type Report string
+
+const (
+	// ReportNow shows current air quality.
+	ReportNow Report = "now"
+	// ReportForecast shows the hourly forecast.
+	ReportForecast Report = "forecast"
+	// ReportPollen shows pollen forecast.
+	ReportPollen Report = "pollen"
+	// ReportTomorrow shows the forecast for tomorrow.
+	ReportTomorrow Report = "tomorrow"
+)
+
+const (
+	// forecastHours is the number of hours covered by forecast reports.
+	forecastHours = 12
+	// tomorrowStep is the interval between rows of tomorrow's forecast.
+	tomorrowStep = 3 * time.Hour
+)
+
+// CurrentVars lists variables of the current air quality report.
+var CurrentVars = []string{
+	meteo.PM2_5,
+	meteo.PM10,
+	meteo.Dust,
+	meteo.OlivePollen,
+	meteo.Ozone,
+	meteo.NitrogenDioxide,
+	meteo.SulphurDioxide,
+	meteo.EuropeanAQI,
+}
+
+// fetch fetches data of the report at loc.
+func (b *Bot) fetch(ctx context.Context, report Report, loc Location, vars []string) (models.AirQualityResponse, error) {
+	params := meteo.Params{
+		Latitude:  loc.Latitude,
+		Longitude: loc.Longitude,
+		Timezone:  cmp.Or(loc.Timezone, "auto"),
+	}
+	switch report {
+	case ReportNow:
+		params.Current = CurrentVars
+		if len(vars) > 0 {
+			params.Current = vars
+		}
+	case ReportForecast:
+		params.Hourly = view.ForecastVars
+		params.ForecastDays = 2
+	case ReportPollen:
+		params.Hourly = digest.Pollen
+		params.ForecastDays = 2
+	case ReportTomorrow:
+		params.Hourly = view.ForecastVars
+		params.ForecastDays = 3
+	default:
+		return models.AirQualityResponse{}, fmt.Errorf("unknown report %q", report)
+	}
+
+	resp, err := b.fetcher.AirQuality(ctx, params)
+	if err != nil {
+		return models.AirQualityResponse{}, fmt.Errorf("fetch air quality: %w", err)
+	}
+	return resp, nil
+}
+
+// format renders the report about loc from fetched data.
+// Current air quality is formatted with entities, like daily posts.
+func (b *Bot) format(report Report, loc Location, resp models.AirQualityResponse) (*tg.Text, error) {
+	text := new(tg.Text)
+	switch {
+	case loc.Name != "" && loc.Timezone != "":
+		text.Plainf("📍  %s (%s)\n", loc.Name, loc.Timezone)
+	case loc.Name != "":
+		text.Plainf("📍  %s\n", loc.Name)
+	}
+
+	if report == ReportNow {
+		return text.Append(view.AirQualityText(resp)), nil
+	}
+	var buf bytes.Buffer
+	if err := b.renderHourly(&buf, report, resp); err != nil {
+		return nil, fmt.Errorf("render %s: %w", report, err)
+	}
+	return text.Plain(buf.String()), nil
+}
+
+// report renders the report with a keyboard switching between reports about loc.
+// Non-empty vars replace the variables of the current air quality report.
+func (b *Bot) report(ctx context.Context, report Report, loc Location, vars []string) (response, error) {
+	data, err := b.fetch(ctx, report, loc, vars)
+	if err != nil {
+		return response{}, err
+	}
+	text, err := b.format(report, loc, data)
+	if err != nil {
+		return response{}, err
+	}
+
+	resp := response{text: text.String(), opts: []tg.SendOption{tg.WithEntities(text.Entities())}}
+	if report == ReportNow {
+		resp.level = meteo.Worst(meteo.Values(data.Current))
+	}
+	keyboard, err := Keyboard(loc, report)
+	if err != nil {
+		b.logger.WarnContext(ctx, "report keyboard", "err", err)
+		return resp, nil
+	}
+	resp.opts = append(resp.opts, tg.WithKeyboard(keyboard))
+	return resp, nil
+}
+
+func (b *Bot) renderHourly(dst io.Writer, report Report, resp models.AirQualityResponse) error {
+	samples, err := digest.FromHourly(resp)
+	if err != nil {
+		return err
+	}
+	units := meteo.HourlyUnits(resp.HourlyUnits)
+
+	from := startOfHour(b.now(), samples)
+	switch report {
+	case ReportForecast:
+		return view.Forecast(dst, between(samples, from, from.Add(forecastHours*time.Hour), time.Hour), units)
+	case ReportPollen:
+		return view.Pollen(dst, between(samples, from, from.Add(forecastHours*time.Hour), time.Hour), units)
+	case ReportTomorrow:
+		if len(samples) == 0 {
+			return view.Forecast(dst, nil, units)
+		}
+		local := b.now().In(samples[0].Time.Location())
+		tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, local.Location())
+		return view.Forecast(dst, between(samples, tomorrow, tomorrow.AddDate(0, 0, 1), tomorrowStep), units)
+	default:
+		return fmt.Errorf("unknown report %q", report)
+	}
+}
+
+// startOfHour truncates now to the hour in the location of the samples.
+// Hourly samples of zones with a half-hour offset are not on whole UTC hours.
+func startOfHour(now time.Time, samples []digest.Sample) time.Time {
+	if len(samples) > 0 {
+		now = now.In(samples[0].Time.Location())
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+}
+
+// between returns samples within [from, to) which are step apart, starting from from.
+func between(samples []digest.Sample, from, to time.Time, step time.Duration) []digest.Sample {
+	var selected []digest.Sample
+	for _, sample := range samples {
+		if sample.Time.Before(from) || !sample.Time.Before(to) {
+			continue
+		}
+		if sample.Time.Sub(from)%step == 0 {
+			selected = append(selected, sample)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/timezones"
+)
+
+// scheduleInterval is how often subscriptions are checked for due posts.
+const scheduleInterval = time.Minute
+
+// runSchedule posts daily reports of subscriptions until ctx is canceled.
+func (b *Bot) runSchedule(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		b.postDue(ctx, b.now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// postDue posts to subscriptions whose local post time has passed today
+// and which have not been posted to since.
+func (b *Bot) postDue(ctx context.Context, now time.Time) {
+	for key, sub := range b.store.Subscriptions() {
+		if ctx.Err() != nil {
+			return
+		}
+		due, err := postDueAt(sub, now)
+		if err != nil {
+			b.logger.ErrorContext(ctx, "invalid subscription", "chat", key, "err", err)
+			continue
+		}
+		if now.Before(due) || !sub.LastPostedAt.Before(due) {
+			continue
+		}
+
+		if err := b.post(ctx, sub); err != nil {
+			b.logger.ErrorContext(ctx, "scheduled post", "chat", key, "err", err)
+			if errors.Is(err, tg.ErrBotBlocked) || errors.Is(err, tg.ErrChatNotFound) {
+				b.unsubscribeGone(ctx, key)
+			}
+			continue
+		}
+
+		_, err = b.store.UpdateSubscription(key, func(sub *state.Subscription) {
+			sub.LastPostedAt = now
+		})
+		if err != nil {
+			b.logger.ErrorContext(ctx, "save subscription", "chat", key, "err", err)
+		}
+	}
+}
+
+// post sends the report of the subscription, delivered by its policy.
+func (b *Bot) post(ctx context.Context, sub state.Subscription) error {
+	policy, err := subscriptionPolicy(sub)
+	if err != nil {
+		return err
+	}
+	resp, err := b.report(ctx, ReportNow, subscriptionLocation(sub), sub.Vars)
+	if err != nil {
+		return err
+	}
+
+	notification := policy.Notify(resp.level)
+	opts := append([]tg.SendOption{tg.InThread(sub.ThreadID), tg.Silent(notification.Silent)}, resp.opts...)
+	sent, err := b.client.SendText(ctx, sub.ChatID, resp.text, opts...)
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	notification.PinAlert(ctx, b.logger, b.client, sub.ChatID, sent[0].MessageID)
+	return nil
+}
+
+// unsubscribeGone drops subscriptions of chats the bot can't post to anymore.
+func (b *Bot) unsubscribeGone(ctx context.Context, key string) {
+	if _, err := b.store.DeleteSubscription(key); err != nil {
+		b.logger.ErrorContext(ctx, "delete subscription", "chat", key, "err", err)
+		return
+	}
+	b.logger.WarnContext(ctx, "chat is unavailable, subscription is removed", "chat", key)
+}
+
+// postDueAt returns today's post time of the subscription in its timezone.
+func postDueAt(sub state.Subscription, now time.Time) (time.Time, error) {
+	loc, err := timezones.Location(sub.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	at, err := time.Parse(postTimeLayout, sub.Time)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse post time: %w", err)
+	}
+
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, loc), nil
+}
@@ -0,0 +1,301 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ninedraft/daily-bacon/internal/delivery"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/state"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/timezones"
+	"github.com/ninedraft/daily-bacon/internal/view"
+)
+
+const (
+	defaultPostTime = "08:00"
+	postTimeLayout  = "15:04"
+)
+
+const (
+	noStoreText      = "Daily posts are disabled: the bot runs without a state file."
+	notAdminText     = "⛔  Only chat administrators can change daily posts."
+	notSubscribeText = "This chat has no daily posts. Start them with /subscribe <city>."
+	subscribeUsage   = "Send /subscribe with a city name or coordinates like /subscribe 34.7,33.0, or reply with /subscribe to a shared location."
+	notifyUsage      = "Send the lowest level of posts with a sound like /notify watch, or /notify watch:limit-exceeded to also pin them. Levels are good, watch, limit-exceeded and act-now."
+)
+
+// chatLocation returns the place and variables of the chat's subscription,
+// or the default location.
+func (b *Bot) chatLocation(msg *tg.Message) (Location, []string) {
+	if b.store == nil {
+		return b.location, nil
+	}
+	sub, ok := b.store.Subscription(targetOf(msg).String())
+	if !ok {
+		return b.location, nil
+	}
+	return subscriptionLocation(sub), sub.Vars
+}
+
+func subscriptionLocation(sub state.Subscription) Location {
+	return Location{
+		Name:      sub.Place,
+		Latitude:  sub.Latitude,
+		Longitude: sub.Longitude,
+		Timezone:  sub.Timezone,
+	}
+}
+
+// configure runs commands changing the chat's subscription.
+func (b *Bot) configure(ctx context.Context, msg *tg.Message, command, args string) (string, error) {
+	if b.store == nil {
+		return noStoreText, nil
+	}
+	allowed, err := b.canConfigure(ctx, msg)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return notAdminText, nil
+	}
+
+	key := targetOf(msg).String()
+	switch command {
+	case "subscribe":
+		return b.subscribe(msg, key, args)
+	case "unsubscribe":
+		found, err := b.store.DeleteSubscription(key)
+		if err != nil {
+			return "", fmt.Errorf("delete subscription: %w", err)
+		}
+		if !found {
+			return notSubscribeText, nil
+		}
+		return "Daily posts are stopped.", nil
+	case "time":
+		return b.setPostTime(key, args)
+	case "vars":
+		return b.setVars(key, args)
+	case "notify":
+		return b.setNotify(key, args)
+	default:
+		return "", fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// canConfigure reports whether the sender may change the subscription:
+// anyone in private chats, only administrators in groups.
+func (b *Bot) canConfigure(ctx context.Context, msg *tg.Message) (bool, error) {
+	if msg.Chat.Type == "private" {
+		return true, nil
+	}
+	if msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID {
+		// anonymous administrator
+		return true, nil
+	}
+	if msg.From == nil {
+		return false, nil
+	}
+
+	member, err := b.client.GetChatMember(ctx, strconv.FormatInt(msg.Chat.ID, 10), msg.From.ID)
+	if err != nil {
+		return false, fmt.Errorf("get chat member: %w", err)
+	}
+	return member.IsAdmin(), nil
+}
+
+func (b *Bot) subscribe(msg *tg.Message, key, args string) (string, error) {
+	loc, ok := resolvePlace(msg, args)
+	if !ok {
+		if args == "" {
+			return subscribeUsage, nil
+		}
+		return fmt.Sprintf("❓  Unknown place %q. %s", args, subscribeUsage), nil
+	}
+
+	target := targetOf(msg)
+	sub, ok := b.store.Subscription(key)
+	if !ok {
+		sub = state.Subscription{Time: defaultPostTime}
+	}
+	sub.ChatID, sub.ThreadID = target.ChatID, target.ThreadID
+	sub.Place, sub.Timezone = loc.Name, loc.Timezone
+	sub.Latitude, sub.Longitude = loc.Latitude, loc.Longitude
+
+	if err := b.store.SetSubscription(key, sub); err != nil {
+		return "", fmt.Errorf("save subscription: %w", err)
+	}
+	return fmt.Sprintf("✅  Daily air quality for %s (%s) at %s.", sub.Place, sub.Timezone, sub.Time), nil
+}
+
+func (b *Bot) setPostTime(key, args string) (string, error) {
+	at, err := time.Parse(postTimeLayout, args)
+	if err != nil {
+		return "Send the local time of daily posts like /time 08:00.", nil
+	}
+
+	postTime := at.Format(postTimeLayout)
+	found, err := b.store.UpdateSubscription(key, func(sub *state.Subscription) {
+		sub.Time = postTime
+	})
+	if err != nil {
+		return "", fmt.Errorf("save subscription: %w", err)
+	}
+	if !found {
+		return notSubscribeText, nil
+	}
+	return fmt.Sprintf("✅  Daily posts at %s.", postTime), nil
+}
+
+func (b *Bot) setVars(key, args string) (string, error) {
+	vars, problem := parseVars(args)
+	if problem != "" {
+		return problem, nil
+	}
+
+	found, err := b.store.UpdateSubscription(key, func(sub *state.Subscription) {
+		sub.Vars = vars
+	})
+	if err != nil {
+		return "", fmt.Errorf("save subscription: %w", err)
+	}
+	if !found {
+		return notSubscribeText, nil
+	}
+	return fmt.Sprintf("✅  Daily posts report %s.", varsLabel(vars)), nil
+}
+
+// setNotify sets the delivery policy of posts, "default" resets it.
+func (b *Bot) setNotify(key, args string) (string, error) {
+	policy, notify := delivery.DefaultPolicy, ""
+	if !strings.EqualFold(args, "default") {
+		var err error
+		if policy, err = delivery.ParsePolicy(args); err != nil {
+			return notifyUsage, nil
+		}
+		notify = policy.String()
+	}
+
+	found, err := b.store.UpdateSubscription(key, func(sub *state.Subscription) {
+		sub.Notify = notify
+	})
+	if err != nil {
+		return "", fmt.Errorf("save subscription: %w", err)
+	}
+	if !found {
+		return notSubscribeText, nil
+	}
+	return fmt.Sprintf("✅  Daily posts %s.", notifyLabel(policy)), nil
+}
+
+// subscriptionPolicy returns the delivery policy of the subscription.
+func subscriptionPolicy(sub state.Subscription) (delivery.Policy, error) {
+	if sub.Notify == "" {
+		return delivery.DefaultPolicy, nil
+	}
+	return delivery.ParsePolicy(sub.Notify)
+}
+
+// notifyLabel describes the policy. Act Now posts are always pinned.
+func notifyLabel(policy delivery.Policy) string {
+	return fmt.Sprintf("sound from %s, pinned from %s", policy.Audible, min(policy.Pinned, meteo.LevelActNow))
+}
+
+// parseVars parses a comma or space separated list of variables, "default" resets the list.
+// Invalid lists are reported with a problem to reply with.
+func parseVars(args string) (vars []string, problem string) {
+	if strings.EqualFold(args, "default") {
+		return nil, ""
+	}
+
+	for _, key := range strings.FieldsFunc(strings.ToLower(args), varsSeparator) {
+		if _, ok := view.Label(key); !ok {
+			return nil, fmt.Sprintf("❓  Unknown variable %q, e.g. %s.", key, strings.Join(CurrentVars[:3], ", "))
+		}
+		vars = append(vars, key)
+	}
+	if len(vars) == 0 {
+		return nil, "Send variables like /vars pm2_5,dust, or /vars default."
+	}
+	return vars, ""
+}
+
+func varsSeparator(ru rune) bool {
+	return ru == ',' || unicode.IsSpace(ru)
+}
+
+func varsLabel(vars []string) string {
+	if len(vars) == 0 {
+		vars = CurrentVars
+	}
+	labels := make([]string, 0, len(vars))
+	for _, key := range vars {
+		label, _ := view.Label(key)
+		labels = append(labels, label)
+	}
+	return strings.Join(labels, ", ")
+}
+
+// settings describes the chat's subscription.
+func (b *Bot) settings(msg *tg.Message) string {
+	if b.store == nil {
+		return noStoreText
+	}
+	sub, ok := b.store.Subscription(targetOf(msg).String())
+	if !ok {
+		return notSubscribeText
+	}
+	notify := "invalid, reset with /notify default"
+	if policy, err := subscriptionPolicy(sub); err == nil {
+		notify = notifyLabel(policy)
+	}
+	return fmt.Sprintf("⚙️  Daily posts\nPlace: %s (%s)\nTime: %s\nVariables: %s\nNotifications: %s",
+		sub.Place, sub.Timezone, sub.Time, varsLabel(sub.Vars), notify)
+}
+
+// resolvePlace finds the place of a /subscribe command: a shared location
+// the command replies to, coordinates or a city name.
+func resolvePlace(msg *tg.Message, args string) (Location, bool) {
+	if msg.ReplyToMessage != nil {
+		if loc, ok := sharedLocation(msg.ReplyToMessage); ok {
+			return loc, true
+		}
+	}
+	if loc, ok := parseCoordinates(args); ok {
+		return loc, true
+	}
+
+	places := timezones.Places(args)
+	if len(places) == 0 {
+		return Location{}, false
+	}
+	place := places[0]
+	return Location{
+		Name:      place.Name,
+		Latitude:  place.Latitude,
+		Longitude: place.Longitude,
+		Timezone:  place.Timezone,
+	}, true
+}
+
+// parseCoordinates parses "latitude,longitude".
+func parseCoordinates(s string) (Location, bool) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return Location{}, false
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return Location{}, false
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return Location{}, false
+	}
+	return pointLocation(latitude, longitude), true
+}
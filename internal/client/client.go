@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/ninedraft/daily-bacon/internal/redact"
 )
 
 // Client wraps http.Client.
@@ -45,7 +47,7 @@ func (c *Client) DoJSON(ctx context.Context, req *http.Request, dst any) error {
 	req = req.WithContext(ctx)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("do request url=%s: %w", req.URL, err)
+		return fmt.Errorf("do request url=%s: %w", redact.URL(req.URL), redact.New().Error(err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -2,8 +2,10 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -54,3 +56,25 @@ func TestClient_DoJSON_BadScheme(t *testing.T) {
 	err = c.DoJSON(t.Context(), req, nil)
 	require.ErrorIs(t, err, ErrBadResponseScheme)
 }
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestClient_DoJSON_RedactsURL(t *testing.T) {
+	const secret = "s3cret"
+
+	c := New(failingTransport{})
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "https://example.com/v1?apikey="+secret, nil)
+	require.NoError(t, err)
+
+	err = c.DoJSON(t.Context(), req, nil)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), secret)
+
+	var urlErr *url.Error
+	require.ErrorAs(t, err, &urlErr)
+	require.NotContains(t, urlErr.Error(), secret)
+}
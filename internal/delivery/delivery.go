@@ -0,0 +1,216 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// Telegram limits: about 30 messages per second overall
+// and 20 messages per minute to the same group.
+const (
+	DefaultGlobalRate  = rate.Limit(30)
+	DefaultPerChatRate = rate.Limit(20.0 / 60.0)
+
+	defaultWorkers     = 8
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+)
+
+// SendFunc delivers a message to a single chat. Bot API calls made with
+// ctx are retried by the Engine one by one, see tg.WithRetry.
+type SendFunc func(ctx context.Context, chatID string) error
+
+// Options configure Engine. Zero values are replaced with defaults.
+type Options struct {
+	// Workers is the number of chats served concurrently.
+	Workers int
+	// MaxAttempts is the number of attempts of every Bot API call.
+	MaxAttempts int
+	// Backoff is the initial delay between attempts when Telegram
+	// does not provide retry_after. It doubles after every attempt.
+	Backoff time.Duration
+	// GlobalRate limits Bot API calls across all chats.
+	GlobalRate rate.Limit
+	// PerChatRate limits Bot API calls to a single chat.
+	PerChatRate rate.Limit
+}
+
+// Result of delivery to a single chat.
+type Result struct {
+	ChatID string
+	// Attempts counts the first attempt and every repeated Bot API call.
+	Attempts int
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// Engine delivers messages to many chats with a bounded worker pool,
+// per-chat retries and Telegram rate limits.
+type Engine struct {
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	global      *rate.Limiter
+	perChatRate rate.Limit
+
+	mu      sync.Mutex
+	perChat map[string]*rate.Limiter
+}
+
+// New creates Engine.
+func New(opts Options) *Engine {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.GlobalRate <= 0 {
+		opts.GlobalRate = DefaultGlobalRate
+	}
+	if opts.PerChatRate <= 0 {
+		opts.PerChatRate = DefaultPerChatRate
+	}
+
+	return &Engine{
+		workers:     opts.Workers,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		global:      rate.NewLimiter(opts.GlobalRate, 1),
+		perChatRate: opts.PerChatRate,
+		perChat:     map[string]*rate.Limiter{},
+	}
+}
+
+// Deliver calls send for every chat and returns results in the order of chatIDs.
+func (e *Engine) Deliver(ctx context.Context, chatIDs []string, send SendFunc) []Result {
+	results := make([]Result, len(chatIDs))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(e.workers, len(chatIDs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = e.deliver(ctx, chatIDs[i], send)
+			}
+		}()
+	}
+
+	for i := range chatIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (e *Engine) deliver(ctx context.Context, chatID string, send SendFunc) Result {
+	result := Result{ChatID: chatID, Started: time.Now(), Attempts: 1}
+	defer func() { result.Duration = time.Since(result.Started) }()
+
+	// every Bot API call waits for the rate limits, including repeated ones
+	chatLimiter := e.chatLimiter(chatID)
+	ctx = tg.WithWait(ctx, func(ctx context.Context) error {
+		return e.wait(ctx, chatLimiter)
+	})
+
+	// failed Bot API calls are repeated one by one,
+	// so the parts of the message already sent are not sent again
+	ctx = tg.WithRetry(ctx, func(ctx context.Context, attempt int, err error) bool {
+		if attempt >= e.maxAttempts {
+			return false
+		}
+		delay, ok := e.retryDelay(err, attempt)
+		if !ok || !fitsDeadline(ctx, delay) {
+			return false
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+		result.Attempts++
+		return true
+	})
+	result.Err = send(ctx, chatID)
+	return result
+}
+
+// wait waits for the global and the chat's rate limits.
+func (e *Engine) wait(ctx context.Context, chatLimiter *rate.Limiter) error {
+	if err := e.global.Wait(ctx); err != nil {
+		return err
+	}
+	return chatLimiter.Wait(ctx)
+}
+
+// retryDelay reports whether err is worth retrying and how long to wait before.
+func (e *Engine) retryDelay(err error, attempt int) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	backoff := e.backoff << (attempt - 1)
+
+	var (
+		apiErr *tg.APIError
+		urlErr *url.Error
+		netErr net.Error
+	)
+	switch {
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		// transport failure, the request may not have reached Telegram
+		return backoff, true
+	case !errors.As(err, &apiErr):
+		// invalid payloads fail the same way every time
+		return 0, false
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter, true
+		}
+		return backoff, true
+	case apiErr.Code >= http.StatusInternalServerError:
+		return backoff, true
+	default:
+		return 0, false
+	}
+}
+
+func (e *Engine) chatLimiter(chatID string) *rate.Limiter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	limiter, ok := e.perChat[chatID]
+	if !ok {
+		limiter = rate.NewLimiter(e.perChatRate, 1)
+		e.perChat[chatID] = limiter
+	}
+	return limiter
+}
+
+func fitsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > delay
+}
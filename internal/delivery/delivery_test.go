@@ -0,0 +1,337 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
+)
+
+func newTestEngine(workers int) *Engine {
+	return New(Options{
+		Workers:     workers,
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		GlobalRate:  rate.Inf,
+		PerChatRate: rate.Inf,
+	})
+}
+
+func newTestClient(t *testing.T) (*tg.Client, *tgtest.Server) {
+	srv := tgtest.NewServer(t)
+	return tg.New(tg.WithToken("tok"), tg.WithAPIURL(srv.URL), tg.WithDoer(srv.Client())), srv
+}
+
+func TestEngine_Deliver_RetryAfter(t *testing.T) {
+	engine := newTestEngine(2)
+	client, srv := newTestClient(t)
+	srv.Fail("sendMessage", tgtest.TooManyRequests(0))
+
+	results := engine.Deliver(t.Context(), []string{"1"}, func(ctx context.Context, chatID string) error {
+		_, err := client.SendMessage(ctx, chatID, "hello")
+		return err
+	})
+
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 2, results[0].Attempts)
+	require.Len(t, srv.Messages("1"), 1)
+}
+
+func TestEngine_Deliver_RetryCall(t *testing.T) {
+	engine := newTestEngine(1)
+	client, srv := newTestClient(t)
+	srv.Fail("sendMessage", tgtest.Fault{Code: http.StatusBadGateway, Description: "Bad Gateway"})
+	srv.Fail("pinChatMessage", tgtest.Fault{Code: http.StatusInternalServerError, Description: "Internal Server Error"})
+
+	results := engine.Deliver(t.Context(), []string{"1"}, func(ctx context.Context, chatID string) error {
+		photo := tg.MediaUpload{Type: tg.MediaPhoto, Reader: strings.NewReader("photo")}
+		if _, err := client.SendPhoto(ctx, chatID, photo); err != nil {
+			return err
+		}
+		sent, err := client.SendText(ctx, chatID, "hello")
+		if err != nil {
+			return err
+		}
+		return client.PinChatMessage(ctx, chatID, sent[0].MessageID, false)
+	})
+
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 3, results[0].Attempts)
+
+	// the photo is not sent again when the text fails
+	messages := srv.Messages("1")
+	require.Len(t, messages, 2)
+	require.Equal(t, "sendPhoto", messages[0].Method)
+	require.Equal(t, "hello", messages[1].Text)
+	require.True(t, messages[1].Pinned)
+	require.Equal(t, 1, srv.Calls("sendPhoto"))
+}
+
+func TestEngine_Deliver_RateLimitEveryCall(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	engine := New(Options{GlobalRate: rate.Inf, PerChatRate: rate.Every(interval)})
+	client, srv := newTestClient(t)
+
+	started := time.Now()
+	results := engine.Deliver(t.Context(), []string{"1"}, func(ctx context.Context, chatID string) error {
+		photo := tg.MediaUpload{Type: tg.MediaPhoto, Reader: strings.NewReader("photo")}
+		if _, err := client.SendPhoto(ctx, chatID, photo); err != nil {
+			return err
+		}
+		sent, err := client.SendText(ctx, chatID, "hello")
+		if err != nil {
+			return err
+		}
+		return client.PinChatMessage(ctx, chatID, sent[0].MessageID, false)
+	})
+
+	require.NoError(t, results[0].Err)
+	require.Len(t, srv.Messages("1"), 2)
+	require.GreaterOrEqual(t, time.Since(started), 2*interval, "the text and the pin wait for the chat limit")
+}
+
+func TestEngine_Deliver_NoRetryOnClientError(t *testing.T) {
+	engine := newTestEngine(2)
+	client, srv := newTestClient(t)
+	srv.Block("2")
+
+	results := engine.Deliver(t.Context(), []string{"1", "2"}, func(ctx context.Context, chatID string) error {
+		_, err := client.SendMessage(ctx, chatID, "hello")
+		return err
+	})
+
+	require.Len(t, results, 2)
+	require.Equal(t, "1", results[0].ChatID)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "2", results[1].ChatID)
+	var apiErr *tg.APIError
+	require.ErrorAs(t, results[1].Err, &apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.Code)
+	require.Equal(t, 1, results[1].Attempts)
+}
+
+type failingDoer struct {
+	calls atomic.Int32
+}
+
+func (d *failingDoer) Do(req *http.Request) (*http.Response, error) {
+	d.calls.Add(1)
+	return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("connection reset")}
+}
+
+func TestEngine_Deliver_MaxAttempts(t *testing.T) {
+	engine := newTestEngine(1)
+	doer := &failingDoer{}
+	client := tg.New(tg.WithToken("tok"), tg.WithDoer(doer))
+
+	results := engine.Deliver(t.Context(), []string{"1"}, func(ctx context.Context, chatID string) error {
+		_, err := client.SendMessage(ctx, chatID, "hello")
+		return err
+	})
+
+	var urlErr *url.Error
+	require.ErrorAs(t, results[0].Err, &urlErr)
+	require.Equal(t, 3, results[0].Attempts)
+	require.EqualValues(t, 3, doer.calls.Load())
+}
+
+func TestEngine_Deliver_NoRetryOnInvalidPayload(t *testing.T) {
+	engine := newTestEngine(1)
+	doer := &failingDoer{}
+	client := tg.New(tg.WithToken("tok"), tg.WithDoer(doer))
+
+	results := engine.Deliver(t.Context(), []string{"1"}, func(ctx context.Context, chatID string) error {
+		// an album of a single file is rejected before sending
+		_, err := client.SendMediaGroup(ctx, chatID, []tg.MediaUpload{{Reader: strings.NewReader("photo")}})
+		return err
+	})
+
+	require.Error(t, results[0].Err)
+	require.Equal(t, 1, results[0].Attempts)
+	require.Zero(t, doer.calls.Load())
+
+	errInvalid := errors.New("text is too long")
+	results = engine.Deliver(t.Context(), []string{"1"}, func(context.Context, string) error {
+		return errInvalid
+	})
+	require.ErrorIs(t, results[0].Err, errInvalid)
+	require.Equal(t, 1, results[0].Attempts)
+}
+
+func TestEngine_Deliver_Workers(t *testing.T) {
+	const workers = 3
+	engine := newTestEngine(workers)
+
+	var (
+		mu           sync.Mutex
+		active, peak int
+		chats        = []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+		delivered    atomic.Int32
+	)
+	results := engine.Deliver(t.Context(), chats, func(context.Context, string) error {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		delivered.Add(1)
+		return nil
+	})
+
+	require.Len(t, results, len(chats))
+	require.EqualValues(t, len(chats), delivered.Load())
+	require.LessOrEqual(t, peak, workers)
+}
+
+func TestEngine_Deliver_RetryAfterExceedsDeadline(t *testing.T) {
+	engine := newTestEngine(1)
+	client, srv := newTestClient(t)
+	srv.Fail("sendMessage", tgtest.TooManyRequests(time.Minute))
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+
+	results := engine.Deliver(ctx, []string{"1"}, func(ctx context.Context, chatID string) error {
+		_, err := client.SendMessage(ctx, chatID, "hello")
+		return err
+	})
+
+	require.Error(t, results[0].Err)
+	require.Equal(t, 1, srv.Calls("sendMessage"))
+}
+
+func TestPolicy_Notify(t *testing.T) {
+	policy := DefaultPolicy
+	require.Equal(t, Notification{Silent: true}, policy.Notify(meteo.LevelGood))
+	require.Equal(t, Notification{}, policy.Notify(meteo.LevelWatch))
+	require.Equal(t, Notification{}, policy.Notify(meteo.LevelLimitExceeded))
+	require.Equal(t, Notification{Pin: true}, policy.Notify(meteo.LevelActNow))
+
+	quiet, err := ParsePolicy("act-now")
+	require.NoError(t, err)
+	require.Equal(t, Notification{Silent: true}, quiet.Notify(meteo.LevelLimitExceeded))
+	require.Equal(t, Notification{Pin: true}, quiet.Notify(meteo.LevelActNow), "act now is never silenced")
+
+	loud, err := ParsePolicy("good:watch")
+	require.NoError(t, err)
+	require.Equal(t, Notification{}, loud.Notify(meteo.LevelGood))
+	require.Equal(t, Notification{Pin: true}, loud.Notify(meteo.LevelWatch))
+	require.Equal(t, "good:watch", loud.String())
+
+	var decoded Policy
+	require.NoError(t, decoded.UnmarshalText([]byte(loud.String())))
+	require.Equal(t, loud, decoded)
+
+	_, err = ParsePolicy("loud")
+	require.Error(t, err)
+	_, err = ParsePolicy("watch:never")
+	require.Error(t, err)
+	_, err = ParsePolicy("act-now:good")
+	require.ErrorContains(t, err, "pinned level is below the audible level")
+	require.Error(t, decoded.UnmarshalText([]byte("limit-exceeded:watch")))
+
+	same, err := ParsePolicy("watch:watch")
+	require.NoError(t, err)
+	require.Equal(t, Notification{Pin: true}, same.Notify(meteo.LevelWatch))
+}
+
+func TestNotification_PinAlert(t *testing.T) {
+	ctx := t.Context()
+	logger := slog.New(slog.DiscardHandler)
+	client, srv := newTestClient(t)
+
+	send := func() int {
+		sent, err := client.SendText(ctx, "1", "report")
+		require.NoError(t, err)
+		return sent[0].MessageID
+	}
+
+	Notification{Silent: true}.PinAlert(ctx, logger, client, "1", send())
+	Notification{Pin: true}.PinAlert(ctx, logger, client, "1", send())
+	Pin(ctx, logger, client, "1", send(), false)
+
+	messages := srv.Messages("1")
+	require.Len(t, messages, 3)
+	require.False(t, messages[0].Pinned)
+	require.True(t, messages[1].Pinned)
+	require.False(t, messages[1].PinnedSilently, "alerts are pinned with a notification")
+	require.True(t, messages[2].Pinned)
+	require.True(t, messages[2].PinnedSilently)
+
+	// the message is delivered anyway, failures to pin are only logged
+	srv.Fail("pinChatMessage", tgtest.Fault{Code: http.StatusBadRequest, Description: "Bad Request: not enough rights"})
+	Notification{Pin: true}.PinAlert(ctx, logger, client, "1", send())
+	require.False(t, srv.Messages("1")[3].Pinned)
+}
+
+func TestQuietHours(t *testing.T) {
+	quiet, err := ParseQuietHours("22:00-07:00 Europe/Berlin")
+	require.NoError(t, err)
+	require.Equal(t, "22:00-07:00 Europe/Berlin", quiet.String())
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		now   time.Time
+		until time.Time
+		quiet bool
+	}{
+		{now: at(5, 1, 21, 59)},
+		{now: at(5, 1, 22, 0), until: at(5, 2, 7, 0), quiet: true},
+		{now: at(5, 2, 3, 0), until: at(5, 2, 7, 0), quiet: true},
+		{now: at(5, 2, 7, 0)},
+		{now: at(5, 2, 12, 0)},
+		// the window ends at 07:00 local time across the DST switch
+		{now: at(3, 29, 23, 0), until: at(3, 30, 7, 0), quiet: true},
+		// evaluated in the chat's timezone, not in the timezone of now
+		{now: time.Date(2025, 5, 1, 20, 30, 0, 0, time.UTC), until: at(5, 2, 7, 0), quiet: true},
+	}
+	for _, test := range tests {
+		until, ok := quiet.Until(test.now)
+		require.Equal(t, test.quiet, ok, test.now)
+		require.True(t, test.until.Equal(until), "%v: until %v, want %v", test.now, until, test.until)
+	}
+
+	_, deferred := quiet.Defer(at(5, 2, 3, 0), meteo.LevelActNow)
+	require.False(t, deferred, "act now is never deferred")
+	until, deferred := quiet.Defer(at(5, 2, 3, 0), meteo.LevelLimitExceeded)
+	require.True(t, deferred)
+	require.True(t, at(5, 2, 7, 0).Equal(until))
+
+	var none *QuietHours
+	_, deferred = none.Defer(at(5, 2, 3, 0), meteo.LevelGood)
+	require.False(t, deferred)
+
+	day, err := ParseQuietHours("13:00-15:00 Asia/Nicosia")
+	require.NoError(t, err)
+	_, ok := day.Until(at(5, 2, 12, 30))
+	require.True(t, ok, "12:30 in Berlin is 13:30 in Nicosia")
+
+	for _, s := range []string{"22:00-07:00", "22:00 Europe/Berlin", "22:00-25:00 Europe/Berlin", "07:00-07:00 UTC", "22:00-07:00 Mars/Olympus", "22:00-07:00 Local"} {
+		_, err := ParseQuietHours(s)
+		require.Error(t, err, s)
+	}
+}
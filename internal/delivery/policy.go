@@ -0,0 +1,103 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// Policy decides how a report is delivered to a chat by its worst level.
+// Act Now reports are always pinned and delivered with a notification.
+type Policy struct {
+	// Audible is the lowest level delivered with a notification sound.
+	Audible meteo.Level
+	// Pinned is the lowest level pinned in the chat.
+	Pinned meteo.Level
+}
+
+// DefaultPolicy delivers Good reports silently, Watch and above normally,
+// and pins Act Now reports.
+var DefaultPolicy = Policy{Audible: meteo.LevelWatch, Pinned: meteo.LevelActNow}
+
+// Notification is how a single report is delivered: with tg.Silent
+// and pinned with a notification if Pin is set.
+type Notification struct {
+	Silent bool
+	Pin    bool
+}
+
+// Notify returns the notification of a report with the worst level.
+func (p Policy) Notify(level meteo.Level) Notification {
+	if level >= meteo.LevelActNow {
+		return Notification{Pin: true}
+	}
+	return Notification{
+		Silent: level < p.Audible,
+		Pin:    level >= p.Pinned,
+	}
+}
+
+// PinAlert pins the delivered message with a notification if Pin is set.
+// See Pin for failures.
+func (n Notification) PinAlert(ctx context.Context, logger *slog.Logger, client *tg.Client, chatID string, messageID int) {
+	if n.Pin {
+		Pin(ctx, logger, client, chatID, messageID, true)
+	}
+}
+
+// Pin pins the delivered message, with a notification if notify is set.
+// The message is delivered anyway, so failures are only logged:
+// pinning requires admin rights.
+func Pin(ctx context.Context, logger *slog.Logger, client *tg.Client, chatID string, messageID int, notify bool) {
+	if err := client.PinChatMessage(ctx, chatID, messageID, !notify); err != nil {
+		logger.WarnContext(ctx, "pin message", "chat", chatID, "message_id", messageID, "err", err)
+	}
+}
+
+// ParsePolicy parses "audible[:pinned]" levels, e.g. "watch" or "good:limit-exceeded".
+// The pinned level defaults to act-now and can't be below the audible level.
+func ParsePolicy(s string) (Policy, error) {
+	audible, pinned, ok := strings.Cut(s, ":")
+	policy := DefaultPolicy
+
+	var err error
+	if policy.Audible, err = meteo.ParseLevel(audible); err != nil {
+		return Policy{}, fmt.Errorf("policy %q: audible: %w", s, err)
+	}
+	if !ok {
+		return policy, nil
+	}
+	if policy.Pinned, err = meteo.ParseLevel(pinned); err != nil {
+		return Policy{}, fmt.Errorf("policy %q: pinned: %w", s, err)
+	}
+	// pins notify, so silent reports must not be pinned
+	if policy.Pinned < policy.Audible {
+		return Policy{}, fmt.Errorf("policy %q: pinned level is below the audible level", s)
+	}
+	return policy, nil
+}
+
+func (p Policy) String() string {
+	audible, _ := p.Audible.MarshalText()
+	pinned, _ := p.Pinned.MarshalText()
+	return string(audible) + ":" + string(pinned)
+}
+
+// MarshalText encodes the policy like ParsePolicy accepts it.
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes the policy, see ParsePolicy.
+func (p *Policy) UnmarshalText(text []byte) error {
+	policy, err := ParsePolicy(string(text))
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
@@ -0,0 +1,105 @@
+package delivery
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/timezones"
+)
+
+const quietTimeLayout = "15:04"
+
+// QuietHours is a daily window in the chat's timezone when only Act Now
+// reports are delivered. Windows may span midnight, e.g. 22:00-07:00.
+type QuietHours struct {
+	start, end time.Time // clock times of the window, dates are ignored
+	location   *time.Location
+}
+
+// ParseQuietHours parses a window with an IANA timezone, e.g. "22:00-07:00 Europe/Berlin".
+func ParseQuietHours(s string) (*QuietHours, error) {
+	window, timezone, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return nil, fmt.Errorf("quiet hours %q: want a window with a timezone like 22:00-07:00 Europe/Berlin", s)
+	}
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet hours %q: want a window like 22:00-07:00", s)
+	}
+
+	var (
+		q   QuietHours
+		err error
+	)
+	if q.start, err = time.Parse(quietTimeLayout, from); err != nil {
+		return nil, fmt.Errorf("quiet hours %q: start: %w", s, err)
+	}
+	if q.end, err = time.Parse(quietTimeLayout, to); err != nil {
+		return nil, fmt.Errorf("quiet hours %q: end: %w", s, err)
+	}
+	if q.start.Equal(q.end) {
+		return nil, fmt.Errorf("quiet hours %q: window is empty", s)
+	}
+	if q.location, err = timezones.Location(strings.TrimSpace(timezone)); err != nil {
+		return nil, fmt.Errorf("quiet hours %q: %w", s, err)
+	}
+	return &q, nil
+}
+
+// Until returns the end of the window if t is within it.
+func (q *QuietHours) Until(t time.Time) (time.Time, bool) {
+	if q == nil {
+		return time.Time{}, false
+	}
+
+	local := t.In(q.location)
+	// the window containing t started either today or yesterday
+	for _, day := range []int{0, -1} {
+		start := q.clock(local, day, q.start)
+		end := q.clock(local, day, q.end)
+		if !end.After(start) {
+			end = q.clock(local, day+1, q.end)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Defer returns the time to deliver a report with the level at, if it
+// falls within the window. Act Now reports are never deferred.
+func (q *QuietHours) Defer(t time.Time, level meteo.Level) (time.Time, bool) {
+	if level >= meteo.LevelActNow {
+		return time.Time{}, false
+	}
+	return q.Until(t)
+}
+
+func (q *QuietHours) clock(local time.Time, day int, at time.Time) time.Time {
+	return time.Date(local.Year(), local.Month(), local.Day()+day, at.Hour(), at.Minute(), 0, 0, q.location)
+}
+
+func (q *QuietHours) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.start.Format(quietTimeLayout) + "-" + q.end.Format(quietTimeLayout) + " " + q.location.String()
+}
+
+// MarshalText encodes the window like ParseQuietHours accepts it.
+func (q *QuietHours) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText decodes the window, see ParseQuietHours.
+func (q *QuietHours) UnmarshalText(text []byte) error {
+	parsed, err := ParseQuietHours(string(text))
+	if err != nil {
+		return err
+	}
+	*q = *parsed
+	return nil
+}
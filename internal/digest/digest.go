@@ -0,0 +1,274 @@
+package digest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/models"
+)
+
+// Period of a digest.
+type Period string
+
+const (
+	// Week covers the last 7 days.
+	Week Period = "week"
+	// Month covers the last 30 days.
+	Month Period = "month"
+)
+
+// ParsePeriod parses period name.
+func ParsePeriod(name string) (Period, error) {
+	switch p := Period(strings.ToLower(name)); p {
+	case Week, Month:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown period %q", name)
+	}
+}
+
+// Days returns number of days covered by the period.
+func (p Period) Days() int {
+	if p == Month {
+		return 30
+	}
+	return 7
+}
+
+// Pollen lists pollen variables tracked by the season progress.
+var Pollen = []string{
+	meteo.AlderPollen,
+	meteo.BirchPollen,
+	meteo.GrassPollen,
+	meteo.MugwortPollen,
+	meteo.OlivePollen,
+	meteo.RagweedPollen,
+}
+
+const hourlyTimeLayout = "2006-01-02T15:04"
+
+// Sample is a set of variable values observed at a moment.
+type Sample struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// FromHourly converts hourly series of the response into samples.
+func FromHourly(resp models.AirQualityResponse) ([]Sample, error) {
+	if resp.Hourly == nil {
+		return nil, nil
+	}
+
+	loc := time.FixedZone(resp.Timezone, resp.UTCOffsetSeconds)
+	series := meteo.Series(resp.Hourly)
+
+	samples := make([]Sample, 0, len(resp.Hourly.Time))
+	for i, raw := range resp.Hourly.Time {
+		at, err := time.ParseInLocation(hourlyTimeLayout, raw, loc)
+		if err != nil {
+			return nil, fmt.Errorf("parse hourly time %d: %w", i, err)
+		}
+		values := map[string]float64{}
+		for key, column := range series {
+			if i < len(column) {
+				values[key] = column[i]
+			}
+		}
+		samples = append(samples, Sample{Time: at, Values: values})
+	}
+	return samples, nil
+}
+
+// Summary of air quality over a period.
+type Summary struct {
+	Period   Period
+	From, To time.Time
+	Days     []Day
+	Bands    map[meteo.Level]int
+	Worst    Day
+	Peaks    []Peak
+	Pollen   []PollenSeason
+	Units    map[string]string
+}
+
+// Day is the worst observation of a calendar day.
+type Day struct {
+	Date  time.Time
+	Level meteo.Level
+	Key   string
+	Value float64
+}
+
+// Peak is the maximum value of a variable.
+type Peak struct {
+	Key   string
+	Value float64
+	At    time.Time
+	Level meteo.Level
+}
+
+// PollenSeason describes progress of a pollen season within the period.
+type PollenSeason struct {
+	Key        string
+	ActiveDays int
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Peak       Peak
+	Trend      Trend
+}
+
+// Trend of a variable over the last days of a period.
+type Trend int
+
+const (
+	// TrendFlat means no significant change.
+	TrendFlat Trend = iota
+	// TrendRising means values grow.
+	TrendRising
+	// TrendFalling means values decline.
+	TrendFalling
+)
+
+// String returns the textual representation of the Trend.
+func (t Trend) String() string {
+	switch t {
+	case TrendRising:
+		return "rising"
+	case TrendFalling:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
+
+const trendDays = 3
+
+// Summarize builds a summary of samples in [to-period, to) for given variables.
+// Samples outside of the period and variables without data are ignored.
+func Summarize(period Period, to time.Time, vars []string, samples []Sample, units map[string]string) Summary {
+	from := to.AddDate(0, 0, -period.Days())
+	summary := Summary{
+		Period: period,
+		From:   from,
+		To:     to,
+		Bands:  map[meteo.Level]int{},
+		Units:  units,
+	}
+
+	samples = slices.DeleteFunc(slices.Clone(samples), func(s Sample) bool {
+		return s.Time.Before(from) || !s.Time.Before(to)
+	})
+	slices.SortFunc(samples, func(a, b Sample) int { return a.Time.Compare(b.Time) })
+
+	peaks := map[string]Peak{}
+	for _, sample := range samples {
+		date := truncateDay(sample.Time)
+		if len(summary.Days) == 0 || !summary.Days[len(summary.Days)-1].Date.Equal(date) {
+			summary.Days = append(summary.Days, Day{Date: date})
+		}
+		day := &summary.Days[len(summary.Days)-1]
+
+		for _, key := range vars {
+			value, ok := sample.Values[key]
+			if !ok {
+				continue
+			}
+			level := meteo.LevelOf(key, value)
+			if day.Key == "" || level > day.Level {
+				day.Level, day.Key, day.Value = level, key, value
+			}
+			if peak, ok := peaks[key]; !ok || value > peak.Value {
+				peaks[key] = Peak{Key: key, Value: value, At: sample.Time, Level: level}
+			}
+		}
+	}
+
+	for i, day := range summary.Days {
+		summary.Bands[day.Level]++
+		if i == 0 || day.Level > summary.Worst.Level {
+			summary.Worst = day
+		}
+	}
+
+	for _, key := range vars {
+		if peak, ok := peaks[key]; ok && !slices.Contains(Pollen, key) {
+			summary.Peaks = append(summary.Peaks, peak)
+		}
+	}
+
+	for _, key := range Pollen {
+		if !slices.Contains(vars, key) {
+			continue
+		}
+		if season, ok := pollenSeason(key, samples); ok {
+			summary.Pollen = append(summary.Pollen, season)
+		}
+	}
+
+	return summary
+}
+
+func pollenSeason(key string, samples []Sample) (PollenSeason, bool) {
+	season := PollenSeason{Key: key}
+	daily := map[time.Time]float64{}
+	var dates []time.Time
+
+	for _, sample := range samples {
+		value, ok := sample.Values[key]
+		if !ok || value <= 0 {
+			continue
+		}
+		date := truncateDay(sample.Time)
+		if _, seen := daily[date]; !seen {
+			dates = append(dates, date)
+		}
+		daily[date] = max(daily[date], value)
+
+		if season.FirstSeen.IsZero() {
+			season.FirstSeen = sample.Time
+		}
+		season.LastSeen = sample.Time
+		if value > season.Peak.Value {
+			season.Peak = Peak{Key: key, Value: value, At: sample.Time, Level: meteo.LevelOf(key, value)}
+		}
+	}
+	if len(dates) == 0 {
+		return season, false
+	}
+	season.ActiveDays = len(dates)
+	season.Trend = trend(dates, daily)
+	return season, true
+}
+
+func trend(dates []time.Time, daily map[time.Time]float64) Trend {
+	if len(dates) < 2*trendDays {
+		return TrendFlat
+	}
+	mean := func(dates []time.Time) float64 {
+		var sum float64
+		for _, date := range dates {
+			sum += daily[date]
+		}
+		return sum / float64(len(dates))
+	}
+	recent := mean(dates[len(dates)-trendDays:])
+	before := mean(dates[len(dates)-2*trendDays : len(dates)-trendDays])
+
+	const threshold = 0.2
+	switch {
+	case recent > before*(1+threshold):
+		return TrendRising
+	case recent < before*(1-threshold):
+		return TrendFalling
+	default:
+		return TrendFlat
+	}
+}
+
+func truncateDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,61 @@
+package digest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+	"github.com/ninedraft/daily-bacon/internal/models"
+)
+
+func TestFromHourly(t *testing.T) {
+	samples, err := FromHourly(models.AirQualityResponse{
+		Timezone:         "EET",
+		UTCOffsetSeconds: 2 * 3600,
+		Hourly: &models.HourlyData{
+			Time: []string{"2025-05-01T00:00", "2025-05-01T01:00"},
+			PM10: []float64{10, 20},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	require.Equal(t, time.Date(2025, 4, 30, 23, 0, 0, 0, time.UTC), samples[1].Time.UTC())
+	require.InDelta(t, 20.0, samples[1].Values[meteo.PM10], 0)
+}
+
+func TestSummarize(t *testing.T) {
+	to := time.Date(2025, 5, 8, 0, 0, 0, 0, time.UTC)
+	at := func(day, hour int) time.Time {
+		return time.Date(2025, 5, day, hour, 0, 0, 0, time.UTC)
+	}
+	samples := []Sample{
+		{Time: at(1, 0), Values: map[string]float64{meteo.PM10: 5, meteo.OlivePollen: 0}},
+		{Time: at(2, 12), Values: map[string]float64{meteo.PM10: 30, meteo.OlivePollen: 10}},
+		{Time: at(3, 14), Values: map[string]float64{meteo.PM10: 150, meteo.OlivePollen: 40}},
+		{Time: at(3, 15), Values: map[string]float64{meteo.PM10: 10, meteo.OlivePollen: 5}},
+		// outside of the period
+		{Time: at(9, 0), Values: map[string]float64{meteo.PM10: 1000}},
+	}
+	vars := []string{meteo.PM10, meteo.OlivePollen}
+
+	summary := Summarize(Week, to, vars, samples, nil)
+
+	require.Len(t, summary.Days, 3)
+	require.Equal(t, 1, summary.Bands[meteo.LevelGood])
+	require.Equal(t, 1, summary.Bands[meteo.LevelWatch])
+	require.Equal(t, 1, summary.Bands[meteo.LevelActNow])
+
+	require.Equal(t, at(3, 0), summary.Worst.Date)
+	require.Equal(t, meteo.PM10, summary.Worst.Key)
+
+	require.Len(t, summary.Peaks, 1)
+	require.InDelta(t, 150.0, summary.Peaks[0].Value, 0)
+	require.Equal(t, at(3, 14), summary.Peaks[0].At)
+
+	require.Len(t, summary.Pollen, 1)
+	require.Equal(t, 2, summary.Pollen[0].ActiveDays)
+	require.Equal(t, at(2, 12), summary.Pollen[0].FirstSeen)
+	require.InDelta(t, 40.0, summary.Pollen[0].Peak.Value, 0)
+}
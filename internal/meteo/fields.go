@@ -0,0 +1,108 @@
+package meteo
+
+import "github.com/ninedraft/daily-bacon/internal/models"
+
+// field points to a variable in the response types of models.
+type field struct {
+	key         string
+	hourly      *[]float64
+	hourlyUnit  *string
+	current     *float64
+	currentUnit *string
+}
+
+// fieldsOf returns the fields of every variable in the response values.
+// Nil values are read as empty ones.
+func fieldsOf(h *models.HourlyData, hu *models.HourlyUnits, c *models.CurrentData, cu *models.CurrentUnits) []field {
+	h, hu = orZero(h), orZero(hu)
+	c, cu = orZero(c), orZero(cu)
+	return []field{
+		{PM10, &h.PM10, &hu.PM10, &c.PM10, &cu.PM10},
+		{PM2_5, &h.PM25, &hu.PM25, &c.PM25, &cu.PM25},
+		{CarbonMonoxide, &h.CarbonMonoxide, &hu.CarbonMonoxide, &c.CarbonMonoxide, &cu.CarbonMonoxide},
+		{CarbonDioxide, &h.CarbonDioxide, &hu.CarbonDioxide, &c.CarbonDioxide, &cu.CarbonDioxide},
+		{NitrogenDioxide, &h.NitrogenDioxide, &hu.NitrogenDioxide, &c.NitrogenDioxide, &cu.NitrogenDioxide},
+		{SulphurDioxide, &h.SulphurDioxide, &hu.SulphurDioxide, &c.SulphurDioxide, &cu.SulphurDioxide},
+		{Ozone, &h.Ozone, &hu.Ozone, &c.Ozone, &cu.Ozone},
+		{AerosolOpticalDepth, &h.AerosolOpticalDepth, &hu.AerosolOpticalDepth, &c.AerosolOpticalDepth, &cu.AerosolOpticalDepth},
+		{Dust, &h.Dust, &hu.Dust, &c.Dust, &cu.Dust},
+		{UVIndex, &h.UVIndex, &hu.UVIndex, &c.UVIndex, &cu.UVIndex},
+		{UVIndexClearSky, &h.UVIndexClearSky, &hu.UVIndexClearSky, &c.UVIndexClearSky, &cu.UVIndexClearSky},
+		{Ammonia, &h.Ammonia, &hu.Ammonia, &c.Ammonia, &cu.Ammonia},
+		{Methane, &h.Methane, &hu.Methane, &c.Methane, &cu.Methane},
+		{AlderPollen, &h.AlderPollen, &hu.AlderPollen, &c.AlderPollen, &cu.AlderPollen},
+		{BirchPollen, &h.BirchPollen, &hu.BirchPollen, &c.BirchPollen, &cu.BirchPollen},
+		{GrassPollen, &h.GrassPollen, &hu.GrassPollen, &c.GrassPollen, &cu.GrassPollen},
+		{MugwortPollen, &h.MugwortPollen, &hu.MugwortPollen, &c.MugwortPollen, &cu.MugwortPollen},
+		{OlivePollen, &h.OlivePollen, &hu.OlivePollen, &c.OlivePollen, &cu.OlivePollen},
+		{RagweedPollen, &h.RagweedPollen, &hu.RagweedPollen, &c.RagweedPollen, &cu.RagweedPollen},
+		{EuropeanAQI, &h.EuropeanAQI, &hu.EuropeanAQI, &c.EuropeanAQI, &cu.EuropeanAQI},
+		{EuropeanAQI_PM2_5, &h.EuropeanAQIPM25, &hu.EuropeanAQIPM25, &c.EuropeanAQIPM25, &cu.EuropeanAQIPM25},
+		{EuropeanAQI_PM10, &h.EuropeanAQIPM10, &hu.EuropeanAQIPM10, &c.EuropeanAQIPM10, &cu.EuropeanAQIPM10},
+		{EuropeanAQI_NitrogenDioxide, &h.EuropeanAQINO2, &hu.EuropeanAQINO2, &c.EuropeanAQINO2, &cu.EuropeanAQINO2},
+		{EuropeanAQI_Ozone, &h.EuropeanAQIOzone, &hu.EuropeanAQIOzone, &c.EuropeanAQIOzone, &cu.EuropeanAQIOzone},
+		{EuropeanAQI_SulphurDioxide, &h.EuropeanAQISO2, &hu.EuropeanAQISO2, &c.EuropeanAQISO2, &cu.EuropeanAQISO2},
+		{USAQI, &h.USAQI, &hu.USAQI, &c.USAQI, &cu.USAQI},
+		{USAQI_PM2_5, &h.USAQIPM25, &hu.USAQIPM25, &c.USAQIPM25, &cu.USAQIPM25},
+		{USAQI_PM10, &h.USAQIPM10, &hu.USAQIPM10, &c.USAQIPM10, &cu.USAQIPM10},
+		{USAQI_NitrogenDioxide, &h.USAQINO2, &hu.USAQINO2, &c.USAQINO2, &cu.USAQINO2},
+		{USAQI_Ozone, &h.USAQIOzone, &hu.USAQIOzone, &c.USAQIOzone, &cu.USAQIOzone},
+		{USAQI_SulphurDioxide, &h.USAQISO2, &hu.USAQISO2, &c.USAQISO2, &cu.USAQISO2},
+		{USAQI_CarbonMonoxide, &h.USAQICarbonMonoxide, &hu.USAQICarbonMonoxide, &c.USAQICarbonMonoxide, &cu.USAQICarbonMonoxide},
+	}
+}
+
+func orZero[T any](v *T) *T {
+	if v == nil {
+		return new(T)
+	}
+	return v
+}
+
+// Series returns hourly values keyed by variable.
+func Series(h *models.HourlyData) map[string][]float64 {
+	if h == nil {
+		return nil
+	}
+	series := map[string][]float64{}
+	for _, f := range fieldsOf(h, nil, nil, nil) {
+		series[f.key] = *f.hourly
+	}
+	return series
+}
+
+// HourlyUnits returns hourly units keyed by variable.
+func HourlyUnits(u *models.HourlyUnits) map[string]string {
+	if u == nil {
+		return nil
+	}
+	units := map[string]string{}
+	for _, f := range fieldsOf(nil, u, nil, nil) {
+		units[f.key] = *f.hourlyUnit
+	}
+	return units
+}
+
+// Values returns current values keyed by variable.
+func Values(c *models.CurrentData) map[string]float64 {
+	if c == nil {
+		return nil
+	}
+	values := map[string]float64{}
+	for _, f := range fieldsOf(nil, nil, c, nil) {
+		values[f.key] = *f.current
+	}
+	return values
+}
+
+// CurrentUnits returns current units keyed by variable.
+func CurrentUnits(u *models.CurrentUnits) map[string]string {
+	if u == nil {
+		return nil
+	}
+	units := map[string]string{}
+	for _, f := range fieldsOf(nil, nil, nil, u) {
+		units[f.key] = *f.currentUnit
+	}
+	return units
+}
@@ -0,0 +1,61 @@
+package meteo
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ninedraft/daily-bacon/internal/models"
+)
+
+// TestFields checks that every variable is keyed by the JSON name of its fields.
+func TestFields(t *testing.T) {
+	var (
+		hourly      models.HourlyData
+		hourlyUnits models.HourlyUnits
+		current     models.CurrentData
+		units       models.CurrentUnits
+	)
+	for i, f := range fieldsOf(&hourly, &hourlyUnits, &current, &units) {
+		*f.hourly = []float64{float64(i + 1)}
+		*f.hourlyUnit = strconv.Itoa(i)
+		*f.current = float64(i + 1)
+		*f.currentUnit = strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"Series", Series(&hourly), hourly},
+		{"HourlyUnits", HourlyUnits(&hourlyUnits), hourlyUnits},
+		{"Values", Values(&current), current},
+		{"CurrentUnits", CurrentUnits(&units), units},
+	}
+	for _, tc := range tests {
+		got, want := decodeJSON(t, tc.got), decodeJSON(t, tc.want)
+		delete(want, "time")
+		if len(want) != 32 || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v; want %v", tc.name, got, want)
+		}
+	}
+
+	if got := Values(nil); got != nil {
+		t.Errorf("Values(nil) = %v; want nil", got)
+	}
+}
+
+// decodeJSON returns v encoded to JSON as a map.
+func decodeJSON(t *testing.T, v any) map[string]any {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := map[string]any{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
@@ -1,5 +1,10 @@
 package meteo
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Level indicates a health‐risk band.
 type Level int
 
@@ -73,3 +78,50 @@ func LevelOf(key string, value float64) Level {
 	}
 	return LevelGood
 }
+
+// Worst returns the highest level of the values keyed by variable.
+func Worst(values map[string]float64) Level {
+	worst := LevelGood
+	for key, value := range values {
+		worst = max(worst, LevelOf(key, value))
+	}
+	return worst
+}
+
+// levelNames are names of levels in configuration.
+var levelNames = map[Level]string{
+	LevelGood:          "good",
+	LevelWatch:         "watch",
+	LevelLimitExceeded: "limit-exceeded",
+	LevelActNow:        "act-now",
+}
+
+// ParseLevel parses a level name: good, watch, limit-exceeded or act-now.
+func ParseLevel(s string) (Level, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for level, levelName := range levelNames {
+		if name == levelName {
+			return level, nil
+		}
+	}
+	return LevelGood, fmt.Errorf("unknown level %q, want one of good, watch, limit-exceeded, act-now", s)
+}
+
+// MarshalText encodes the level by its name, see ParseLevel.
+func (l Level) MarshalText() ([]byte, error) {
+	name, ok := levelNames[l]
+	if !ok {
+		return nil, fmt.Errorf("unknown level %d", int(l))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes the level name, see ParseLevel.
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
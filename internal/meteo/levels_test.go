@@ -52,3 +52,33 @@ func TestLevelOf(t *testing.T) {
 		}
 	}
 }
+
+func TestWorst(t *testing.T) {
+	values := map[string]float64{PM2_5: 15, Dust: 120, "unknown_key": 1000}
+	if got := Worst(values); got != LevelActNow {
+		t.Errorf("Worst(%v) = %v; want %v", values, got, LevelActNow)
+	}
+	if got := Worst(nil); got != LevelGood {
+		t.Errorf("Worst(nil) = %v; want %v", got, LevelGood)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelGood, LevelWatch, LevelLimitExceeded, LevelActNow} {
+		text, err := level.MarshalText()
+		if err != nil {
+			t.Fatalf("Level(%d).MarshalText(): %v", level, err)
+		}
+		var got Level
+		if err := got.UnmarshalText(text); err != nil || got != level {
+			t.Errorf("UnmarshalText(%q) = %v, %v; want %v", text, got, err, level)
+		}
+	}
+
+	if got, err := ParseLevel(" Act-Now "); err != nil || got != LevelActNow {
+		t.Errorf("ParseLevel(\" Act-Now \") = %v, %v; want %v", got, err, LevelActNow)
+	}
+	if _, err := ParseLevel("beware"); err == nil {
+		t.Error("ParseLevel(\"beware\") succeeded; want an error")
+	}
+}
@@ -54,6 +54,7 @@ type HourlyUnits struct {
 
 // HourlyData holds the time series values.
 type HourlyData struct {
+	Time                []string  `json:"time,omitempty"`
 	PM10                []float64 `json:"pm10,omitempty"`
 	PM25                []float64 `json:"pm2_5,omitempty"`
 	CarbonMonoxide      []float64 `json:"carbon_monoxide,omitempty"`
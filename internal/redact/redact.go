@@ -0,0 +1,218 @@
+// Package redact scrubs secrets such as bot tokens from strings, errors and log records.
+package redact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted secrets.
+const Mask = "REDACTED"
+
+// botToken matches Telegram bot tokens, e.g. 123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11.
+var botToken = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+
+// Redactor replaces known secrets and anything looking like a bot token.
+// The zero value and nil are ready to use.
+type Redactor struct {
+	secrets []string
+}
+
+// New creates Redactor for secrets. Empty secrets are ignored.
+func New(secrets ...string) *Redactor {
+	r := &Redactor{}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+// String returns s with secrets masked.
+func (r *Redactor) String(s string) string {
+	if r != nil {
+		for _, secret := range r.secrets {
+			s = strings.ReplaceAll(s, secret, Mask)
+		}
+	}
+	return botToken.ReplaceAllString(s, Mask)
+}
+
+// Error returns err with a redacted message. err is not changed:
+// a *url.Error is returned as a redacted copy, and wrapped errors keep
+// working with errors.Is while errors.As finds a redacted copy of the
+// wrapped *url.Error. Redact *url.Error before wrapping it, as wrapped
+// messages are fixed and only known secrets can be removed from them.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var urlErr, redactedURLErr *url.Error
+	if errors.As(err, &urlErr) {
+		copied := &url.Error{Op: urlErr.Op, URL: r.String(URLString(urlErr.URL)), Err: r.Error(urlErr.Err)}
+		switch {
+		case copied.Error() == urlErr.Error():
+			// nothing to hide
+		case err == error(urlErr):
+			return copied
+		default:
+			redactedURLErr = copied
+		}
+	}
+
+	msg := err.Error()
+	redacted := r.String(msg)
+	if redacted == msg && redactedURLErr == nil {
+		return err
+	}
+	return &redactedError{msg: redacted, err: err, urlErr: redactedURLErr}
+}
+
+type redactedError struct {
+	msg    string
+	err    error
+	urlErr *url.Error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// As finds the redacted copy of the wrapped *url.Error
+// before errors.As looks into the original.
+func (e *redactedError) As(target any) bool {
+	if urlErr, ok := target.(**url.Error); ok && e.urlErr != nil {
+		*urlErr = e.urlErr
+		return true
+	}
+	return false
+}
+
+// Attr returns a with secrets masked in its value.
+func (r *Redactor) Attr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(r.String(value.String()))
+	case slog.KindGroup:
+		attrs := value.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, attr := range attrs {
+			redacted[i] = r.Attr(attr)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			a.Value = slog.AnyValue(r.Error(v))
+		case *url.URL:
+			a.Value = slog.StringValue(r.String(URL(v)))
+		default:
+			if s := fmt.Sprint(v); r.String(s) != s {
+				a.Value = slog.StringValue(r.String(s))
+			}
+		}
+	default:
+		a.Value = value
+	}
+	return a
+}
+
+// Handler wraps next, so messages and attributes are redacted before logging.
+func (r *Redactor) Handler(next slog.Handler) slog.Handler {
+	return &handler{next: next, r: r}
+}
+
+type handler struct {
+	next slog.Handler
+	r    *Redactor
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.r.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.r.Attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.r.Attr(a)
+	}
+	return &handler{next: h.next.WithAttrs(redacted), r: h.r}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), r: h.r}
+}
+
+var sensitiveParams = []string{"token", "key", "secret", "password", "signature"}
+
+// URL formats u with credentials, sensitive query parameters
+// and Bot API tokens in /bot<token>/ path segments masked.
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+
+	if _, ok := redacted.User.Password(); ok {
+		redacted.User = url.UserPassword(redacted.User.Username(), Mask)
+	}
+
+	if redacted.RawQuery != "" {
+		query := redacted.Query()
+		for name := range query {
+			if isSensitive(name) {
+				query.Set(name, Mask)
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+
+	segments := strings.Split(redacted.Path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "bot") && strings.Contains(segment, ":") {
+			segments[i] = "bot" + Mask
+		}
+	}
+	redacted.Path = strings.Join(segments, "/")
+	redacted.RawPath = ""
+
+	return redacted.String()
+}
+
+// URLString parses s as URL and formats it with URL.
+// Strings which are not URLs are returned as is.
+func URLString(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" {
+		return s
+	}
+	return URL(u)
+}
+
+func isSensitive(param string) bool {
+	param = strings.ToLower(param)
+	for _, sensitive := range sensitiveParams {
+		if strings.Contains(param, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package state
+
+// lockFile is a no-op on platforms without flock, only one process
+// may write the state file there.
+func lockFile(string) (unlock func(), err error) {
+	return func() {}, nil
+}
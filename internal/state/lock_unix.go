@@ -0,0 +1,25 @@
+//go:build unix
+
+package state
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock shared by processes using the state
+// file. The lock is held on a separate file, the state file itself is
+// replaced on every write.
+func lockFile(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open state lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("lock state: %w", err)
+	}
+	// closing the file releases the lock
+	return func() { _ = file.Close() }, nil
+}
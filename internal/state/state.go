@@ -0,0 +1,471 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+// DefaultRetention is used when Open is called with zero retention.
+const DefaultRetention = 90 * 24 * time.Hour
+
+const currentVersion = 1
+
+// Store is a small file-backed state of daily-bacon runs.
+// Every mutation is persisted with write-to-temp, fsync and atomic rename,
+// so a crash leaves either the old or the new state on disk.
+//
+// Processes may share the file: mutations hold a lock on path+".lock" and
+// are applied to the state read from the file, so they don't overwrite
+// changes of other processes. Reads see the state of the last mutation
+// of the store.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	data      Data
+}
+
+// Data is the persisted content of the store.
+type Data struct {
+	Version    int                  `json:"version"`
+	Reports    []Report             `json:"reports,omitempty"`
+	Deliveries []Delivery           `json:"deliveries,omitempty"`
+	Chats      map[string]ChatState `json:"chats,omitempty"`
+	// UpdatesOffset is the identifier of the next bot update to receive.
+	UpdatesOffset int `json:"updates_offset,omitempty"`
+	// Subscriptions are keyed by chat ID with an optional topic, e.g. "-100123:45".
+	Subscriptions map[string]Subscription `json:"subscriptions,omitempty"`
+	// Deferred are posts waiting for the end of quiet hours, in the order they were deferred.
+	Deferred []Deferred `json:"deferred,omitempty"`
+	// LastDeferredID is the identifier of the last deferred post.
+	LastDeferredID int `json:"last_deferred_id,omitempty"`
+}
+
+// Report is a single fetched air quality response.
+type Report struct {
+	FetchedAt time.Time                 `json:"fetched_at"`
+	Latitude  float64                   `json:"latitude"`
+	Longitude float64                   `json:"longitude"`
+	Response  models.AirQualityResponse `json:"response"`
+}
+
+// Delivery is a single attempt to deliver a message to a chat.
+type Delivery struct {
+	ChatID string    `json:"chat_id"`
+	At     time.Time `json:"at"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// OK reports whether the delivery succeeded.
+func (d Delivery) OK() bool {
+	return d.Error == ""
+}
+
+// ChatState holds the last message successfully delivered to a chat.
+type ChatState struct {
+	SentAt time.Time `json:"sent_at"`
+	Text   string    `json:"text"`
+	// LiveMessageID is the pinned message edited on every run.
+	LiveMessageID int `json:"live_message_id,omitempty"`
+}
+
+// Subscription is a chat subscribed to scheduled posts with bot commands.
+type Subscription struct {
+	ChatID    string  `json:"chat_id"`
+	ThreadID  int     `json:"thread_id,omitempty"`
+	Place     string  `json:"place"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone"`
+	// Time is the local time of the daily post, e.g. "08:00".
+	Time string `json:"time"`
+	// Vars lists reported variables. Empty means defaults.
+	Vars []string `json:"vars,omitempty"`
+	// Notify is the delivery policy of posts, e.g. "watch:act-now". Empty means the default.
+	Notify       string    `json:"notify,omitempty"`
+	LastPostedAt time.Time `json:"last_posted_at,omitzero"`
+}
+
+// Deferred is a post held back by quiet hours until Due.
+type Deferred struct {
+	ID int `json:"id"`
+	// Chat is the recipient: a group ID with an optional topic in daily
+	// runs, a chat label in the gateway.
+	Chat     string                   `json:"chat"`
+	Due      time.Time                `json:"due"`
+	Text     string                   `json:"text,omitempty"`
+	Entities []tg.MessageEntity       `json:"entities,omitempty"`
+	Keyboard *tg.InlineKeyboardMarkup `json:"keyboard,omitempty"`
+	Silent   bool                     `json:"silent,omitempty"`
+	Pin      bool                     `json:"pin,omitempty"`
+	// Files are uploads of the post saved by the caller.
+	Files []DeferredFile `json:"files,omitempty"`
+}
+
+// DeferredFile is an upload of a deferred post.
+type DeferredFile struct {
+	Path        string `json:"path"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Open loads the store from path. A missing file yields an empty store.
+// Records older than retention are dropped by Compact.
+func Open(path string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, retention: retention, data: data}, nil
+}
+
+// AddReport records a fetched report.
+func (s *Store) AddReport(report Report) error {
+	return s.update(func(data *Data) {
+		data.Reports = append(data.Reports, report)
+	})
+}
+
+// AddDelivery records a delivery attempt. Successful deliveries of text
+// also update the chat's last message.
+func (s *Store) AddDelivery(delivery Delivery, text string) error {
+	return s.update(func(data *Data) {
+		data.Deliveries = append(data.Deliveries, delivery)
+		if !delivery.OK() {
+			return
+		}
+		if data.Chats == nil {
+			data.Chats = map[string]ChatState{}
+		}
+		chat := data.Chats[delivery.ChatID]
+		chat.SentAt, chat.Text = delivery.At, text
+		data.Chats[delivery.ChatID] = chat
+	})
+}
+
+// SetLiveMessage remembers the live message of a chat.
+func (s *Store) SetLiveMessage(chatID string, messageID int) error {
+	return s.update(func(data *Data) {
+		if data.Chats == nil {
+			data.Chats = map[string]ChatState{}
+		}
+		chat := data.Chats[chatID]
+		chat.LiveMessageID = messageID
+		if chat.SentAt.IsZero() {
+			chat.SentAt = time.Now()
+		}
+		data.Chats[chatID] = chat
+	})
+}
+
+// UpdatesOffset returns the identifier of the next bot update to receive.
+func (s *Store) UpdatesOffset() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data.UpdatesOffset
+}
+
+// SetUpdatesOffset remembers the identifier of the next bot update to receive.
+func (s *Store) SetUpdatesOffset(offset int) error {
+	return s.update(func(data *Data) {
+		data.UpdatesOffset = offset
+	})
+}
+
+// Subscription returns the subscription stored under key.
+func (s *Store) Subscription(key string) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub, ok := s.data.Subscriptions[key]
+	return sub, ok
+}
+
+// Subscriptions returns all subscriptions by key.
+func (s *Store) Subscriptions() map[string]Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return maps.Clone(s.data.Subscriptions)
+}
+
+// SetSubscription creates or replaces the subscription stored under key.
+func (s *Store) SetSubscription(key string, sub Subscription) error {
+	return s.update(func(data *Data) {
+		if data.Subscriptions == nil {
+			data.Subscriptions = map[string]Subscription{}
+		}
+		data.Subscriptions[key] = sub
+	})
+}
+
+// UpdateSubscription changes an existing subscription with fn.
+// It reports false if there is no subscription under key.
+func (s *Store) UpdateSubscription(key string, fn func(sub *Subscription)) (bool, error) {
+	found := false
+	err := s.update(func(data *Data) {
+		sub, ok := data.Subscriptions[key]
+		if !ok {
+			return
+		}
+		found = true
+		fn(&sub)
+		data.Subscriptions[key] = sub
+	})
+	return found, err
+}
+
+// DeleteSubscription removes the subscription stored under key.
+// It reports false if there was none.
+func (s *Store) DeleteSubscription(key string) (bool, error) {
+	found := false
+	err := s.update(func(data *Data) {
+		_, found = data.Subscriptions[key]
+		delete(data.Subscriptions, key)
+	})
+	return found, err
+}
+
+// AddDeferred saves a post and returns it with its identifier.
+func (s *Store) AddDeferred(post Deferred) (Deferred, error) {
+	err := s.update(func(data *Data) {
+		data.LastDeferredID++
+		post.ID = data.LastDeferredID
+		data.Deferred = append(data.Deferred, post)
+	})
+	return post, err
+}
+
+// ReplaceDeferred saves a post instead of the posts deferred to its chat
+// and returns it with its identifier.
+func (s *Store) ReplaceDeferred(post Deferred) (Deferred, error) {
+	err := s.update(func(data *Data) {
+		data.Deferred = slices.DeleteFunc(data.Deferred, func(deferred Deferred) bool {
+			return deferred.Chat == post.Chat
+		})
+		data.LastDeferredID++
+		post.ID = data.LastDeferredID
+		data.Deferred = append(data.Deferred, post)
+	})
+	return post, err
+}
+
+// DueDeferred returns posts due at or before now in the order they were deferred.
+func (s *Store) DueDeferred(now time.Time) []Deferred {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Deferred
+	for _, post := range s.data.Deferred {
+		if !post.Due.After(now) {
+			due = append(due, post)
+		}
+	}
+	return due
+}
+
+// UpdateDeferred applies fn to the deferred post with the identifier.
+// It reports false if there is no such post.
+func (s *Store) UpdateDeferred(id int, fn func(post *Deferred)) (bool, error) {
+	found := false
+	err := s.update(func(data *Data) {
+		i := slices.IndexFunc(data.Deferred, func(post Deferred) bool { return post.ID == id })
+		if i < 0 {
+			return
+		}
+		found = true
+		fn(&data.Deferred[i])
+	})
+	return found, err
+}
+
+// DeleteDeferred removes the deferred post with the identifier.
+// It reports false if there was none.
+func (s *Store) DeleteDeferred(id int) (bool, error) {
+	found := false
+	err := s.update(func(data *Data) {
+		data.Deferred = slices.DeleteFunc(data.Deferred, func(post Deferred) bool {
+			if post.ID == id {
+				found = true
+			}
+			return post.ID == id
+		})
+	})
+	return found, err
+}
+
+// DeleteDeferredTo removes the posts deferred to the chat.
+// It reports false if there were none.
+func (s *Store) DeleteDeferredTo(chat string) (bool, error) {
+	found := false
+	err := s.update(func(data *Data) {
+		data.Deferred = slices.DeleteFunc(data.Deferred, func(post Deferred) bool {
+			if post.Chat == chat {
+				found = true
+			}
+			return post.Chat == chat
+		})
+	})
+	return found, err
+}
+
+// Reports returns reports fetched at or after since, oldest first.
+func (s *Store) Reports(since time.Time) []Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reports []Report
+	for _, report := range s.data.Reports {
+		if !report.FetchedAt.Before(since) {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// Deliveries returns delivery attempts made at or after since, oldest first.
+func (s *Store) Deliveries(since time.Time) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range s.data.Deliveries {
+		if !delivery.At.Before(since) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// LastMessage returns the last message delivered to the chat.
+func (s *Store) LastMessage(chatID string) (ChatState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.data.Chats[chatID]
+	return chat, ok
+}
+
+// Compact drops records older than the retention period relative to now
+// and rewrites the state file.
+func (s *Store) Compact(now time.Time) error {
+	deadline := now.Add(-s.retention)
+	return s.update(func(data *Data) {
+		data.Reports = slices.DeleteFunc(data.Reports, func(report Report) bool {
+			return report.FetchedAt.Before(deadline)
+		})
+		data.Deliveries = slices.DeleteFunc(data.Deliveries, func(delivery Delivery) bool {
+			return delivery.At.Before(deadline)
+		})
+		for chatID, chat := range data.Chats {
+			if chat.SentAt.Before(deadline) {
+				delete(data.Chats, chatID)
+			}
+		}
+	})
+}
+
+// update applies fn to the state read from the file under the file lock
+// and keeps the result only if it was written.
+func (s *Store) update(fn func(data *Data)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// other processes may have changed the file since it was read
+	data, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+	fn(&data)
+	if err := writeFile(s.path, data); err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// readFile loads the state from path. A missing file yields an empty state.
+func readFile(path string) (Data, error) {
+	data := Data{Version: currentVersion}
+	raw, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return data, nil
+	case err != nil:
+		return Data{}, fmt.Errorf("read state: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return Data{}, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	if data.Version > currentVersion {
+		return Data{}, fmt.Errorf("state %s: unsupported version %d", path, data.Version)
+	}
+	data.Version = currentVersion
+	return data, nil
+}
+
+func writeFile(path string, data Data) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp state: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write temp state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temp state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename state: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open state dir: %w", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync state dir: %w", err)
+	}
+	return nil
+}
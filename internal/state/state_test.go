@@ -0,0 +1,254 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/tg"
+)
+
+func TestStore_Roundtrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2025, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	s, err := Open(path, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, s.AddReport(Report{
+		FetchedAt: now,
+		Latitude:  34.7,
+		Longitude: 33.02,
+		Response:  models.AirQualityResponse{Current: &models.CurrentData{PM10: 12}},
+	}))
+	require.NoError(t, s.SetLiveMessage("1", 42))
+	require.NoError(t, s.SetUpdatesOffset(100))
+	require.NoError(t, s.AddDelivery(Delivery{ChatID: "1", At: now}, "hello"))
+	require.NoError(t, s.AddDelivery(Delivery{ChatID: "2", At: now, Error: "chat not found"}, "hello"))
+
+	reopened, err := Open(path, 0)
+	require.NoError(t, err)
+
+	reports := reopened.Reports(now)
+	require.Len(t, reports, 1)
+	require.InDelta(t, 12.0, reports[0].Response.Current.PM10, 0)
+	require.Len(t, reopened.Deliveries(now), 2)
+	require.Equal(t, 100, reopened.UpdatesOffset())
+
+	chat, ok := reopened.LastMessage("1")
+	require.True(t, ok)
+	require.Equal(t, "hello", chat.Text)
+	require.Equal(t, 42, chat.LiveMessageID, "delivery must keep the live message")
+
+	_, ok = reopened.LastMessage("2")
+	require.False(t, ok, "failed delivery must not update last message")
+
+	temps, err := filepath.Glob(path + ".tmp*")
+	require.NoError(t, err)
+	require.Empty(t, temps, "temporary files must be cleaned up")
+}
+
+func TestStore_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2025, 5, 10, 8, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+
+	s, err := Open(path, 24*time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, s.AddReport(Report{FetchedAt: old}))
+	require.NoError(t, s.AddReport(Report{FetchedAt: now}))
+	require.NoError(t, s.AddDelivery(Delivery{ChatID: "old", At: old}, "old"))
+	require.NoError(t, s.AddDelivery(Delivery{ChatID: "new", At: now}, "new"))
+
+	require.NoError(t, s.Compact(now))
+
+	reopened, err := Open(path, 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, reopened.Reports(time.Time{}), 1)
+	require.Len(t, reopened.Deliveries(time.Time{}), 1)
+
+	_, ok := reopened.LastMessage("old")
+	require.False(t, ok)
+	_, ok = reopened.LastMessage("new")
+	require.True(t, ok)
+}
+
+func TestOpen_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+	_, err := Open(path, 0)
+	require.Error(t, err)
+}
+
+func TestStore_Subscriptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Open(path, 0)
+	require.NoError(t, err)
+
+	sub := Subscription{ChatID: "-100", ThreadID: 7, Place: "Nicosia", Timezone: "Asia/Nicosia", Time: "08:00"}
+	require.NoError(t, s.SetSubscription("-100:7", sub))
+
+	found, err := s.UpdateSubscription("-100:7", func(sub *Subscription) {
+		sub.Vars = []string{"pm2_5"}
+	})
+	require.NoError(t, err)
+	require.True(t, found)
+
+	found, err = s.UpdateSubscription("-200", func(*Subscription) {
+		t.Fatal("unexpected update")
+	})
+	require.NoError(t, err)
+	require.False(t, found)
+
+	reopened, err := Open(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, reopened.Compact(time.Now().AddDate(1, 0, 0)))
+
+	got, ok := reopened.Subscription("-100:7")
+	require.True(t, ok, "compaction must keep subscriptions")
+	sub.Vars = []string{"pm2_5"}
+	require.Equal(t, sub, got)
+	require.Len(t, reopened.Subscriptions(), 1)
+
+	found, err = reopened.DeleteSubscription("-100:7")
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = reopened.DeleteSubscription("-100:7")
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Empty(t, reopened.Subscriptions())
+}
+
+func TestStore_Deferred(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2025, 5, 1, 22, 0, 0, 0, time.UTC)
+	morning := now.Add(9 * time.Hour)
+
+	s, err := Open(path, 0)
+	require.NoError(t, err)
+
+	first, err := s.AddDeferred(Deferred{Chat: "-100:7", Due: morning, Text: "first", Pin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, first.ID)
+	_, err = s.AddDeferred(Deferred{Chat: "-200", Due: now.Add(time.Hour), Text: "other"})
+	require.NoError(t, err)
+
+	second, err := s.ReplaceDeferred(Deferred{
+		Chat:     "-100:7",
+		Due:      morning,
+		Text:     "second",
+		Entities: []tg.MessageEntity{{Type: "bold", Length: 6}},
+		Files:    []DeferredFile{{Path: "upload-1", Name: "chart.png", ContentType: "image/png"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, second.ID, "identifiers are not reused")
+
+	require.Empty(t, s.DueDeferred(now))
+
+	reopened, err := Open(path, 0)
+	require.NoError(t, err)
+
+	due := reopened.DueDeferred(morning)
+	require.Len(t, due, 2)
+	require.Equal(t, "other", due[0].Text)
+	require.Equal(t, second, due[1], "replaced post must be dropped")
+	require.Len(t, reopened.DueDeferred(now.Add(time.Hour)), 1)
+
+	require.NoError(t, reopened.Compact(morning.AddDate(1, 0, 0)))
+	require.Len(t, reopened.DueDeferred(morning), 2, "compaction must keep deferred posts")
+
+	found, err := reopened.UpdateDeferred(second.ID, func(post *Deferred) { post.Files = nil })
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Empty(t, reopened.DueDeferred(morning)[1].Files)
+	found, err = reopened.UpdateDeferred(100, func(*Deferred) { t.Fatal("no post to update") })
+	require.NoError(t, err)
+	require.False(t, found)
+
+	found, err = reopened.DeleteDeferred(second.ID)
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = reopened.DeleteDeferred(second.ID)
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Len(t, reopened.DueDeferred(morning), 1)
+
+	found, err = reopened.DeleteDeferredTo("-200")
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = reopened.DeleteDeferredTo("-200")
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Empty(t, reopened.DueDeferred(morning))
+}
+
+func TestStore_SharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	stores := make([]*Store, 2)
+	for i := range stores {
+		s, err := Open(path, 0)
+		require.NoError(t, err)
+		stores[i] = s
+	}
+
+	const posts = 20
+	due := time.Date(2025, 5, 2, 7, 0, 0, 0, time.UTC)
+	errs := make(chan error, len(stores))
+	var wg sync.WaitGroup
+	for i, s := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range posts {
+				if _, err := s.AddDeferred(Deferred{Chat: strconv.Itoa(i), Due: due}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	reopened, err := Open(path, 0)
+	require.NoError(t, err)
+	deferred := reopened.DueDeferred(due)
+	require.Len(t, deferred, 2*posts, "writers must not overwrite each other")
+	ids := map[int]bool{}
+	for _, post := range deferred {
+		ids[post.ID] = true
+	}
+	require.Len(t, ids, 2*posts, "identifiers are unique across writers")
+}
+
+func TestStore_FailedWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	s, err := Open(filepath.Join(dir, "state.json"), 0)
+	require.NoError(t, err)
+
+	due := time.Date(2025, 5, 2, 7, 0, 0, 0, time.UTC)
+	_, err = s.AddDeferred(Deferred{Chat: "-100", Due: due, Text: "saved"})
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(dir))
+	_, err = s.AddDeferred(Deferred{Chat: "-200", Due: due, Text: "lost"})
+	require.Error(t, err)
+	_, err = s.UpdateDeferred(1, func(post *Deferred) { post.Text = "changed" })
+	require.Error(t, err)
+
+	deferred := s.DueDeferred(due)
+	require.Len(t, deferred, 1, "failed writes must not change the store")
+	require.Equal(t, "saved", deferred[0].Text)
+}
@@ -0,0 +1,41 @@
+package tg
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Chat member statuses.
+const (
+	MemberCreator       = "creator"
+	MemberAdministrator = "administrator"
+	MemberMember        = "member"
+	MemberRestricted    = "restricted"
+	MemberLeft          = "left"
+	MemberKicked        = "kicked"
+)
+
+// ChatMember is a user's membership in a chat.
+type ChatMember struct {
+	Status string `json:"status"`
+	User   User   `json:"user"`
+}
+
+// IsAdmin reports whether the member is the owner or an administrator of the chat.
+func (m ChatMember) IsAdmin() bool {
+	return m.Status == MemberCreator || m.Status == MemberAdministrator
+}
+
+// GetChatMember returns membership of the user in the chat.
+func (c *Client) GetChatMember(ctx context.Context, chatID string, userID int64) (ChatMember, error) {
+	data := url.Values{}
+	data.Set("chat_id", chatID)
+	data.Set("user_id", strconv.FormatInt(userID, 10))
+
+	var member ChatMember
+	if err := c.callForm(ctx, "getChatMember", data, &member); err != nil {
+		return ChatMember{}, err
+	}
+	return member, nil
+}
@@ -0,0 +1,109 @@
+package tg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// EditMessageText replaces text of a message sent by the bot.
+// Only WithKeyboard, WithParseMode, WithEntities and WithoutLinkPreview options apply,
+// a message without WithKeyboard loses its keyboard. Deleted messages are reported as ErrMessageNotFound.
+func (c *Client) EditMessageText(ctx context.Context, chatID string, messageID int, text string, opts ...SendOption) (Message, error) {
+	options := newSendOptions(opts)
+
+	var edited Message
+	err := c.withMigration(ctx, chatID, func(chatID string) error {
+		data := url.Values{}
+		data.Set("chat_id", chatID)
+		data.Set("message_id", strconv.Itoa(messageID))
+		data.Set("text", text)
+		for _, field := range options.editFields() {
+			data.Set(field.name, field.value)
+		}
+
+		return c.callForm(ctx, "editMessageText", data, &edited)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return edited, nil
+}
+
+// EditMessageMedia replaces media and caption of a message sent by the bot.
+// Deleted messages are reported as ErrMessageNotFound.
+func (c *Client) EditMessageMedia(ctx context.Context, chatID string, messageID int, upload MediaUpload) (Message, error) {
+	if upload.Reader == nil && upload.FileID == "" {
+		return Message{}, errors.New("upload has nil reader")
+	}
+
+	type inputMedia struct {
+		Type            string          `json:"type"`
+		Media           string          `json:"media"`
+		Caption         string          `json:"caption,omitempty"`
+		ParseMode       string          `json:"parse_mode,omitempty"`
+		CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+	}
+	media := inputMedia{
+		Type:            resolveMediaType(upload),
+		Media:           cmp.Or(upload.FileID, "attach://file"),
+		Caption:         upload.Caption,
+		ParseMode:       upload.ParseMode,
+		CaptionEntities: upload.CaptionEntities,
+	}
+	mediaJSON, err := json.Marshal(media)
+	if err != nil {
+		return Message{}, fmt.Errorf("marshal media payload: %w", err)
+	}
+
+	rw := newRewinder(uploadReaders([]MediaUpload{upload}))
+	attempt := 0
+
+	var edited Message
+	err = c.withMigration(ctx, chatID, func(chatID string) error {
+		attempt++
+		if attempt > 1 {
+			if err := rw.rewind(); err != nil {
+				return err
+			}
+		}
+
+		fields := []formField{
+			{name: "chat_id", value: chatID},
+			{name: "message_id", value: strconv.Itoa(messageID)},
+			{name: "media", value: string(mediaJSON)},
+		}
+		var files []formFile
+		if upload.FileID == "" {
+			files = append(files, formFile{
+				field:       "file",
+				fileName:    cmp.Or(upload.FileName, media.Type),
+				contentType: upload.ContentType,
+				reader:      upload.Reader,
+			})
+		}
+
+		return c.callMultipart(ctx, "editMessageMedia", fields, files, &edited)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return edited, nil
+}
+
+// PinChatMessage pins a message in the chat. The bot must be an administrator
+// with the right to pin messages in groups.
+func (c *Client) PinChatMessage(ctx context.Context, chatID string, messageID int, silent bool) error {
+	return c.withMigration(ctx, chatID, func(chatID string) error {
+		data := url.Values{}
+		data.Set("chat_id", chatID)
+		data.Set("message_id", strconv.Itoa(messageID))
+		data.Set("disable_notification", strconv.FormatBool(silent))
+
+		return c.callForm(ctx, "pinChatMessage", data, nil)
+	})
+}
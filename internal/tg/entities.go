@@ -0,0 +1,106 @@
+package tg
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Types of message entities built by Text.
+const (
+	EntityBold     = "bold"
+	EntityCode     = "code"
+	EntityPre      = "pre"
+	EntityTextLink = "text_link"
+	EntitySpoiler  = "spoiler"
+)
+
+// MessageEntity formats a part of a text. Offset and Length are in UTF-16 code units.
+type MessageEntity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// Text builds a message text along with its entities, so nothing has to be escaped.
+// Send it with WithEntities, or as a caption with MediaUpload.CaptionEntities.
+// The zero value is an empty text.
+type Text struct {
+	text     []byte
+	units    int
+	entities []MessageEntity
+}
+
+// Plain appends s without formatting.
+func (t *Text) Plain(s string) *Text {
+	t.text = append(t.text, s...)
+	t.units += UTF16Len(s)
+	return t
+}
+
+// Plainf appends formatted text without formatting.
+func (t *Text) Plainf(format string, args ...any) *Text {
+	return t.Plain(fmt.Sprintf(format, args...))
+}
+
+// Bold appends s in bold.
+func (t *Text) Bold(s string) *Text {
+	return t.add(MessageEntity{Type: EntityBold}, s)
+}
+
+// Code appends s in monospace.
+func (t *Text) Code(s string) *Text {
+	return t.add(MessageEntity{Type: EntityCode}, s)
+}
+
+// Pre appends s as a code block. The language is optional.
+func (t *Text) Pre(s, language string) *Text {
+	return t.add(MessageEntity{Type: EntityPre, Language: language}, s)
+}
+
+// Link appends s linking to url.
+func (t *Text) Link(s, url string) *Text {
+	return t.add(MessageEntity{Type: EntityTextLink, URL: url}, s)
+}
+
+// Spoiler appends s hidden until tapped.
+func (t *Text) Spoiler(s string) *Text {
+	return t.add(MessageEntity{Type: EntitySpoiler}, s)
+}
+
+// Append appends other with its formatting.
+func (t *Text) Append(other *Text) *Text {
+	for _, entity := range other.entities {
+		entity.Offset += t.units
+		t.entities = append(t.entities, entity)
+	}
+	t.text = append(t.text, other.text...)
+	t.units += other.units
+	return t
+}
+
+func (t *Text) add(entity MessageEntity, s string) *Text {
+	entity.Offset = t.units
+	t.Plain(s)
+	// Telegram rejects empty entities
+	if entity.Length = t.units - entity.Offset; entity.Length > 0 {
+		t.entities = append(t.entities, entity)
+	}
+	return t
+}
+
+// Len returns the length of the text in UTF-16 code units.
+func (t *Text) Len() int {
+	return t.units
+}
+
+// String returns the text without formatting.
+func (t *Text) String() string {
+	return string(t.text)
+}
+
+// Entities returns entities of the text, ordered by offset.
+func (t *Text) Entities() []MessageEntity {
+	return slices.Clone(t.entities)
+}
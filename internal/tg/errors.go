@@ -0,0 +1,60 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by APIError via errors.Is.
+var (
+	ErrBotBlocked     = errors.New("bot was blocked by the user")
+	ErrChatNotFound   = errors.New("chat not found")
+	ErrMessageTooLong = errors.New("message is too long")
+	ErrWrongFileID    = errors.New("wrong file identifier")
+	// ErrMessageNotFound is returned when the edited message was deleted or can't be edited anymore.
+	ErrMessageNotFound = errors.New("message to edit not found")
+	// ErrMessageNotModified is returned when an edit doesn't change the message.
+	ErrMessageNotModified = errors.New("message is not modified")
+)
+
+// APIError is an error response of Bot API.
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is the delay requested by flood control.
+	RetryAfter time.Duration
+	// MigrateToChatID is set when the group was upgraded to a supergroup.
+	MigrateToChatID int64
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s: %d: %s", e.Method, e.Code, e.Description)
+}
+
+// Is matches the error against sentinel errors of the package.
+func (e *APIError) Is(target error) bool {
+	description := strings.ToLower(e.Description)
+	switch target {
+	case ErrBotBlocked:
+		return e.Code == http.StatusForbidden && strings.Contains(description, "bot was blocked")
+	case ErrChatNotFound:
+		return e.Code == http.StatusBadRequest && strings.Contains(description, "chat not found")
+	case ErrMessageTooLong:
+		return e.Code == http.StatusBadRequest &&
+			(strings.Contains(description, "message is too long") || strings.Contains(description, "caption is too long"))
+	case ErrMessageNotFound:
+		return e.Code == http.StatusBadRequest &&
+			(strings.Contains(description, "message to edit not found") || strings.Contains(description, "message can't be edited"))
+	case ErrMessageNotModified:
+		return e.Code == http.StatusBadRequest && strings.Contains(description, "message is not modified")
+	case ErrWrongFileID:
+		return e.Code == http.StatusBadRequest &&
+			(strings.Contains(description, "wrong file identifier") || strings.Contains(description, "wrong remote file identifier"))
+	default:
+		return false
+	}
+}
@@ -0,0 +1,197 @@
+package tg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// FileCache maps content hashes of uploads to Telegram file_ids,
+// so identical files are uploaded once and referenced by file_id afterwards.
+// Only uploads with readers implementing io.Seeker are cached.
+// It is safe for concurrent use.
+type FileCache struct {
+	mu       sync.Mutex
+	ids      map[string]string
+	inflight map[string]chan struct{}
+}
+
+// NewFileCache creates an empty FileCache.
+func NewFileCache() *FileCache {
+	return &FileCache{
+		ids:      map[string]string{},
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// Len returns the number of cached file_ids.
+func (fc *FileCache) Len() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return len(fc.ids)
+}
+
+// fileLease holds file_ids known for the requested keys
+// and keys which the holder is uploading.
+type fileLease struct {
+	ids   map[string]string
+	owned []string
+}
+
+// acquire returns file_ids cached for keys. Keys which are not cached
+// are owned by the caller until release, so concurrent senders
+// of the same file wait for the first upload instead of repeating it.
+func (fc *FileCache) acquire(ctx context.Context, keys []string) (fileLease, error) {
+	lease := fileLease{ids: map[string]string{}}
+
+	// acquiring in order prevents deadlocks between albums sharing files
+	unique := slices.Compact(slices.Sorted(slices.Values(keys)))
+	for _, key := range unique {
+		if key == "" {
+			continue
+		}
+		id, err := fc.acquireKey(ctx, key)
+		if err != nil {
+			fc.release(lease, nil)
+			return fileLease{}, err
+		}
+		if id != "" {
+			lease.ids[key] = id
+		} else {
+			lease.owned = append(lease.owned, key)
+		}
+	}
+	return lease, nil
+}
+
+func (fc *FileCache) acquireKey(ctx context.Context, key string) (string, error) {
+	for {
+		fc.mu.Lock()
+		if id, ok := fc.ids[key]; ok {
+			fc.mu.Unlock()
+			return id, nil
+		}
+		wait, busy := fc.inflight[key]
+		if !busy {
+			fc.inflight[key] = make(chan struct{})
+			fc.mu.Unlock()
+			return "", nil
+		}
+		fc.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// release stores uploaded file_ids and wakes up senders waiting for owned keys.
+func (fc *FileCache) release(lease fileLease, uploaded map[string]string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for key, id := range uploaded {
+		fc.ids[key] = id
+	}
+	for _, key := range lease.owned {
+		if wait, ok := fc.inflight[key]; ok {
+			close(wait)
+			delete(fc.inflight, key)
+		}
+	}
+}
+
+func (fc *FileCache) forget(keys []string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for _, key := range keys {
+		delete(fc.ids, key)
+	}
+}
+
+// contentKeys returns cache keys of uploads: media type and SHA-256 of the content.
+// Uploads which already have a file_id or can't be read twice get an empty key.
+func contentKeys(uploads []MediaUpload) ([]string, error) {
+	keys := make([]string, len(uploads))
+	for i, upload := range uploads {
+		seeker, ok := upload.Reader.(io.ReadSeeker)
+		if upload.FileID != "" || !ok {
+			continue
+		}
+
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			continue
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, seeker); err != nil {
+			return nil, fmt.Errorf("hash upload %d: %w", i, err)
+		}
+		if _, err := seeker.Seek(pos, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("rewind upload %d: %w", i, err)
+		}
+		keys[i] = resolveMediaType(upload) + ":" + hex.EncodeToString(hash.Sum(nil))
+	}
+	return keys, nil
+}
+
+// sendCached replaces uploads known to the file cache with their file_ids,
+// calls send and remembers file_ids of the uploaded files.
+// If Telegram rejects a cached file_id, the files are uploaded again
+// as long as every upload reader implements io.Seeker.
+func (c *Client) sendCached(ctx context.Context, uploads []MediaUpload, send func([]MediaUpload) ([]Message, error)) ([]Message, error) {
+	if c.files == nil {
+		return send(uploads)
+	}
+
+	keys, err := contentKeys(uploads)
+	if err != nil {
+		return nil, err
+	}
+	lease, err := c.files.acquire(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var reused []string
+	cached := slices.Clone(uploads)
+	for i, key := range keys {
+		if id := lease.ids[key]; id != "" {
+			cached[i].FileID = id
+			reused = append(reused, key)
+		}
+	}
+
+	// the first attempt reads the new files, they are rewound for the retry
+	rw := newRewinder(uploadReaders(uploads))
+	sent, err := send(cached)
+	if errors.Is(err, ErrWrongFileID) && len(reused) > 0 {
+		c.files.forget(reused)
+		if rewindErr := rw.rewind(); rewindErr != nil {
+			c.logger.WarnContext(ctx, "cached file_id was rejected, files can't be uploaded again", "err", err, "rewind_err", rewindErr)
+		} else {
+			c.logger.WarnContext(ctx, "cached file_id was rejected, uploading again", "err", err)
+			cached = uploads
+			sent, err = send(cached)
+		}
+	}
+
+	uploaded := map[string]string{}
+	for i, msg := range sent {
+		if i < len(keys) && keys[i] != "" && cached[i].FileID == "" && msg.FileID() != "" {
+			uploaded[keys[i]] = msg.FileID()
+		}
+	}
+	c.files.release(lease, uploaded)
+
+	return sent, err
+}
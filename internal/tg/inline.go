@@ -0,0 +1,90 @@
+package tg
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxInlineResults is the maximum number of results of an inline query answer.
+const MaxInlineResults = 50
+
+// InlineQuery is sent when a user types "@bot query" in a chat.
+type InlineQuery struct {
+	ID     string `json:"id"`
+	From   User   `json:"from"`
+	Query  string `json:"query"`
+	Offset string `json:"offset"`
+}
+
+// InlineQueryResultArticle is an inline query result sending a text message.
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	ID                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	Description         string                  `json:"description,omitempty"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+	ReplyMarkup         *InlineKeyboardMarkup   `json:"reply_markup,omitempty"`
+}
+
+// InputTextMessageContent is the text message sent for a chosen inline result.
+type InputTextMessageContent struct {
+	MessageText string          `json:"message_text"`
+	ParseMode   string          `json:"parse_mode,omitempty"`
+	Entities    []MessageEntity `json:"entities,omitempty"`
+}
+
+// NewArticle returns an article result sending text.
+func NewArticle(id, title, description, text string) InlineQueryResultArticle {
+	return InlineQueryResultArticle{
+		Type:                "article",
+		ID:                  id,
+		Title:               title,
+		Description:         description,
+		InputMessageContent: InputTextMessageContent{MessageText: text},
+	}
+}
+
+// InlineAnswer configures AnswerInlineQuery.
+type InlineAnswer struct {
+	// CacheTime is how long Telegram may cache the results. Zero means the server default of 300s.
+	CacheTime time.Duration
+	// IsPersonal disables sharing cached results between users.
+	IsPersonal bool
+	// NextOffset is passed back with the query for the next page of results.
+	NextOffset string
+}
+
+// AnswerInlineQuery sends results of an inline query, at most MaxInlineResults.
+func (c *Client) AnswerInlineQuery(ctx context.Context, queryID string, results []InlineQueryResultArticle, answer InlineAnswer) error {
+	if results == nil {
+		results = []InlineQueryResultArticle{}
+	}
+
+	data := url.Values{}
+	data.Set("inline_query_id", queryID)
+	data.Set("results", mustJSON(results[:min(len(results), MaxInlineResults)]))
+	if answer.CacheTime > 0 {
+		data.Set("cache_time", strconv.Itoa(int(answer.CacheTime/time.Second)))
+	}
+	if answer.IsPersonal {
+		data.Set("is_personal", "true")
+	}
+	if answer.NextOffset != "" {
+		data.Set("next_offset", answer.NextOffset)
+	}
+	return c.callForm(ctx, "answerInlineQuery", data, nil)
+}
+
+// EditInlineMessageText replaces text of a message sent via the bot in inline mode.
+// Only WithKeyboard, WithParseMode, WithEntities and WithoutLinkPreview options apply.
+func (c *Client) EditInlineMessageText(ctx context.Context, inlineMessageID, text string, opts ...SendOption) error {
+	data := url.Values{}
+	data.Set("inline_message_id", inlineMessageID)
+	data.Set("text", text)
+	for _, field := range newSendOptions(opts).editFields() {
+		data.Set(field.name, field.value)
+	}
+	return c.callForm(ctx, "editMessageText", data, nil)
+}
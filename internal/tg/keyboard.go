@@ -0,0 +1,83 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxCallbackData is the maximum size of callback data in bytes.
+const MaxCallbackData = 64
+
+const callbackSeparator = "|"
+
+// ErrCallbackDataTooLong is returned for callback data longer than MaxCallbackData.
+var ErrCallbackDataTooLong = errors.New("callback data is too long")
+
+// InlineKeyboardMarkup is a keyboard attached to a message.
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton is a button of an inline keyboard.
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+// CallbackQuery is sent when a user presses a callback button.
+type CallbackQuery struct {
+	ID   string `json:"id"`
+	From User   `json:"from"`
+	// Message is the message with the button. It is nil for inline messages
+	// and for messages too old to be delivered.
+	Message         *Message `json:"message,omitempty"`
+	InlineMessageID string   `json:"inline_message_id,omitempty"`
+	Data            string   `json:"data,omitempty"`
+}
+
+// WithKeyboard attaches an inline keyboard to the message.
+// It is ignored by media groups, which can't have keyboards.
+func WithKeyboard(keyboard InlineKeyboardMarkup) SendOption {
+	return func(o *sendOptions) {
+		o.keyboard = &keyboard
+	}
+}
+
+// EncodeCallbackData joins fields into callback data.
+// Fields must not contain "|" and the result must fit into MaxCallbackData bytes.
+func EncodeCallbackData(fields ...string) (string, error) {
+	for _, field := range fields {
+		if strings.Contains(field, callbackSeparator) {
+			return "", fmt.Errorf("callback data field %q contains %q", field, callbackSeparator)
+		}
+	}
+	data := strings.Join(fields, callbackSeparator)
+	if len(data) > MaxCallbackData {
+		return "", fmt.Errorf("%w: %d bytes", ErrCallbackDataTooLong, len(data))
+	}
+	return data, nil
+}
+
+// DecodeCallbackData splits callback data encoded by EncodeCallbackData.
+func DecodeCallbackData(data string) []string {
+	return strings.Split(data, callbackSeparator)
+}
+
+// AnswerCallbackQuery stops the loading indicator of a pressed button.
+// Non-empty text is shown as a notification, or as an alert if showAlert is set.
+func (c *Client) AnswerCallbackQuery(ctx context.Context, queryID, text string, showAlert bool) error {
+	data := url.Values{}
+	data.Set("callback_query_id", queryID)
+	if text != "" {
+		data.Set("text", text)
+	}
+	if showAlert {
+		data.Set("show_alert", strconv.FormatBool(showAlert))
+	}
+	return c.callForm(ctx, "answerCallbackQuery", data, nil)
+}
@@ -0,0 +1,423 @@
+package tg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Media types of MediaUpload.
+const (
+	MediaPhoto     = "photo"
+	MediaVideo     = "video"
+	MediaAnimation = "animation"
+	MediaAudio     = "audio"
+	MediaDocument  = "document"
+)
+
+// Parse modes for message text and captions.
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+	// ParseModeMarkdown is the legacy Markdown, kept for backward compatibility.
+	ParseModeMarkdown = "Markdown"
+)
+
+// IsParseMode reports whether Telegram knows the parse mode.
+func IsParseMode(mode string) bool {
+	switch mode {
+	case ParseModeHTML, ParseModeMarkdownV2, ParseModeMarkdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Media group size limits.
+const (
+	MinMediaGroup = 2
+	MaxMediaGroup = 10
+)
+
+// ErrMixedMediaGroup is returned when media of incompatible types are sent in one album.
+var ErrMixedMediaGroup = errors.New("media group mixes incompatible types")
+
+// MediaUpload represents a single file sent to Telegram.
+type MediaUpload struct {
+	// Type is one of Media* constants.
+	// If empty, it is resolved from ContentType.
+	Type        string
+	FileName    string
+	Reader      io.Reader
+	ContentType string
+	Caption     string
+	ParseMode   string
+	// CaptionEntities format the caption instead of ParseMode, see Text.
+	CaptionEntities []MessageEntity
+	// Thumbnail is an optional JPEG preview, ignored for photos.
+	Thumbnail io.Reader
+	// FileID references a file already stored by Telegram.
+	// If set, Reader and Thumbnail are not uploaded.
+	FileID string
+}
+
+var sendMethods = map[string]string{
+	MediaPhoto:     "sendPhoto",
+	MediaVideo:     "sendVideo",
+	MediaAnimation: "sendAnimation",
+	MediaAudio:     "sendAudio",
+	MediaDocument:  "sendDocument",
+}
+
+// SendPhoto sends a single photo.
+func (c *Client) SendPhoto(ctx context.Context, chatID string, upload MediaUpload, opts ...SendOption) (Message, error) {
+	upload.Type = MediaPhoto
+	return c.sendFile(ctx, chatID, upload, opts)
+}
+
+// SendDocument sends a single file as a document.
+func (c *Client) SendDocument(ctx context.Context, chatID string, upload MediaUpload, opts ...SendOption) (Message, error) {
+	upload.Type = MediaDocument
+	return c.sendFile(ctx, chatID, upload, opts)
+}
+
+// SendVideo sends a single video.
+func (c *Client) SendVideo(ctx context.Context, chatID string, upload MediaUpload, opts ...SendOption) (Message, error) {
+	upload.Type = MediaVideo
+	return c.sendFile(ctx, chatID, upload, opts)
+}
+
+// SendAudio sends a single audio file to be shown in the music player.
+func (c *Client) SendAudio(ctx context.Context, chatID string, upload MediaUpload, opts ...SendOption) (Message, error) {
+	upload.Type = MediaAudio
+	return c.sendFile(ctx, chatID, upload, opts)
+}
+
+// SendAnimation sends a single GIF or silent video.
+func (c *Client) SendAnimation(ctx context.Context, chatID string, upload MediaUpload, opts ...SendOption) (Message, error) {
+	upload.Type = MediaAnimation
+	return c.sendFile(ctx, chatID, upload, opts)
+}
+
+// SendMedia sends uploads in order, grouping them into albums where Telegram allows it.
+// More than MaxMediaGroup uploads are split into consecutive albums, documents and
+// audio are grouped separately from photos and videos, and animations are sent one by one.
+// On failure, messages sent before the error are returned along with it.
+func (c *Client) SendMedia(ctx context.Context, chatID string, uploads []MediaUpload, opts ...SendOption) ([]Message, error) {
+	var sent []Message
+	for _, batch := range splitMedia(uploads) {
+		if len(batch) == 1 {
+			msg, err := c.sendFile(ctx, chatID, batch[0], opts)
+			if err != nil {
+				return sent, err
+			}
+			sent = append(sent, msg)
+			continue
+		}
+
+		msgs, err := c.SendMediaGroup(ctx, chatID, batch, opts...)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, msgs...)
+	}
+	return sent, nil
+}
+
+// SendMediaGroup uploads multiple files as an album / media group.
+// If the chat was migrated to a supergroup, the album is sent to the new chat
+// as long as every upload reader implements io.Seeker.
+// Returned messages are in the order of uploads, see Message.FileID to reuse them.
+func (c *Client) SendMediaGroup(ctx context.Context, chatID string, uploads []MediaUpload, opts ...SendOption) ([]Message, error) {
+	types := make([]string, len(uploads))
+	for i, upload := range uploads {
+		types[i] = resolveMediaType(upload)
+	}
+	if err := ValidateMediaGroup(types); err != nil {
+		return nil, err
+	}
+
+	return c.sendCached(ctx, uploads, func(uploads []MediaUpload) ([]Message, error) {
+		rw := newRewinder(uploadReaders(uploads))
+		attempt := 0
+
+		var sent []Message
+		err := c.withMigration(ctx, chatID, func(chatID string) error {
+			attempt++
+			if attempt > 1 {
+				if err := rw.rewind(); err != nil {
+					return err
+				}
+			}
+
+			var err error
+			sent, err = c.sendMediaGroup(ctx, chatID, uploads, newSendOptions(opts))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return sent, nil
+	})
+}
+
+func (c *Client) sendMediaGroup(ctx context.Context, chatID string, uploads []MediaUpload, options sendOptions) ([]Message, error) {
+	type mediaItem struct {
+		Type            string          `json:"type"`
+		Media           string          `json:"media"`
+		Caption         string          `json:"caption,omitempty"`
+		ParseMode       string          `json:"parse_mode,omitempty"`
+		CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+		Thumbnail       string          `json:"thumbnail,omitempty"`
+	}
+
+	items := make([]mediaItem, 0, len(uploads))
+	files := make([]formFile, 0, len(uploads))
+	for i, upload := range uploads {
+		if upload.FileID != "" {
+			items = append(items, mediaItem{
+				Type:            resolveMediaType(upload),
+				Media:           upload.FileID,
+				Caption:         upload.Caption,
+				ParseMode:       upload.ParseMode,
+				CaptionEntities: upload.CaptionEntities,
+			})
+			continue
+		}
+		if upload.Reader == nil {
+			return nil, fmt.Errorf("upload %d has nil reader", i)
+		}
+
+		item := mediaItem{
+			Type:            resolveMediaType(upload),
+			Media:           fmt.Sprintf("attach://file%d", i),
+			Caption:         upload.Caption,
+			ParseMode:       upload.ParseMode,
+			CaptionEntities: upload.CaptionEntities,
+		}
+		files = append(files, formFile{
+			field:       fmt.Sprintf("file%d", i),
+			fileName:    cmp.Or(upload.FileName, fmt.Sprintf("file%d", i)),
+			contentType: upload.ContentType,
+			reader:      upload.Reader,
+		})
+		if upload.Thumbnail != nil && item.Type != MediaPhoto {
+			item.Thumbnail = fmt.Sprintf("attach://thumbnail%d", i)
+			files = append(files, thumbnailFile(fmt.Sprintf("thumbnail%d", i), upload.Thumbnail))
+		}
+		items = append(items, item)
+	}
+
+	mediaJSON, err := json.Marshal(items)
+	if err != nil {
+		return nil, fmt.Errorf("marshal media payload: %w", err)
+	}
+
+	fields := []formField{
+		{name: "chat_id", value: chatID},
+		{name: "media", value: string(mediaJSON)},
+	}
+	// media groups can't have keyboards
+	options.keyboard = nil
+	fields = append(fields, options.fields(false)...)
+
+	var sent []Message
+	if err := c.callMultipart(ctx, "sendMediaGroup", fields, files, &sent); err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
+
+// sendFile sends a single upload with the method matching its type.
+func (c *Client) sendFile(ctx context.Context, chatID string, upload MediaUpload, opts []SendOption) (Message, error) {
+	if upload.Reader == nil && upload.FileID == "" {
+		return Message{}, errors.New("upload has nil reader")
+	}
+	upload.Type = resolveMediaType(upload)
+	if sendMethods[upload.Type] == "" {
+		return Message{}, fmt.Errorf("unknown media type %q", upload.Type)
+	}
+
+	sent, err := c.sendCached(ctx, []MediaUpload{upload}, func(uploads []MediaUpload) ([]Message, error) {
+		msg, err := c.sendSingle(ctx, chatID, uploads[0], newSendOptions(opts))
+		if err != nil {
+			return nil, err
+		}
+		return []Message{msg}, nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return sent[0], nil
+}
+
+func (c *Client) sendSingle(ctx context.Context, chatID string, upload MediaUpload, options sendOptions) (Message, error) {
+	method := sendMethods[upload.Type]
+	rw := newRewinder(uploadReaders([]MediaUpload{upload}))
+	attempt := 0
+
+	var sent Message
+	err := c.withMigration(ctx, chatID, func(chatID string) error {
+		attempt++
+		if attempt > 1 {
+			if err := rw.rewind(); err != nil {
+				return err
+			}
+		}
+
+		fields := []formField{{name: "chat_id", value: chatID}}
+		if upload.Caption != "" {
+			fields = append(fields, formField{name: "caption", value: upload.Caption})
+		}
+		if upload.ParseMode != "" {
+			fields = append(fields, formField{name: "parse_mode", value: upload.ParseMode})
+		}
+		if len(upload.CaptionEntities) > 0 {
+			fields = append(fields, formField{name: "caption_entities", value: mustJSON(upload.CaptionEntities)})
+		}
+		fields = append(fields, options.fields(false)...)
+
+		if upload.FileID != "" {
+			fields = append(fields, formField{name: upload.Type, value: upload.FileID})
+			return c.callMultipart(ctx, method, fields, nil, &sent)
+		}
+
+		files := []formFile{{
+			field:       upload.Type,
+			fileName:    cmp.Or(upload.FileName, upload.Type),
+			contentType: upload.ContentType,
+			reader:      upload.Reader,
+		}}
+		if upload.Thumbnail != nil && upload.Type != MediaPhoto {
+			fields = append(fields, formField{name: "thumbnail", value: "attach://thumbnail"})
+			files = append(files, thumbnailFile("thumbnail", upload.Thumbnail))
+		}
+
+		return c.callMultipart(ctx, method, fields, files, &sent)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return sent, nil
+}
+
+// callMultipart invokes Bot API method with a streamed multipart body.
+func (c *Client) callMultipart(ctx context.Context, method string, fields []formField, files []formFile, dst any) error {
+	body := newMultipartBody(fields, files)
+
+	err := c.call(ctx, method, body.contentType, body, dst)
+	if werr := body.wait(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+func thumbnailFile(field string, reader io.Reader) formFile {
+	return formFile{
+		field:       field,
+		fileName:    field + ".jpg",
+		contentType: "image/jpeg",
+		reader:      reader,
+	}
+}
+
+// splitMedia groups uploads into batches which can be sent as albums.
+// Batches of a single upload are sent with the method matching its type.
+func splitMedia(uploads []MediaUpload) [][]MediaUpload {
+	var (
+		batches [][]MediaUpload
+		batch   []MediaUpload
+		group   string
+	)
+	flush := func() {
+		if len(batch) > 0 {
+			batches = append(batches, batch)
+		}
+		batch = nil
+	}
+
+	for _, upload := range uploads {
+		upload.Type = resolveMediaType(upload)
+		g := mediaGroupOf(upload.Type)
+		if g == "" || g != group || len(batch) == MaxMediaGroup {
+			flush()
+		}
+		batch = append(batch, upload)
+		group = g
+		if g == "" {
+			flush()
+		}
+	}
+	flush()
+
+	return batches
+}
+
+// ValidateMediaGroup checks that media of the types can be sent in one album:
+// MinMediaGroup-MaxMediaGroup photos and videos, audios or documents.
+func ValidateMediaGroup(types []string) error {
+	if len(types) < MinMediaGroup || len(types) > MaxMediaGroup {
+		return fmt.Errorf("media group must have %d-%d uploads, got %d", MinMediaGroup, MaxMediaGroup, len(types))
+	}
+	group := mediaGroupOf(types[0])
+	for i, mediaType := range types {
+		if g := mediaGroupOf(mediaType); g == "" || g != group {
+			return fmt.Errorf("upload %d of type %s: %w", i, mediaType, ErrMixedMediaGroup)
+		}
+	}
+	return nil
+}
+
+// mediaGroupOf returns the kind of album the media type can be part of.
+// Empty result means that the media can't be sent in an album.
+func mediaGroupOf(mediaType string) string {
+	switch mediaType {
+	case MediaPhoto, MediaVideo:
+		return "visual"
+	case MediaAudio:
+		return MediaAudio
+	case MediaDocument:
+		return MediaDocument
+	default:
+		return ""
+	}
+}
+
+func resolveMediaType(upload MediaUpload) string {
+	if t := upload.Type; t != "" {
+		return t
+	}
+	ct := strings.ToLower(upload.ContentType)
+	switch {
+	case ct == "image/gif":
+		return MediaAnimation
+	case ct == "image/svg+xml":
+		return MediaDocument
+	case strings.HasPrefix(ct, "image/"):
+		return MediaPhoto
+	case strings.HasPrefix(ct, "video/"):
+		return MediaVideo
+	case strings.HasPrefix(ct, "audio/"):
+		return MediaAudio
+	}
+
+	return MediaDocument
+}
+
+func uploadReaders(uploads []MediaUpload) []io.Reader {
+	var readers []io.Reader
+	for _, upload := range uploads {
+		if upload.FileID != "" {
+			continue
+		}
+		readers = append(readers, upload.Reader)
+		if upload.Thumbnail != nil && resolveMediaType(upload) != MediaPhoto {
+			readers = append(readers, upload.Thumbnail)
+		}
+	}
+	return readers
+}
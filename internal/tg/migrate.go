@@ -0,0 +1,90 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MigrateFunc is called when a group was upgraded to a supergroup
+// and requests to it were redirected to the new chat ID.
+// It may be called concurrently.
+type MigrateFunc func(from, to string)
+
+// ChatMigratedError is returned when a chat was migrated to a supergroup,
+// but the request could not be repeated with the new chat ID.
+type ChatMigratedError struct {
+	From, To string
+	Err      error
+}
+
+func (e *ChatMigratedError) Error() string {
+	return fmt.Sprintf("chat %s migrated to %s: %v", e.From, e.To, e.Err)
+}
+
+func (e *ChatMigratedError) Unwrap() error {
+	return e.Err
+}
+
+// withMigration calls send with chatID and repeats it once with the new
+// chat ID if Telegram reports that the chat was migrated.
+// Failed calls are repeated by the RetryFunc of ctx, see WithRetry.
+func (c *Client) withMigration(ctx context.Context, chatID string, send func(chatID string) error) error {
+	err := withRetry(ctx, func() error { return send(chatID) })
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.MigrateToChatID == 0 {
+		return err
+	}
+
+	to := strconv.FormatInt(apiErr.MigrateToChatID, 10)
+	if c.onMigrate != nil {
+		c.onMigrate(chatID, to)
+	}
+
+	if err := withRetry(ctx, func() error { return send(to) }); err != nil {
+		return &ChatMigratedError{From: chatID, To: to, Err: err}
+	}
+	return nil
+}
+
+var errNotRewindable = errors.New("upload reader is not an io.Seeker")
+
+// rewinder restores upload readers to their initial positions,
+// so the same uploads can be sent again.
+type rewinder struct {
+	seekers   []io.Seeker
+	positions []int64
+	err       error
+}
+
+func newRewinder(readers []io.Reader) rewinder {
+	var r rewinder
+	for _, reader := range readers {
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return rewinder{err: errNotRewindable}
+		}
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return rewinder{err: errNotRewindable}
+		}
+		r.seekers = append(r.seekers, seeker)
+		r.positions = append(r.positions, pos)
+	}
+	return r
+}
+
+func (r rewinder) rewind() error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, seeker := range r.seekers {
+		if _, err := seeker.Seek(r.positions[i], io.SeekStart); err != nil {
+			return fmt.Errorf("rewind upload %d: %w", i, err)
+		}
+	}
+	return nil
+}
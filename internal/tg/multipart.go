@@ -0,0 +1,92 @@
+package tg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+type formField struct {
+	name, value string
+}
+
+type formFile struct {
+	field       string
+	fileName    string
+	contentType string
+	reader      io.Reader
+}
+
+// multipartBody streams a multipart form through a pipe,
+// so uploads are not buffered in memory.
+type multipartBody struct {
+	*io.PipeReader
+	contentType string
+
+	done chan struct{}
+	err  error
+}
+
+func newMultipartBody(fields []formField, files []formFile) *multipartBody {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	body := &multipartBody{
+		PipeReader:  pr,
+		contentType: writer.FormDataContentType(),
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		defer close(body.done)
+		body.err = writeMultipart(writer, fields, files)
+		_ = pw.CloseWithError(body.err)
+	}()
+
+	return body
+}
+
+// wait stops the writer and returns its error.
+// It must be called after the request is done, before the uploads are reused.
+func (b *multipartBody) wait() error {
+	_ = b.PipeReader.Close()
+	<-b.done
+
+	if errors.Is(b.err, io.ErrClosedPipe) {
+		// the request was finished or canceled before the body was consumed
+		return nil
+	}
+	return b.err
+}
+
+func writeMultipart(writer *multipart.Writer, fields []formField, files []formFile) error {
+	for _, field := range fields {
+		if err := writer.WriteField(field.name, field.value); err != nil {
+			return fmt.Errorf("write %s field: %w", field.name, err)
+		}
+	}
+
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, file.field, file.fileName))
+		header.Set("Content-Type", "application/octet-stream")
+		if file.contentType != "" {
+			header.Set("Content-Type", file.contentType)
+		}
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return fmt.Errorf("create part: %w", err)
+		}
+		if _, err := io.Copy(part, file.reader); err != nil {
+			return fmt.Errorf("copy %s: %w", file.field, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("close multipart writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package tg
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// DefaultAPIURL is the public Bot API server.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// Option configures Client.
+type Option func(*Client)
+
+// WithToken sets the bot token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = strings.TrimSpace(token)
+	}
+}
+
+// WithAPIURL sets the Bot API base URL, e.g. of a local Bot API server.
+func WithAPIURL(apiURL string) Option {
+	return func(c *Client) {
+		c.apiURL = strings.TrimRight(apiURL, "/")
+	}
+}
+
+// WithDoer sets HTTPDoer used for requests. Defaults to http.DefaultClient.
+func WithDoer(doer HTTPDoer) Option {
+	return func(c *Client) {
+		c.doer = doer
+	}
+}
+
+// WithLogger sets logger for Bot API calls. Logging is disabled by default,
+// a nil logger keeps it disabled.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithMigrationHandler sets fn to be called on chat migrations.
+func WithMigrationHandler(fn MigrateFunc) Option {
+	return func(c *Client) {
+		c.onMigrate = fn
+	}
+}
+
+// WithFileCache enables reusing file_ids of uploaded files shared by cache.
+// Share one cache between clients of the same bot only, file_ids are bot-specific.
+func WithFileCache(cache *FileCache) Option {
+	return func(c *Client) {
+		c.files = cache
+	}
+}
+
+func defaultClient() *Client {
+	return &Client{
+		doer:   http.DefaultClient,
+		apiURL: DefaultAPIURL,
+		logger: slog.New(slog.DiscardHandler),
+	}
+}
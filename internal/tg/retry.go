@@ -0,0 +1,62 @@
+package tg
+
+import (
+	"context"
+	"errors"
+)
+
+// RetryFunc decides whether a failed Bot API call is repeated. It is called
+// after every failed attempt of a single call, waits before the next one
+// and reports whether to make it.
+type RetryFunc func(ctx context.Context, attempt int, err error) bool
+
+type retryKey struct{}
+
+// WithRetry returns a context in which failed calls sending, editing or
+// pinning messages are repeated as retry decides. Every Bot API call is
+// repeated on its own, so messages already sent by SendText or SendMedia
+// are not sent again.
+func WithRetry(ctx context.Context, retry RetryFunc) context.Context {
+	return context.WithValue(ctx, retryKey{}, retry)
+}
+
+// WaitFunc waits before every attempt of a Bot API call, e.g. for rate limits.
+type WaitFunc func(ctx context.Context) error
+
+type waitKey struct{}
+
+// WithWait returns a context in which every attempt of calls sending,
+// editing or pinning messages waits for wait first. A call fails
+// if the wait fails before its first attempt.
+func WithWait(ctx context.Context, wait WaitFunc) context.Context {
+	return context.WithValue(ctx, waitKey{}, wait)
+}
+
+// withRetry calls call until it succeeds or the RetryFunc of ctx gives up.
+// Every attempt waits for the WaitFunc of ctx.
+func withRetry(ctx context.Context, call func() error) error {
+	retry, _ := ctx.Value(retryKey{}).(RetryFunc)
+	wait, _ := ctx.Value(waitKey{}).(WaitFunc)
+
+	var last error
+	for attempt := 1; ; attempt++ {
+		if wait != nil {
+			if err := wait(ctx); err != nil {
+				if last != nil {
+					// the failure of the call explains more than the wait
+					return last
+				}
+				return err
+			}
+		}
+		err := call()
+		if attempt > 1 && errors.Is(err, errNotRewindable) {
+			// the upload can't be sent again, report why it failed
+			return last
+		}
+		if err == nil || retry == nil || !retry(ctx, attempt, err) {
+			return err
+		}
+		last = err
+	}
+}
@@ -0,0 +1,155 @@
+package tg
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SendOption configures a sent message.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	threadID           int
+	replyTo            int
+	silent             bool
+	disableLinkPreview bool
+	parseMode          string
+	entities           []MessageEntity
+	keyboard           *InlineKeyboardMarkup
+}
+
+// InThread sends the message to a forum topic. Zero threadID is ignored.
+func InThread(threadID int) SendOption {
+	return func(o *sendOptions) {
+		o.threadID = threadID
+	}
+}
+
+// ReplyTo sends the message as a reply. The message is sent
+// even if the replied message was deleted.
+func ReplyTo(messageID int) SendOption {
+	return func(o *sendOptions) {
+		o.replyTo = messageID
+	}
+}
+
+// Silent sends the message without a notification sound.
+func Silent(silent bool) SendOption {
+	return func(o *sendOptions) {
+		o.silent = silent
+	}
+}
+
+// WithoutLinkPreview disables link previews of text messages.
+func WithoutLinkPreview(disable bool) SendOption {
+	return func(o *sendOptions) {
+		o.disableLinkPreview = disable
+	}
+}
+
+// WithParseMode formats text of messages with the parse mode, e.g. ParseModeHTML.
+// Captions are formatted with MediaUpload.ParseMode instead.
+func WithParseMode(parseMode string) SendOption {
+	return func(o *sendOptions) {
+		o.parseMode = parseMode
+	}
+}
+
+// WithEntities formats text of messages with entities, e.g. built by Text.
+// Parse mode is ignored along with them.
+func WithEntities(entities []MessageEntity) SendOption {
+	return func(o *sendOptions) {
+		o.entities = entities
+	}
+}
+
+func newSendOptions(opts []SendOption) sendOptions {
+	var o sendOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// fields returns Bot API parameters of the options.
+// Parse mode, entities and link preview options are only set for text messages.
+func (o sendOptions) fields(text bool) []formField {
+	var fields []formField
+	if o.threadID != 0 {
+		fields = append(fields, formField{name: "message_thread_id", value: strconv.Itoa(o.threadID)})
+	}
+	if o.replyTo != 0 {
+		fields = append(fields, formField{name: "reply_parameters", value: mustJSON(map[string]any{
+			"message_id":                  o.replyTo,
+			"allow_sending_without_reply": true,
+		})})
+	}
+	if o.silent {
+		fields = append(fields, formField{name: "disable_notification", value: "true"})
+	}
+	switch {
+	case len(o.entities) > 0 && text:
+		fields = append(fields, formField{name: "entities", value: mustJSON(o.entities)})
+	case o.parseMode != "" && text:
+		fields = append(fields, formField{name: "parse_mode", value: o.parseMode})
+	}
+	if o.disableLinkPreview && text {
+		fields = append(fields, formField{name: "link_preview_options", value: mustJSON(map[string]any{
+			"is_disabled": true,
+		})})
+	}
+	if o.keyboard != nil {
+		fields = append(fields, formField{name: "reply_markup", value: mustJSON(o.keyboard)})
+	}
+	return fields
+}
+
+// editFields returns Bot API parameters of the options which apply to edited text messages.
+func (o sendOptions) editFields() []formField {
+	return slices.DeleteFunc(o.fields(true), func(field formField) bool {
+		return field.name != "parse_mode" && field.name != "entities" && field.name != "link_preview_options" && field.name != "reply_markup"
+	})
+}
+
+func mustJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// Target is a chat with an optional forum topic.
+type Target struct {
+	ChatID   string
+	ThreadID int
+}
+
+// ParseTarget parses chat ID with an optional topic ID, e.g. "-100123:45".
+func ParseTarget(s string) (Target, error) {
+	chatID, thread, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if chatID == "" {
+		return Target{}, fmt.Errorf("target %q: empty chat ID", s)
+	}
+	target := Target{ChatID: chatID}
+	if !ok {
+		return target, nil
+	}
+
+	threadID, err := strconv.Atoi(thread)
+	if err != nil || threadID <= 0 {
+		return Target{}, fmt.Errorf("target %q: invalid topic ID %q", s, thread)
+	}
+	target.ThreadID = threadID
+	return target, nil
+}
+
+func (t Target) String() string {
+	if t.ThreadID == 0 {
+		return t.ChatID
+	}
+	return t.ChatID + ":" + strconv.Itoa(t.ThreadID)
+}
@@ -0,0 +1,434 @@
+package tg
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Length limits of texts in UTF-16 code units, the way Telegram counts them.
+const (
+	MaxMessageLength = 4096
+	MaxCaptionLength = 1024
+)
+
+// UTF16Len returns the length of s in UTF-16 code units.
+func UTF16Len(s string) int {
+	n := 0
+	for _, ru := range s {
+		n += utf16.RuneLen(ru)
+	}
+	return n
+}
+
+// SendText sends text split into messages of at most MaxMessageLength, in order.
+// See SplitText for how the text is split; the parse mode is taken from WithParseMode.
+// Entities of WithEntities are split along with the text.
+// ReplyTo applies to the first message and WithKeyboard to the last one.
+// On failure, messages sent before the error are returned along with it.
+func (c *Client) SendText(ctx context.Context, chatID, text string, opts ...SendOption) ([]Message, error) {
+	options := newSendOptions(opts)
+	var parts []entityPart
+	if len(options.entities) > 0 {
+		parts = splitEntities(text, options.entities, MaxMessageLength)
+	} else {
+		for _, part := range SplitText(text, options.parseMode, MaxMessageLength) {
+			parts = append(parts, entityPart{text: part})
+		}
+	}
+
+	sent := make([]Message, 0, len(parts))
+	for i, part := range parts {
+		partOpts := slices.Clone(opts)
+		if len(options.entities) > 0 {
+			partOpts = append(partOpts, WithEntities(part.entities))
+		}
+		if i > 0 {
+			partOpts = append(partOpts, ReplyTo(0))
+		}
+		if i < len(parts)-1 {
+			partOpts = append(partOpts, func(o *sendOptions) { o.keyboard = nil })
+		}
+
+		msg, err := c.SendMessage(ctx, chatID, part.text, partOpts...)
+		if err != nil {
+			return sent, err
+		}
+		sent = append(sent, msg)
+	}
+	return sent, nil
+}
+
+// SplitText splits text into parts of at most limit UTF-16 code units.
+// Parts end at paragraph breaks if possible, then at line breaks, then between words.
+// Characters, HTML tags and escape sequences of the parse mode are never cut. Entities
+// longer than a part are closed at its end and opened again in the next part.
+// Whitespace around cuts outside of entities is dropped.
+func SplitText(text, parseMode string, limit int) []string {
+	if UTF16Len(text) <= limit || limit <= 0 {
+		return []string{text}
+	}
+
+	tokens := tokenize(text, parseMode)
+	var parts []string
+	for _, part := range splitTokens(tokens, limit) {
+		parts = append(parts, openingMarkup(part.open)+joinTokens(tokens[part.from:part.to])+closingMarkup(part.close))
+	}
+	return parts
+}
+
+// entityPart is a part of a text formatted with entities.
+type entityPart struct {
+	text     string
+	entities []MessageEntity
+}
+
+// splitEntities splits text the way SplitText does, cutting entities at the part boundaries.
+func splitEntities(text string, entities []MessageEntity, limit int) []entityPart {
+	if UTF16Len(text) <= limit || limit <= 0 {
+		return []entityPart{{text: text, entities: entities}}
+	}
+
+	tokens := entityTokens(text, entities)
+	var parts []entityPart
+	for _, part := range splitTokens(tokens, limit) {
+		last := tokens[part.to-1]
+		from, to := tokens[part.from].at, last.at+last.units
+
+		var clipped []MessageEntity
+		for _, entity := range entities {
+			start, end := max(entity.Offset, from), min(entity.Offset+entity.Length, to)
+			if end > start {
+				entity.Offset, entity.Length = start-from, end-start
+				clipped = append(clipped, entity)
+			}
+		}
+		parts = append(parts, entityPart{text: joinTokens(tokens[part.from:part.to]), entities: clipped})
+	}
+	return parts
+}
+
+// splitPart is a part of split text: tokens[from:to] with entities open at its ends.
+type splitPart struct {
+	from, to    int
+	open, close []splitEntity
+}
+
+func splitTokens(tokens []splitToken, limit int) []splitPart {
+	var (
+		parts []splitPart
+		open  []splitEntity
+	)
+	for i := 0; i < len(tokens); {
+		// take tokens while they fit along with markup closing the open entities
+		var (
+			stack    = open
+			size     = UTF16Len(openingMarkup(open))
+			cut      = -1
+			cutRank  = math.MinInt
+			cutStack []splitEntity
+			end      = i
+		)
+		for ; end < len(tokens); end++ {
+			next := tokens[end].apply(stack)
+			if size+tokens[end].units+closingLen(next) > limit {
+				break
+			}
+			size += tokens[end].units
+			stack = next
+			if rank := cutRankAt(tokens, end+1, len(stack)); rank >= cutRank {
+				cut, cutRank, cutStack = end+1, rank, stack
+			}
+		}
+		if end == len(tokens) {
+			cut, cutStack = end, stack
+		} else if cut <= i {
+			// a single token longer than the limit
+			cut, cutStack = i+1, tokens[i].apply(open)
+		}
+
+		to := cut
+		for len(cutStack) == 0 && to > i && (tokens[to-1].isSpace() || tokens[to-1].units == 0) {
+			to--
+		}
+		if strings.TrimSpace(joinTokens(tokens[i:to])) != "" {
+			parts = append(parts, splitPart{from: i, to: to, open: open, close: cutStack})
+		}
+
+		open, i = cutStack, cut
+		for len(open) == 0 && i < len(tokens) && tokens[i].isSpace() {
+			i++
+		}
+	}
+	return parts
+}
+
+// cutRankAt ranks cutting before tokens[i]: paragraph breaks are the best,
+// then line breaks and spaces. Cuts inside entities rank below any cut outside.
+func cutRankAt(tokens []splitToken, i, depth int) int {
+	rank := 1
+	switch {
+	case i >= 2 && tokens[i-1].text == "\n" && tokens[i-2].text == "\n":
+		rank = 4
+	case tokens[i-1].text == "\n":
+		rank = 3
+	case tokens[i-1].text == " ":
+		rank = 2
+	}
+	if depth > 0 {
+		rank -= 4
+	}
+	return rank
+}
+
+// splitEntity is an entity of the parse mode, reopened in the next part when cut.
+type splitEntity struct {
+	open, close string
+}
+
+// splitToken is a piece of text which is never cut.
+type splitToken struct {
+	text  string
+	units int
+	// at is the offset of the token in the text in UTF-16 code units.
+	at int
+	// opens is an entity started by the token.
+	opens *splitEntity
+	// closes reports that the token ends the innermost entity.
+	closes bool
+}
+
+func (t splitToken) apply(stack []splitEntity) []splitEntity {
+	switch {
+	case t.opens != nil:
+		return append(slices.Clip(stack), *t.opens)
+	case t.closes && len(stack) > 0:
+		return slices.Clip(stack[:len(stack)-1])
+	default:
+		return stack
+	}
+}
+
+func (t splitToken) isSpace() bool {
+	return t.text == " " || t.text == "\n"
+}
+
+func openingMarkup(stack []splitEntity) string {
+	var markup strings.Builder
+	for _, entity := range stack {
+		markup.WriteString(entity.open)
+	}
+	return markup.String()
+}
+
+func closingMarkup(stack []splitEntity) string {
+	var markup strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		markup.WriteString(stack[i].close)
+	}
+	return markup.String()
+}
+
+func closingLen(stack []splitEntity) int {
+	n := 0
+	for _, entity := range stack {
+		n += UTF16Len(entity.close)
+	}
+	return n
+}
+
+func joinTokens(tokens []splitToken) string {
+	var text strings.Builder
+	for _, token := range tokens {
+		text.WriteString(token.text)
+	}
+	return text.String()
+}
+
+func tokenize(text, parseMode string) []splitToken {
+	var (
+		tokens []splitToken
+		at     int
+	)
+	add := func(token splitToken) {
+		token.units, token.at = UTF16Len(token.text), at
+		at += token.units
+		tokens = append(tokens, token)
+	}
+
+	switch parseMode {
+	case ParseModeHTML:
+		tokenizeHTML(text, add)
+	case ParseModeMarkdownV2:
+		tokenizeMarkdownV2(text, add)
+	default:
+		for _, ru := range text {
+			add(splitToken{text: string(ru)})
+		}
+	}
+	return tokens
+}
+
+// entityTokens splits text into characters, with empty tokens opening
+// and closing the entities at their boundaries.
+func entityTokens(text string, entities []MessageEntity) []splitToken {
+	opens, closes := map[int]int{}, map[int]int{}
+	for _, entity := range entities {
+		if entity.Length > 0 {
+			opens[entity.Offset]++
+			closes[entity.Offset+entity.Length]++
+		}
+	}
+
+	var (
+		tokens []splitToken
+		at     int
+	)
+	boundary := func() {
+		for range closes[at] {
+			tokens = append(tokens, splitToken{at: at, closes: true})
+		}
+		for range opens[at] {
+			tokens = append(tokens, splitToken{at: at, opens: &splitEntity{}})
+		}
+	}
+	for _, ru := range text {
+		boundary()
+		units := utf16.RuneLen(ru)
+		tokens = append(tokens, splitToken{text: string(ru), units: units, at: at})
+		at += units
+	}
+	boundary()
+	return tokens
+}
+
+func tokenizeHTML(text string, add func(splitToken)) {
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			end := strings.IndexByte(text[i:], '>')
+			if end < 0 {
+				break
+			}
+			tag := text[i : i+end+1]
+			i += end + 1
+			if strings.HasPrefix(tag, "</") {
+				add(splitToken{text: tag, closes: true})
+				continue
+			}
+			name, _, _ := strings.Cut(strings.Trim(tag, "<>"), " ")
+			add(splitToken{text: tag, opens: &splitEntity{open: tag, close: "</" + name + ">"}})
+			continue
+		case '&':
+			// character references like &lt; or &#128512;
+			if end := strings.IndexByte(text[i:], ';'); end > 0 && end <= 10 && !strings.ContainsAny(text[i+1:i+end], " &<") {
+				add(splitToken{text: text[i : i+end+1]})
+				i += end + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		add(splitToken{text: text[i : i+size]})
+		i += size
+	}
+}
+
+// markdownMarkers are MarkdownV2 markers of entities which can be nested, longest first.
+var markdownMarkers = []string{"__", "||", "*", "_", "~"}
+
+func tokenizeMarkdownV2(text string, add func(splitToken)) {
+	var (
+		open []string
+		code string // the marker of the open code or pre entity
+	)
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		if rest[0] == '\\' && len(rest) > 1 {
+			_, size := utf8.DecodeRuneInString(rest[1:])
+			add(splitToken{text: rest[:1+size]})
+			i += 1 + size
+			continue
+		}
+
+		if code != "" {
+			// only the closing marker is special inside code
+			if strings.HasPrefix(rest, code) {
+				add(splitToken{text: code, closes: true})
+				i += len(code)
+				code = ""
+				continue
+			}
+		} else if token, ok := markdownToken(rest, open); ok {
+			switch {
+			case token.closes:
+				open = open[:len(open)-1]
+			case token.opens != nil && strings.HasPrefix(token.opens.open, "`"):
+				code = token.opens.close
+			case token.opens != nil:
+				open = append(open, token.opens.open)
+			}
+			add(token)
+			i += len(token.text)
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		add(splitToken{text: rest[:size]})
+		i += size
+	}
+}
+
+// markdownToken returns the MarkdownV2 markup at the start of rest:
+// a code or pre start, a whole link, or a marker opening or closing an entity.
+func markdownToken(rest string, open []string) (splitToken, bool) {
+	switch {
+	case strings.HasPrefix(rest, "```"):
+		start := "```"
+		// the language of the block is kept with the opening marker
+		if lang, _, ok := strings.Cut(rest[3:], "\n"); ok && !strings.ContainsAny(lang, " `") {
+			start += lang + "\n"
+		}
+		return splitToken{text: start, opens: &splitEntity{open: start, close: "```"}}, true
+	case rest[0] == '`':
+		return splitToken{text: "`", opens: &splitEntity{open: "`", close: "`"}}, true
+	case rest[0] == '[':
+		if end := markdownLinkEnd(rest); end > 0 {
+			return splitToken{text: rest[:end]}, true
+		}
+		return splitToken{}, false
+	}
+
+	for _, marker := range markdownMarkers {
+		if !strings.HasPrefix(rest, marker) {
+			continue
+		}
+		if len(open) > 0 && open[len(open)-1] == marker {
+			return splitToken{text: marker, closes: true}, true
+		}
+		return splitToken{text: marker, opens: &splitEntity{open: marker, close: marker}}, true
+	}
+	return splitToken{}, false
+}
+
+// markdownLinkEnd returns the length of a "[text](url)" link at the start of s, or 0.
+func markdownLinkEnd(s string) int {
+	closing := []byte{']', ')'}
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] != closing[0]:
+		case len(closing) == 2 && !strings.HasPrefix(s[i+1:], "("):
+			return 0
+		case len(closing) == 2:
+			closing = closing[1:]
+			i++
+		default:
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -1,18 +1,19 @@
 package tg
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
-	"net/textproto"
 	"net/url"
-	"os"
 	"strings"
+	"time"
+
+	"github.com/ninedraft/daily-bacon/internal/redact"
 )
 
 // HTTPDoer executes HTTP requests.
@@ -22,162 +23,127 @@ type HTTPDoer interface {
 
 // Client for Telegram Bot API.
 type Client struct {
-	doer   HTTPDoer
-	apiURL string
-	token  string
+	doer      HTTPDoer
+	apiURL    string
+	token     string
+	logger    *slog.Logger
+	redactor  *redact.Redactor
+	files     *FileCache
+	onMigrate MigrateFunc
 }
 
-// New creates Client with provided HTTPDoer.
-func New(doer HTTPDoer) *Client {
-	return &Client{
-		doer:   doer,
-		apiURL: "https://api.telegram.org",
-		token:  os.Getenv("TELEGRAM_TOKEN"),
+// New creates Client. The token must be provided with WithToken.
+// The token is redacted from returned errors and logs.
+func New(opts ...Option) *Client {
+	c := defaultClient()
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.redactor = redact.New(c.token)
+	c.logger = slog.New(c.redactor.Handler(c.logger.Handler()))
+	return c
 }
 
-// Img represents image to send.
-type Img struct {
-	Name   string
-	Reader io.Reader
+// SendMessage sends text message.
+// If the chat was migrated to a supergroup, the message is sent to the new chat.
+func (c *Client) SendMessage(ctx context.Context, chatID, msg string, opts ...SendOption) (Message, error) {
+	options := newSendOptions(opts)
+
+	var sent Message
+	err := c.withMigration(ctx, chatID, func(chatID string) error {
+		data := url.Values{}
+		data.Set("chat_id", chatID)
+		data.Set("text", msg)
+		for _, field := range options.fields(true) {
+			data.Set(field.name, field.value)
+		}
+
+		return c.callForm(ctx, "sendMessage", data, &sent)
+	})
+	if err != nil {
+		return Message{}, err
+	}
+	return sent, nil
 }
 
-// MediaUpload represents a single attachment inside a Telegram media group.
-type MediaUpload struct {
-	Type        string
-	FileName    string
-	Reader      io.Reader
-	ContentType string
-	Caption     string
+// callForm invokes Bot API method with URL-encoded parameters.
+func (c *Client) callForm(ctx context.Context, method string, data url.Values, dst any) error {
+	return c.call(ctx, method, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()), dst)
 }
 
-// SendMessage sends text message. Images are ignored in this simple implementation.
-func (c *Client) SendMessage(ctx context.Context, chatID, msg string, _ ...Img) error {
+const responseSizeLimit = 10_000_000
+
+// call invokes Bot API method and decodes its result into dst.
+// Errors are redacted before wrapping, so the token can't be found in any of them.
+func (c *Client) call(ctx context.Context, method, contentType string, body io.Reader, dst any) error {
 	if c.doer == nil {
 		return errors.New("nil HTTPDoer")
 	}
 	if c.token == "" {
 		return errors.New("empty token")
 	}
-	data := url.Values{}
-	data.Set("chat_id", chatID)
-	data.Set("text", msg)
-	u := fmt.Sprintf("%s/bot%s/sendMessage", c.apiURL, c.token)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(data.Encode()))
+
+	u := fmt.Sprintf("%s/bot%s/%s", c.apiURL, c.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
 	if err != nil {
-		return fmt.Errorf("new request url=%s: %w", u, err)
+		return fmt.Errorf("new request url=%s: %w", c.redactor.String(u), c.redactor.Error(err))
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
+
+	start := time.Now()
 	resp, err := c.doer.Do(req)
 	if err != nil {
+		err = c.redactor.Error(err)
+		c.logger.DebugContext(ctx, "bot api call", "method", method, "err", err)
 		return fmt.Errorf("do request: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(b))
-	}
-	return nil
-}
 
-// SendMediaGroup uploads multiple files as an album / media group.
-func (c *Client) SendMediaGroup(ctx context.Context, chatID string, uploads []MediaUpload) error {
-	if len(uploads) == 0 {
-		return errors.New("media group requires at least one upload")
-	}
-	if c.doer == nil {
-		return errors.New("nil HTTPDoer")
-	}
-	if c.token == "" {
-		return errors.New("empty token")
-	}
-
-	type mediaItem struct {
-		Type    string `json:"type"`
-		Media   string `json:"media"`
-		Caption string `json:"caption,omitempty"`
-	}
-
-	items := make([]mediaItem, 0, len(uploads))
-	for i, upload := range uploads {
-		if upload.Reader == nil {
-			return fmt.Errorf("upload %d has nil reader", i)
-		}
-		items = append(items, mediaItem{
-			Type:    resolveMediaType(upload),
-			Media:   fmt.Sprintf("attach://file%d", i),
-			Caption: upload.Caption,
-		})
+	err = decodeResponse(method, resp, dst)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		apiErr.Description = c.redactor.String(apiErr.Description)
 	}
+	c.logger.DebugContext(ctx, "bot api call", "method", method, "status", resp.StatusCode, "duration", time.Since(start), "err", err)
+	return err
+}
 
-	mediaJSON, err := json.Marshal(items)
+func decodeResponse(method string, resp *http.Response, dst any) error {
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, responseSizeLimit))
 	if err != nil {
-		return fmt.Errorf("marshal media payload: %w", err)
-	}
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	if err := writer.WriteField("chat_id", chatID); err != nil {
-		return fmt.Errorf("write chat_id field: %w", err)
-	}
-	if err := writer.WriteField("media", string(mediaJSON)); err != nil {
-		return fmt.Errorf("write media field: %w", err)
+		return fmt.Errorf("read response: %w", err)
 	}
 
-	for i, upload := range uploads {
-		fileName := upload.FileName
-		if fileName == "" {
-			fileName = fmt.Sprintf("file%d", i)
-		}
-		header := textproto.MIMEHeader{}
-		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file%d"; filename="%s"`, i, fileName))
-
-		header.Set("Content-Type", "application/octet-stream")
-		if upload.ContentType != "" {
-			header.Set("Content-Type", upload.ContentType)
+	var envelope response
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &APIError{
+				Method:      method,
+				Code:        resp.StatusCode,
+				Description: strings.TrimSpace(string(raw)),
+			}
 		}
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
 
-		part, err := writer.CreatePart(header)
-		if err != nil {
-			return fmt.Errorf("create part: %w", err)
+	if !envelope.OK {
+		apiErr := &APIError{
+			Method:      method,
+			Code:        cmp.Or(envelope.ErrorCode, resp.StatusCode),
+			Description: envelope.Description,
 		}
-		if _, err := io.Copy(part, upload.Reader); err != nil {
-			return fmt.Errorf("copy upload %d: %w", i, err)
+		if params := envelope.Parameters; params != nil {
+			apiErr.RetryAfter = time.Duration(params.RetryAfter) * time.Second
+			apiErr.MigrateToChatID = params.MigrateToChatID
 		}
+		return apiErr
 	}
 
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("close multipart writer: %w", err)
+	if dst == nil || len(envelope.Result) == 0 {
+		return nil
 	}
-
-	u := fmt.Sprintf("%s/bot%s/sendMediaGroup", c.apiURL, c.token)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
-	if err != nil {
-		return fmt.Errorf("new request url=%s: %w", u, err)
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	resp, err := c.doer.Do(req)
-	if err != nil {
-		return fmt.Errorf("do request: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-	if resp.StatusCode != http.StatusOK {
-		payload, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, payload)
+	if err := json.Unmarshal(envelope.Result, dst); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
 	}
 	return nil
 }
-
-func resolveMediaType(upload MediaUpload) string {
-	if t := upload.Type; t != "" {
-		return t
-	}
-	ct := strings.ToLower(upload.ContentType)
-	if strings.HasPrefix(ct, "image/") {
-		return "photo"
-	}
-
-	return "document"
-}
@@ -1,37 +1,179 @@
 package tg
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
 )
 
 func TestClient_SendMessage(t *testing.T) {
-	const token = "tok"
-	t.Setenv("TELEGRAM_TOKEN", token)
+	srv := tgtest.NewServer(t)
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	msg, err := c.SendMessage(t.Context(), "1", "hello")
+	require.NoError(t, err)
+	require.Equal(t, 1, msg.MessageID)
+	require.Equal(t, int64(1), msg.Chat.ID)
+	require.Equal(t, "hello", msg.Text)
+	require.WithinDuration(t, time.Now(), msg.Time(), time.Minute)
 
-	var called bool
+	messages := srv.Messages("1")
+	require.Len(t, messages, 1)
+	require.Equal(t, "hello", messages[0].Text)
+}
+
+func TestClient_MultipleBots(t *testing.T) {
+	var paths []string
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		called = true
-		require.Equal(t, "/bot"+token+"/sendMessage", r.URL.Path)
-		require.Equal(t, http.MethodPost, r.Method)
-		w.WriteHeader(http.StatusOK)
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
 	}))
 	defer srv.Close()
 
-	c := New(srv.Client())
-	c.apiURL = srv.URL
+	first := New(WithToken("first"), WithAPIURL(srv.URL+"/"), WithDoer(srv.Client()))
+	second := New(WithToken("second"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	_, err := first.SendMessage(t.Context(), "1", "hello")
+	require.NoError(t, err)
+	_, err = second.SendMessage(t.Context(), "1", "hello")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/botfirst/sendMessage", "/botsecond/sendMessage"}, paths)
+
+	_, err = New(WithAPIURL(srv.URL)).SendMessage(t.Context(), "1", "hello")
+	require.ErrorContains(t, err, "empty token")
+}
+
+func TestClient_NilLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()), WithLogger(nil))
+
+	_, err := c.SendMessage(t.Context(), "1", "hello")
+	require.NoError(t, err)
+}
+
+type failingDoer struct{}
+
+func (failingDoer) Do(req *http.Request) (*http.Response, error) {
+	return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("connection refused")}
+}
+
+func TestClient_RedactsToken(t *testing.T) {
+	const token = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized: token ` + token + `"}`))
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	clients := map[string]*Client{
+		"network":   New(WithToken(token), WithDoer(failingDoer{}), WithLogger(logger)),
+		"api":       New(WithToken(token), WithAPIURL(srv.URL), WithDoer(srv.Client()), WithLogger(logger)),
+		"bad url":   New(WithToken(token), WithAPIURL("http://bad\x7f"), WithLogger(logger)),
+		"bad chars": New(WithToken(token), WithAPIURL("http://[::1"), WithLogger(logger)),
+	}
+	for name, c := range clients {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.SendMessage(t.Context(), "1", "hello")
+			require.Error(t, err)
+			require.NotContains(t, err.Error(), token)
 
-	err := c.SendMessage(t.Context(), "1", "hello")
+			for unwrapped := err; unwrapped != nil; unwrapped = errors.Unwrap(unwrapped) {
+				require.NotContains(t, unwrapped.Error(), token)
+			}
+
+			_, err = c.SendDocument(t.Context(), "1", MediaUpload{Reader: strings.NewReader("data")})
+			require.Error(t, err)
+			require.NotContains(t, err.Error(), token)
+		})
+	}
+
+	require.NotEmpty(t, logs.String())
+	require.NotContains(t, logs.String(), token)
+}
+
+func TestClient_Retry(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	var attempts []int
+	ctx := WithRetry(t.Context(), func(_ context.Context, attempt int, err error) bool {
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		attempts = append(attempts, attempt)
+		return true
+	})
+
+	srv.Fail("sendPhoto", tgtest.Fault{Code: http.StatusBadGateway, Description: "Bad Gateway"})
+	_, err := c.SendPhoto(ctx, "1", MediaUpload{Reader: strings.NewReader("photo")})
 	require.NoError(t, err)
-	require.True(t, called)
+	require.Equal(t, []int{1}, attempts)
+	require.Equal(t, 2, srv.Calls("sendPhoto"))
+	require.Len(t, srv.Messages("1"), 1)
+
+	// uploads which can't be rewound are not repeated
+	srv.Fail("sendPhoto", tgtest.Fault{Code: http.StatusBadGateway, Description: "Bad Gateway"})
+	_, err = c.SendPhoto(ctx, "1", MediaUpload{Reader: io.MultiReader(strings.NewReader("photo"))})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusBadGateway, apiErr.Code)
+	require.Equal(t, 3, srv.Calls("sendPhoto"))
+}
+
+func TestClient_Wait(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	waits := 0
+	ctx := WithWait(t.Context(), func(context.Context) error {
+		waits++
+		return nil
+	})
+	ctx = WithRetry(ctx, func(context.Context, int, error) bool { return true })
+
+	srv.Fail("sendMessage", tgtest.Fault{Code: http.StatusBadGateway, Description: "Bad Gateway"})
+	sent, err := c.SendText(ctx, "1", strings.Repeat("a", MaxMessageLength+1))
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	require.NoError(t, c.PinChatMessage(ctx, "1", sent[0].MessageID, true))
+	require.Equal(t, 4, waits, "every part, repeated call and pin waits")
+
+	// calls are not made if the wait fails
+	errLimit := errors.New("limit")
+	ctx = WithWait(t.Context(), func(context.Context) error { return errLimit })
+	_, err = c.SendMessage(ctx, "1", "hello")
+	require.ErrorIs(t, err, errLimit)
+	require.Equal(t, 3, srv.Calls("sendMessage"))
 }
 
 func TestClient_SendMessage_Error(t *testing.T) {
 	const token = "tok"
-	t.Setenv("TELEGRAM_TOKEN", token)
 
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
@@ -39,9 +181,902 @@ func TestClient_SendMessage_Error(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	c := New(srv.Client())
-	c.apiURL = srv.URL
+	c := New(WithToken(token), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	_, err := c.SendMessage(t.Context(), "1", "hello")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusBadRequest, apiErr.Code)
+	require.Equal(t, "bad", apiErr.Description)
+}
+
+func TestClient_SendMessage_APIError(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(srv *tgtest.Server)
+		text    string
+		target  error
+	}{
+		{
+			name:    "blocked",
+			prepare: func(srv *tgtest.Server) { srv.Block("1") },
+			text:    "hello",
+			target:  ErrBotBlocked,
+		},
+		{
+			name: "chat not found",
+			prepare: func(srv *tgtest.Server) {
+				srv.Fail("sendMessage", tgtest.Fault{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"})
+			},
+			text:   "hello",
+			target: ErrChatNotFound,
+		},
+		{
+			name:    "too long",
+			prepare: func(*tgtest.Server) {},
+			text:    strings.Repeat("x", tgtest.MaxTextLength+1),
+			target:  ErrMessageTooLong,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tgtest.NewServer(t)
+			tc.prepare(srv)
+
+			c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+			_, err := c.SendMessage(t.Context(), "1", tc.text)
+			require.ErrorIs(t, err, tc.target)
+			require.Empty(t, srv.Messages("1"))
+		})
+	}
+}
+
+func TestClient_SendMessage_RetryAfter(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	srv.Fail("sendMessage", tgtest.TooManyRequests(5*time.Second))
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	_, err := c.SendMessage(t.Context(), "1", "hello")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusTooManyRequests, apiErr.Code)
+	require.Equal(t, 5*time.Second, apiErr.RetryAfter)
+	require.NotErrorIs(t, err, ErrChatNotFound)
+}
+
+func TestClient_Migration(t *testing.T) {
+	const token = "tok"
+
+	var uploaded []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			require.NoError(t, r.ParseMultipartForm(1<<20))
+			if file, _, err := r.FormFile("file0"); err == nil {
+				data, _ := io.ReadAll(file)
+				uploaded = append(uploaded, string(data))
+			}
+		}
+		if r.FormValue("chat_id") == "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1001}}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/sendMediaGroup") {
+			_, _ = w.Write([]byte(`{"ok":true,"result":[{"message_id":8,"chat":{"id":-1001}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":7,"chat":{"id":-1001}}}`))
+	}))
+	defer srv.Close()
+
+	var migrations [][2]string
+	c := New(WithToken(token), WithAPIURL(srv.URL), WithDoer(srv.Client()),
+		WithMigrationHandler(func(from, to string) {
+			migrations = append(migrations, [2]string{from, to})
+		}))
+
+	msg, err := c.SendMessage(t.Context(), "1", "hello")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1001), msg.Chat.ID)
+	require.Equal(t, [][2]string{{"1", "-1001"}}, migrations)
+
+	msgs, err := c.SendMediaGroup(t.Context(), "1", []MediaUpload{
+		{Reader: strings.NewReader("data")},
+		{Reader: strings.NewReader("more")},
+	})
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, []string{"data", "data"}, uploaded, "upload must be rewound before retry")
+
+	_, err = c.SendMediaGroup(t.Context(), "1", []MediaUpload{
+		{Reader: io.MultiReader(strings.NewReader("data"))},
+		{Reader: strings.NewReader("more")},
+	})
+	var migrated *ChatMigratedError
+	require.ErrorAs(t, err, &migrated)
+	require.Equal(t, "-1001", migrated.To)
+}
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (fn doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestClient_SendMediaGroup_Streaming(t *testing.T) {
+	t.Run("upload error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_, _ = w.Write([]byte(`{"ok":true,"result":[]}`))
+		}))
+		defer srv.Close()
+
+		readErr := errors.New("disk failure")
+		c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+		_, err := c.SendMediaGroup(t.Context(), "1", []MediaUpload{
+			{Reader: strings.NewReader("data")},
+			{Reader: io.MultiReader(strings.NewReader("partial"), failingReader{err: readErr})},
+		})
+		require.ErrorIs(t, err, readErr)
+	})
+
+	t.Run("body not consumed", func(t *testing.T) {
+		c := New(WithToken("tok"), WithDoer(doerFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("refused")
+		})))
+
+		_, err := c.SendDocument(t.Context(), "1", MediaUpload{Reader: strings.NewReader(strings.Repeat("x", 1<<20))})
+		require.ErrorContains(t, err, "refused")
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		c := New(WithToken("tok"), WithDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+			_, _ = io.ReadFull(req.Body, make([]byte, 16))
+			cancel()
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})))
+
+		_, err := c.SendDocument(ctx, "1", MediaUpload{Reader: strings.NewReader(strings.Repeat("x", 1<<20))})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// sendMediaGroupBuffered is the previous implementation,
+// which copied all uploads into memory before sending.
+func (c *Client) sendMediaGroupBuffered(ctx context.Context, chatID string, uploads []MediaUpload) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("chat_id", chatID); err != nil {
+		return err
+	}
+	if err := writer.WriteField("media", `[]`); err != nil {
+		return err
+	}
+	for i, upload := range uploads {
+		part, err := writer.CreateFormFile(fmt.Sprintf("file%d", i), upload.FileName)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, upload.Reader); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return c.call(ctx, "sendMediaGroup", writer.FormDataContentType(), body, nil)
+}
+
+func BenchmarkSendMediaGroup(b *testing.B) {
+	photo := bytes.Repeat([]byte{0xff}, 4<<20)
+
+	c := New(WithToken("tok"), WithDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		_, _ = io.Copy(io.Discard, req.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":[]}`)),
+		}, nil
+	})))
+
+	uploads := func() []MediaUpload {
+		uploads := make([]MediaUpload, 10)
+		for i := range uploads {
+			uploads[i] = MediaUpload{FileName: "photo.png", ContentType: "image/png", Reader: bytes.NewReader(photo)}
+		}
+		return uploads
+	}
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(10 * len(photo)))
+		for b.Loop() {
+			_, err := c.SendMediaGroup(b.Context(), "1", uploads())
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(10 * len(photo)))
+		for b.Loop() {
+			require.NoError(b, c.sendMediaGroupBuffered(b.Context(), "1", uploads()))
+		}
+	})
+}
+
+func TestSplitMedia(t *testing.T) {
+	upload := func(contentType string) MediaUpload {
+		return MediaUpload{ContentType: contentType, Reader: strings.NewReader("data")}
+	}
+	var uploads []MediaUpload
+	for range 12 {
+		uploads = append(uploads, upload("image/png"))
+	}
+	uploads = append(uploads,
+		upload("video/mp4"),
+		upload("application/pdf"),
+		upload("application/zip"),
+		upload("image/gif"),
+		upload("image/jpeg"),
+		upload("audio/mpeg"),
+	)
+
+	var types [][]string
+	for _, batch := range splitMedia(uploads) {
+		var batchTypes []string
+		for _, upload := range batch {
+			batchTypes = append(batchTypes, upload.Type)
+		}
+		types = append(types, batchTypes)
+	}
+
+	photos := func(n int) []string {
+		return slices.Repeat([]string{MediaPhoto}, n)
+	}
+	require.Equal(t, [][]string{
+		photos(10),
+		append(photos(2), MediaVideo),
+		{MediaDocument, MediaDocument},
+		{MediaAnimation},
+		{MediaPhoto},
+		{MediaAudio},
+	}, types)
+}
+
+func TestValidateMediaGroup(t *testing.T) {
+	require.NoError(t, ValidateMediaGroup([]string{MediaPhoto, MediaVideo}))
+	require.NoError(t, ValidateMediaGroup([]string{MediaDocument, MediaDocument}))
+	require.ErrorContains(t, ValidateMediaGroup([]string{MediaPhoto}), "must have 2-10 uploads")
+	require.ErrorIs(t, ValidateMediaGroup([]string{MediaPhoto, MediaAudio}), ErrMixedMediaGroup)
+	require.ErrorIs(t, ValidateMediaGroup([]string{MediaAnimation, MediaAnimation}), ErrMixedMediaGroup)
+
+	require.True(t, IsParseMode(ParseModeMarkdown))
+	require.False(t, IsParseMode("markdown"))
+}
+
+func TestClient_SendMedia(t *testing.T) {
+	type request struct {
+		method string
+		fields map[string]string
+		files  []string
+	}
+	var requests []request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		req := request{method: path.Base(r.URL.Path), fields: map[string]string{}}
+		for key, values := range r.MultipartForm.Value {
+			req.fields[key] = values[0]
+		}
+		for field := range r.MultipartForm.File {
+			req.files = append(req.files, field)
+		}
+		slices.Sort(req.files)
+		requests = append(requests, req)
+
+		if req.method == "sendMediaGroup" {
+			_, _ = w.Write([]byte(`{"ok":true,"result":[{"message_id":1},{"message_id":2}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":3}}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	msgs, err := c.SendMedia(t.Context(), "1", []MediaUpload{
+		{ContentType: "image/png", Reader: strings.NewReader("photo"), Caption: "<b>hi</b>", ParseMode: ParseModeHTML},
+		{ContentType: "video/mp4", Reader: strings.NewReader("video"), Thumbnail: strings.NewReader("thumb")},
+		{ContentType: "application/pdf", Reader: strings.NewReader("report"), Caption: "report", Thumbnail: strings.NewReader("thumb")},
+	})
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	require.Len(t, requests, 2)
+
+	album := requests[0]
+	require.Equal(t, "sendMediaGroup", album.method)
+	require.Equal(t, []string{"file0", "file1", "thumbnail1"}, album.files)
+	require.JSONEq(t, `[
+		{"type":"photo","media":"attach://file0","caption":"<b>hi</b>","parse_mode":"HTML"},
+		{"type":"video","media":"attach://file1","thumbnail":"attach://thumbnail1"}
+	]`, album.fields["media"])
+
+	document := requests[1]
+	require.Equal(t, "sendDocument", document.method)
+	require.Equal(t, []string{"document", "thumbnail"}, document.files)
+	require.Equal(t, "report", document.fields["caption"])
+	require.Equal(t, "attach://thumbnail", document.fields["thumbnail"])
+
+	_, err = c.SendMediaGroup(t.Context(), "1", []MediaUpload{
+		{Type: MediaPhoto, Reader: strings.NewReader("photo")},
+		{Type: MediaDocument, Reader: strings.NewReader("document")},
+	})
+	require.ErrorIs(t, err, ErrMixedMediaGroup)
+}
+
+func TestClient_FileCache(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		uploads  int
+		reused   []string
+		rejectID string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if id := r.FormValue("photo"); id != "" {
+			if id == rejectID {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`))
+				return
+			}
+			reused = append(reused, id)
+			_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"photo":[{"file_id":%q}]}}`, id)
+			return
+		}
+
+		uploads++
+		_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"photo":[{"file_id":"small"},{"file_id":"id-%d"}]}}`, uploads)
+	}))
+	defer srv.Close()
+
+	cache := NewFileCache()
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()), WithFileCache(cache))
+
+	chart := []byte("chart bytes")
+	errs := make([]error, 5)
+	fileIDs := make([]string, 5)
+	var wg sync.WaitGroup
+	for chat := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := c.SendPhoto(t.Context(), strconv.Itoa(chat), MediaUpload{Reader: bytes.NewReader(chart)})
+			errs[chat], fileIDs[chat] = err, msg.FileID()
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, errors.Join(errs...))
+	require.Equal(t, slices.Repeat([]string{"id-1"}, 5), fileIDs)
+
+	require.Equal(t, 1, uploads, "identical files must be uploaded once")
+	require.Equal(t, []string{"id-1", "id-1", "id-1", "id-1"}, reused)
+	require.Equal(t, 1, cache.Len())
+
+	rejectID = "id-1"
+	msg, err := c.SendPhoto(t.Context(), "1", MediaUpload{Reader: bytes.NewReader(chart)})
+	require.NoError(t, err)
+	require.Equal(t, "id-2", msg.FileID(), "rejected file_id must be replaced by a new upload")
+
+	_, err = c.SendPhoto(t.Context(), "1", MediaUpload{Reader: io.MultiReader(bytes.NewReader(chart))})
+	require.NoError(t, err)
+	require.Equal(t, 3, uploads, "non-seekable uploads are not cached")
+}
+
+func TestClient_FileCache_RejectedAlbum(t *testing.T) {
+	var albums [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+
+		if strings.HasSuffix(r.URL.Path, "/sendPhoto") {
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"photo":[{"file_id":"cached"}]}}`))
+			return
+		}
+		if strings.Contains(r.FormValue("media"), `"media":"cached"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`))
+			return
+		}
+
+		var album []string
+		for _, name := range []string{"file0", "file1"} {
+			file, _, err := r.FormFile(name)
+			require.NoError(t, err)
+			content, err := io.ReadAll(file)
+			require.NoError(t, err)
+			album = append(album, string(content))
+		}
+		albums = append(albums, album)
+		_, _ = w.Write([]byte(`{"ok":true,"result":[{"message_id":2,"photo":[{"file_id":"a"}]},{"message_id":3,"photo":[{"file_id":"b"}]}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()), WithFileCache(NewFileCache()))
+
+	_, err := c.SendPhoto(t.Context(), "1", MediaUpload{Reader: strings.NewReader("first chart")})
+	require.NoError(t, err)
+
+	// the cached file_id of the first chart is rejected, the new chart
+	// is read by the first attempt and must be uploaded in full again
+	_, err = c.SendMediaGroup(t.Context(), "1", []MediaUpload{
+		{Type: MediaPhoto, Reader: strings.NewReader("first chart")},
+		{Type: MediaPhoto, Reader: strings.NewReader("second chart")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"first chart", "second chart"}}, albums)
+}
+
+func TestClient_SendOptions(t *testing.T) {
+	var forms []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			require.ErrorIs(t, err, http.ErrNotMultipart)
+		}
+		forms = append(forms, r.Form)
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"message_thread_id":45}}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	msg, err := c.SendMessage(t.Context(), "-100123", "https://example.com",
+		InThread(45), ReplyTo(7), Silent(true), WithoutLinkPreview(true))
+	require.NoError(t, err)
+	require.Equal(t, 45, msg.MessageThreadID)
+
+	_, err = c.SendPhoto(t.Context(), "-100123", MediaUpload{Reader: strings.NewReader("photo")},
+		InThread(45), WithoutLinkPreview(true))
+	require.NoError(t, err)
+
+	_, err = c.SendMessage(t.Context(), "-100123", "plain", InThread(0), Silent(false))
+	require.NoError(t, err)
+
+	require.Len(t, forms, 3)
+	require.Equal(t, "45", forms[0].Get("message_thread_id"))
+	require.JSONEq(t, `{"message_id":7,"allow_sending_without_reply":true}`, forms[0].Get("reply_parameters"))
+	require.Equal(t, "true", forms[0].Get("disable_notification"))
+	require.JSONEq(t, `{"is_disabled":true}`, forms[0].Get("link_preview_options"))
+
+	require.Equal(t, "45", forms[1].Get("message_thread_id"))
+	require.False(t, forms[1].Has("link_preview_options"), "link previews only apply to text")
+
+	for _, key := range []string{"message_thread_id", "reply_parameters", "disable_notification", "link_preview_options"} {
+		require.False(t, forms[2].Has(key), key)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("-100123:45")
+	require.NoError(t, err)
+	require.Equal(t, Target{ChatID: "-100123", ThreadID: 45}, target)
+	require.Equal(t, "-100123:45", target.String())
+
+	target, err = ParseTarget("@channel")
+	require.NoError(t, err)
+	require.Equal(t, Target{ChatID: "@channel"}, target)
+	require.Equal(t, "@channel", target.String())
+
+	for _, bad := range []string{"", ":45", "-100123:", "-100123:topic", "-100123:-1"} {
+		_, err := ParseTarget(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestClient_Edit(t *testing.T) {
+	var pinned []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			require.ErrorIs(t, err, http.ErrNotMultipart)
+		}
+		switch path.Base(r.URL.Path) {
+		case "editMessageText":
+			switch r.FormValue("message_id") {
+			case "1":
+				_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"text":%q}}`, r.FormValue("text"))
+			case "2":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message to edit not found"}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message is not modified: specified new message content and reply markup are exactly the same"}`))
+			}
+		case "editMessageMedia":
+			file, _, err := r.FormFile("file")
+			require.NoError(t, err)
+			data, _ := io.ReadAll(file)
+			require.Equal(t, "chart", string(data))
+			require.JSONEq(t, `{"type":"photo","media":"attach://file","caption":"today"}`, r.FormValue("media"))
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"caption":"today","photo":[{"file_id":"chart-id"}]}}`))
+		case "pinChatMessage":
+			pinned = append(pinned, r.FormValue("message_id")+"/"+r.FormValue("disable_notification"))
+			_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	msg, err := c.EditMessageText(t.Context(), "1", 1, "updated")
+	require.NoError(t, err)
+	require.Equal(t, "updated", msg.Text)
+
+	_, err = c.EditMessageText(t.Context(), "1", 2, "updated")
+	require.ErrorIs(t, err, ErrMessageNotFound)
+
+	_, err = c.EditMessageText(t.Context(), "1", 3, "updated")
+	require.ErrorIs(t, err, ErrMessageNotModified)
+
+	msg, err = c.EditMessageMedia(t.Context(), "1", 1, MediaUpload{
+		ContentType: "image/png",
+		Reader:      strings.NewReader("chart"),
+		Caption:     "today",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "chart-id", msg.FileID())
+
+	require.NoError(t, c.PinChatMessage(t.Context(), "1", 1, true))
+	require.Equal(t, []string{"1/true"}, pinned)
+}
+
+func TestClient_Poll(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		offsets []string
+		calls   int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/bottok/getUpdates", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "1", r.PostForm.Get("timeout"))
+		require.JSONEq(t, `["message"]`, r.PostForm.Get("allowed_updates"))
+
+		mu.Lock()
+		calls++
+		call := calls
+		offsets = append(offsets, r.PostForm.Get("offset"))
+		mu.Unlock()
+
+		switch call {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+		case 2:
+			_, _ = w.Write([]byte(`{"ok":true,"result":[{"update_id":5,"message":{"message_id":1,"chat":{"id":1},"text":"/now","from":{"id":2,"is_bot":false,"first_name":"Ann"}}},{"update_id":6}]}`))
+		default:
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var (
+		handled   []int
+		committed []int
+	)
+	opts := PollOptions{
+		Offset:         5,
+		Timeout:        time.Second,
+		AllowedUpdates: []string{"message"},
+		Commit: func(offset int) error {
+			committed = append(committed, offset)
+			return nil
+		},
+	}
+	err := c.Poll(ctx, opts, func(ctx context.Context, update Update) {
+		// shutdown must not interrupt updates which are already received
+		cancel()
+		require.NoError(t, ctx.Err())
+		handled = append(handled, update.UpdateID)
+		if update.Message != nil {
+			require.Equal(t, "Ann", update.Message.From.FirstName)
+		}
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []int{5, 6}, handled)
+	require.Equal(t, []int{7}, committed)
+	require.Equal(t, []string{"5", "5"}, offsets)
 
-	err := c.SendMessage(t.Context(), "1", "hello")
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+	}))
+	defer unauthorized.Close()
+
+	c = New(WithToken("tok"), WithAPIURL(unauthorized.URL), WithDoer(unauthorized.Client()))
+	err = c.Poll(t.Context(), opts, func(context.Context, Update) {
+		t.Fatal("unexpected update")
+	})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusUnauthorized, apiErr.Code)
+}
+
+func TestCallbackData(t *testing.T) {
+	data, err := EncodeCallbackData("r", "now", "34.7071", "33.0226")
+	require.NoError(t, err)
+	require.Equal(t, "r|now|34.7071|33.0226", data)
+	require.Equal(t, []string{"r", "now", "34.7071", "33.0226"}, DecodeCallbackData(data))
+
+	_, err = EncodeCallbackData("a|b")
 	require.Error(t, err)
+
+	_, err = EncodeCallbackData(strings.Repeat("x", MaxCallbackData))
+	require.NoError(t, err)
+	_, err = EncodeCallbackData(strings.Repeat("x", MaxCallbackData-1), "é")
+	require.ErrorIs(t, err, ErrCallbackDataTooLong)
+}
+
+func TestClient_Keyboard(t *testing.T) {
+	keyboard := InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: "Pollen", CallbackData: "r|pollen"},
+	}}}
+
+	calls := map[string]url.Values{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		calls[path.Base(r.URL.Path)] = r.PostForm
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	_, err := c.SendMessage(t.Context(), "1", "hi", WithKeyboard(keyboard), InThread(3))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"inline_keyboard":[[{"text":"Pollen","callback_data":"r|pollen"}]]}`, calls["sendMessage"].Get("reply_markup"))
+
+	_, err = c.EditMessageText(t.Context(), "1", 1, "edited", WithKeyboard(keyboard), InThread(3), Silent(true))
+	require.NoError(t, err)
+	edit := calls["editMessageText"]
+	require.JSONEq(t, `{"inline_keyboard":[[{"text":"Pollen","callback_data":"r|pollen"}]]}`, edit.Get("reply_markup"))
+	require.False(t, edit.Has("message_thread_id"), "edits can't move messages between topics")
+	require.False(t, edit.Has("disable_notification"))
+
+	require.NoError(t, c.AnswerCallbackQuery(t.Context(), "q1", "done", true))
+	answer := calls["answerCallbackQuery"]
+	require.Equal(t, "q1", answer.Get("callback_query_id"))
+	require.Equal(t, "done", answer.Get("text"))
+	require.Equal(t, "true", answer.Get("show_alert"))
+}
+
+func TestClient_Inline(t *testing.T) {
+	calls := map[string]url.Values{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		calls[path.Base(r.URL.Path)] = r.PostForm
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer srv.Close()
+
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	require.NoError(t, c.AnswerInlineQuery(t.Context(), "iq", nil, InlineAnswer{}))
+	answer := calls["answerInlineQuery"]
+	require.Equal(t, "iq", answer.Get("inline_query_id"))
+	require.Equal(t, "[]", answer.Get("results"))
+	require.False(t, answer.Has("cache_time"))
+
+	results := make([]InlineQueryResultArticle, MaxInlineResults+1)
+	for i := range results {
+		results[i] = NewArticle(strconv.Itoa(i), "title", "", "text")
+	}
+	err := c.AnswerInlineQuery(t.Context(), "iq", results, InlineAnswer{CacheTime: time.Minute, IsPersonal: true})
+	require.NoError(t, err)
+	answer = calls["answerInlineQuery"]
+	require.Equal(t, "60", answer.Get("cache_time"))
+	require.Equal(t, "true", answer.Get("is_personal"))
+	var sent []InlineQueryResultArticle
+	require.NoError(t, json.Unmarshal([]byte(answer.Get("results")), &sent))
+	require.Len(t, sent, MaxInlineResults)
+	require.Equal(t, "article", sent[0].Type)
+
+	require.NoError(t, c.EditInlineMessageText(t.Context(), "inline-1", "edited", InThread(3)))
+	edit := calls["editMessageText"]
+	require.Equal(t, "inline-1", edit.Get("inline_message_id"))
+	require.Equal(t, "edited", edit.Get("text"))
+	require.False(t, edit.Has("chat_id"))
+	require.False(t, edit.Has("message_thread_id"))
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		parseMode string
+		limit     int
+		want      []string
+	}{
+		{
+			name:  "short",
+			text:  "hello",
+			limit: 10,
+			want:  []string{"hello"},
+		},
+		{
+			name:  "paragraphs first",
+			text:  "first paragraph\n\nsecond one\nwith lines and words",
+			limit: 30,
+			want:  []string{"first paragraph", "second one", "with lines and words"},
+		},
+		{
+			name:  "words",
+			text:  "one two three four",
+			limit: 9,
+			want:  []string{"one two", "three", "four"},
+		},
+		{
+			name:  "surrogate pairs",
+			text:  "🥓🥓🥓",
+			limit: 3,
+			want:  []string{"🥓", "🥓", "🥓"},
+		},
+		{
+			name:      "html entities are reopened",
+			text:      "<b>bold text</b> tail",
+			parseMode: ParseModeHTML,
+			limit:     14,
+			want:      []string{"<b>bold </b>", "<b>text</b>", "tail"},
+		},
+		{
+			name:      "html character references",
+			text:      "a &lt;&lt; b",
+			parseMode: ParseModeHTML,
+			limit:     5,
+			want:      []string{"a", "&lt;", "&lt;", "b"},
+		},
+		{
+			name:      "markdown pre keeps language",
+			text:      "```go\nfmt.Println(1)\nfmt.Println(2)\n```",
+			parseMode: ParseModeMarkdownV2,
+			limit:     25,
+			want:      []string{"```go\nfmt.Println(1)\n```", "```go\nfmt.Println(2)\n```"},
+		},
+		{
+			name:      "markdown links and escapes",
+			text:      `1\.5 [a link](https://example.com) done`,
+			parseMode: ParseModeMarkdownV2,
+			limit:     32,
+			want:      []string{`1\.5`, `[a link](https://example.com)`, "done"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parts := SplitText(tc.text, tc.parseMode, tc.limit)
+			require.Equal(t, tc.want, parts)
+			for _, part := range parts {
+				require.LessOrEqual(t, UTF16Len(part), tc.limit)
+			}
+		})
+	}
+}
+
+func TestClient_SendText(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	var text strings.Builder
+	for i := range 300 {
+		fmt.Fprintf(&text, "<b>Line %d</b> of the <i>report</i> 🥓 &lt;3\n", i)
+		if i%10 == 9 {
+			text.WriteString("\n")
+		}
+	}
+	keyboard := InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{{Text: "More", CallbackData: "more"}}}}
+
+	sent, err := c.SendText(t.Context(), "1", text.String(), WithParseMode(ParseModeHTML), ReplyTo(5), WithKeyboard(keyboard))
+	require.NoError(t, err, "every part must be valid HTML within the limit")
+	require.Greater(t, len(sent), 1)
+
+	messages := srv.Messages("1")
+	require.Len(t, messages, len(sent))
+	var joined []string
+	for i, msg := range messages {
+		require.Equal(t, ParseModeHTML, msg.ParseMode)
+		require.Equal(t, i == 0, msg.ReplyTo == 5, "only the first part is a reply")
+		require.Equal(t, i == len(messages)-1, msg.ReplyMarkup != "", "only the last part has the keyboard")
+		require.True(t, strings.HasSuffix(msg.Text, "&lt;3"), "parts end at paragraphs")
+		joined = append(joined, msg.Text)
+	}
+	require.Equal(t, strings.TrimSpace(text.String()), strings.Join(joined, "\n\n"))
+}
+
+func TestText(t *testing.T) {
+	var text Text
+	text.Bold("🥓 Bacon").Plain(" & <eggs>: ").Code("a_b").Plain("\n").
+		Pre("x := 1", "go").Link("docs", "https://example.com").Spoiler("").Spoiler("secret")
+
+	require.Equal(t, "🥓 Bacon & <eggs>: a_b\nx := 1docssecret", text.String())
+	require.Equal(t, UTF16Len(text.String()), text.Len())
+	require.Equal(t, []MessageEntity{
+		{Type: EntityBold, Offset: 0, Length: 8},
+		{Type: EntityCode, Offset: 19, Length: 3},
+		{Type: EntityPre, Offset: 23, Length: 6, Language: "go"},
+		{Type: EntityTextLink, Offset: 29, Length: 4, URL: "https://example.com"},
+		{Type: EntitySpoiler, Offset: 33, Length: 6},
+	}, text.Entities(), "offsets are in UTF-16 units, empty entities are dropped")
+
+	var report Text
+	report.Plain("📍 ").Append(new(Text).Bold("Limassol"))
+	require.Equal(t, "📍 Limassol", report.String())
+	require.Equal(t, []MessageEntity{{Type: EntityBold, Offset: 3, Length: 8}}, report.Entities())
+}
+
+func TestClient_SendText_Entities(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := New(WithToken("tok"), WithAPIURL(srv.URL), WithDoer(srv.Client()))
+
+	var text Text
+	text.Bold("Report").Plain("\n\n")
+	var table strings.Builder
+	for i := range 400 {
+		fmt.Fprintf(&table, "🥓 row %3d | <%d>\n", i, i*i)
+	}
+	text.Pre(table.String(), "").Plain("\n").Link("source", "https://open-meteo.com")
+
+	sent, err := c.SendText(t.Context(), "1", text.String(), WithEntities(text.Entities()))
+	require.NoError(t, err, "entities of every part must be within its text")
+	require.Greater(t, len(sent), 1)
+
+	messages := srv.Messages("1")
+	var joined strings.Builder
+	for i, msg := range messages {
+		var entities []MessageEntity
+		require.NoError(t, json.Unmarshal([]byte(msg.Entities), &entities))
+		switch {
+		case i == 0:
+			require.Equal(t, "Report", msg.Text, "parts end at paragraphs outside of entities")
+			require.Equal(t, []MessageEntity{{Type: EntityBold, Length: 6}}, entities)
+		case i == len(messages)-1:
+			require.Equal(t, EntityTextLink, entities[len(entities)-1].Type)
+			fallthrough
+		default:
+			require.Equal(t, MessageEntity{Type: EntityPre, Length: entities[0].Length}, entities[0],
+				"the code block continues at the start of the part")
+		}
+		joined.WriteString(msg.Text)
+	}
+	require.Equal(t, strings.ReplaceAll(text.String(), "\n", ""), strings.ReplaceAll(joined.String(), "\n", ""))
+
+	_, err = c.SendPhoto(t.Context(), "1", MediaUpload{
+		Reader: strings.NewReader("photo"), Caption: text.String()[:6], CaptionEntities: text.Entities()[:1],
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"type":"bold","offset":0,"length":6}]`, srv.Messages("1")[len(messages)].Entities)
+
+	_, err = c.SendMessage(t.Context(), "1", "short", WithEntities(text.Entities()))
+	require.ErrorContains(t, err, "out of the text")
 }
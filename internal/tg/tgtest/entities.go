@@ -0,0 +1,266 @@
+package tgtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Parse modes accepted by the server.
+const (
+	parseModeHTML       = "HTML"
+	parseModeMarkdownV2 = "MarkdownV2"
+	parseModeMarkdown   = "Markdown"
+)
+
+// htmlTags are tags supported by the HTML parse mode.
+var htmlTags = map[string]bool{
+	"b": true, "strong": true,
+	"i": true, "em": true,
+	"u": true, "ins": true,
+	"s": true, "strike": true, "del": true,
+	"span": true, "tg-spoiler": true,
+	"a": true, "tg-emoji": true,
+	"code": true, "pre": true,
+	"blockquote": true,
+}
+
+var htmlEntities = map[string]string{"lt": "<", "gt": ">", "amp": "&", "quot": `"`}
+
+// markdownReserved must be escaped outside of MarkdownV2 entities.
+const markdownReserved = "_*[]()~`>#+-=|{}.!"
+
+// plainText returns the text as shown to users, with markup of the parse mode removed.
+// Malformed markup is reported with a problem worded like the Bot API.
+func plainText(text, parseMode string) (plain, problem string) {
+	switch parseMode {
+	case "", parseModeMarkdown:
+		return text, ""
+	case parseModeHTML:
+		plain, problem = parseHTML(text)
+	case parseModeMarkdownV2:
+		plain, problem = parseMarkdownV2(text)
+	default:
+		return "", "unsupported parse_mode"
+	}
+	if problem != "" {
+		return "", "can't parse entities: " + problem
+	}
+	return plain, ""
+}
+
+func parseHTML(s string) (plain, problem string) {
+	var (
+		text strings.Builder
+		open []string
+	)
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return "", fmt.Sprintf("Unclosed start tag at byte offset %d", i)
+			}
+			tag := s[i+1 : i+end]
+			if name, ok := strings.CutPrefix(tag, "/"); ok {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if len(open) == 0 {
+					return "", fmt.Sprintf("Unexpected end tag at byte offset %d", i)
+				}
+				if expected := open[len(open)-1]; name != expected {
+					return "", fmt.Sprintf("Unmatched end tag at byte offset %d, expected \"</%s>\", found \"</%s>\"", i, expected, name)
+				}
+				open = open[:len(open)-1]
+			} else {
+				fields := strings.Fields(tag)
+				if len(fields) == 0 || !htmlTags[strings.ToLower(fields[0])] {
+					return "", fmt.Sprintf("Unsupported start tag %q at byte offset %d", tag, i)
+				}
+				open = append(open, strings.ToLower(fields[0]))
+			}
+			i += end + 1
+		case '&':
+			if semi := strings.IndexByte(s[i:], ';'); semi > 0 {
+				if decoded, ok := htmlEntity(s[i+1 : i+semi]); ok {
+					text.WriteString(decoded)
+					i += semi + 1
+					continue
+				}
+			}
+			text.WriteByte('&')
+			i++
+		default:
+			text.WriteByte(s[i])
+			i++
+		}
+	}
+	if len(open) > 0 {
+		return "", fmt.Sprintf("Can't find end tag corresponding to start tag %q", open[len(open)-1])
+	}
+	return text.String(), ""
+}
+
+func htmlEntity(name string) (string, bool) {
+	if decoded, ok := htmlEntities[name]; ok {
+		return decoded, true
+	}
+	digits, ok := strings.CutPrefix(name, "#")
+	if !ok {
+		return "", false
+	}
+	base := 10
+	if hex, ok := strings.CutPrefix(strings.ToLower(digits), "x"); ok {
+		digits, base = hex, 16
+	}
+	code, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return "", false
+	}
+	return string(rune(code)), true
+}
+
+func parseMarkdownV2(s string) (plain, problem string) {
+	type entity struct {
+		marker string
+		offset int
+	}
+	var (
+		text strings.Builder
+		open []entity
+	)
+	toggle := func(marker string, offset int) {
+		if n := len(open); n > 0 && open[n-1].marker == marker {
+			open = open[:n-1]
+			return
+		}
+		open = append(open, entity{marker: marker, offset: offset})
+	}
+
+	for i := 0; i < len(s); {
+		ru, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case ru == '\\':
+			next, nextSize := utf8.DecodeRuneInString(s[i+size:])
+			if nextSize == 0 || next > 126 {
+				return "", "Character '\\' is reserved and must be escaped with the preceding '\\'"
+			}
+			text.WriteRune(next)
+			i += size + nextSize
+		case ru == '`':
+			marker := "`"
+			if strings.HasPrefix(s[i:], "```") {
+				marker = "```"
+			}
+			content, end, ok := cutEscaped(s, i+len(marker), marker)
+			if !ok {
+				return "", fmt.Sprintf("Can't find end of Pre entity at byte offset %d", i)
+			}
+			if marker == "```" {
+				// the first line of a pre block may name its language
+				if lang, code, ok := strings.Cut(content, "\n"); ok && !strings.ContainsAny(lang, " \t") {
+					content = code
+				}
+			}
+			text.WriteString(content)
+			i = end + len(marker)
+		case ru == '[':
+			open = append(open, entity{marker: "[", offset: i})
+			i += size
+		case ru == ']':
+			if n := len(open); n == 0 || open[n-1].marker != "[" {
+				return "", "Character ']' is reserved and must be escaped with the preceding '\\'"
+			}
+			open = open[:len(open)-1]
+			if !strings.HasPrefix(s[i+1:], "(") {
+				// a bracketed text without URL is a text mention in Telegram, not supported here
+				return "", fmt.Sprintf("Can't find end of the URL at byte offset %d", i)
+			}
+			_, end, ok := cutEscaped(s, i+2, ")")
+			if !ok {
+				return "", fmt.Sprintf("Can't find end of the URL at byte offset %d", i)
+			}
+			i = end + 1
+		case ru == '*' || ru == '~':
+			toggle(string(ru), i)
+			i += size
+		case ru == '_':
+			marker := "_"
+			if strings.HasPrefix(s[i:], "__") {
+				marker = "__"
+			}
+			toggle(marker, i)
+			i += len(marker)
+		case ru == '|' && strings.HasPrefix(s[i:], "||"):
+			toggle("||", i)
+			i += 2
+		case ru == '>' && (i == 0 || s[i-1] == '\n'):
+			// block quotation
+			i += size
+		case ru < utf8.RuneSelf && strings.ContainsRune(markdownReserved, ru):
+			return "", fmt.Sprintf("Character '%c' is reserved and must be escaped with the preceding '\\'", ru)
+		default:
+			text.WriteRune(ru)
+			i += size
+		}
+	}
+	if len(open) > 0 {
+		return "", fmt.Sprintf("Can't find end of the entity starting at byte offset %d", open[len(open)-1].offset)
+	}
+	return text.String(), ""
+}
+
+// cutEscaped returns unescaped text of s from start up to the unescaped marker
+// and the byte offset of the marker.
+func cutEscaped(s string, start int, marker string) (string, int, bool) {
+	var content strings.Builder
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			content.WriteByte(s[i])
+		case strings.HasPrefix(s[i:], marker):
+			return content.String(), i, true
+		default:
+			content.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
+
+// entityTypes are types of entities accepted in entities and caption_entities.
+var entityTypes = map[string]bool{
+	"mention": true, "hashtag": true, "cashtag": true, "bot_command": true,
+	"url": true, "email": true, "phone_number": true,
+	"bold": true, "italic": true, "underline": true, "strikethrough": true, "spoiler": true,
+	"blockquote": true, "expandable_blockquote": true,
+	"code": true, "pre": true, "text_link": true, "text_mention": true, "custom_emoji": true,
+}
+
+// checkEntities validates entities JSON of the text. Unlike the Bot API, which
+// drops entities out of the text, the server reports them to catch offset bugs.
+func checkEntities(raw, text string) (problem string) {
+	var entities []struct {
+		Type   string `json:"type"`
+		Offset int    `json:"offset"`
+		Length int    `json:"length"`
+		URL    string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(raw), &entities); err != nil {
+		return "can't parse entities JSON object"
+	}
+
+	n := utf16Len(text)
+	for i, entity := range entities {
+		switch {
+		case !entityTypes[entity.Type]:
+			return fmt.Sprintf("can't parse MessageEntity: unsupported type %q of entity %d", entity.Type, i)
+		case entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > n:
+			return fmt.Sprintf("entity %d [%d, %d) is out of the text of length %d", i, entity.Offset, entity.Offset+entity.Length, n)
+		case entity.Type == "text_link" && entity.URL == "":
+			return fmt.Sprintf("can't parse MessageEntity: text_link entity %d has no url", i)
+		}
+	}
+	return ""
+}
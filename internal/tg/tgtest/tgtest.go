@@ -0,0 +1,790 @@
+// Package tgtest implements a fake Telegram Bot API server for tests.
+//
+// The server keeps sent messages per chat, answers getUpdates from a queue
+// and rejects payloads the real API would reject: empty or too long texts
+// and captions, malformed parse mode markup or entities, wrong media group
+// sizes and unknown file IDs. Flood control and blocked chats are simulated
+// with Server.Fail and Server.Block. Answers to callback and inline queries
+// are kept too, see Server.Answers.
+package tgtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// Limits of the Bot API.
+const (
+	MaxTextLength    = 4096
+	MaxCaptionLength = 1024
+	MinMediaGroup    = 2
+	MaxMediaGroup    = 10
+)
+
+// Username of the bot returned by getMe.
+const Username = "tgtest_bot"
+
+const maxUploadMemory = 32 << 20 // 32MB
+
+// sendMethods maps methods sending a single file to the media type.
+var sendMethods = map[string]string{
+	"sendPhoto":     "photo",
+	"sendVideo":     "video",
+	"sendAnimation": "animation",
+	"sendAudio":     "audio",
+	"sendDocument":  "document",
+}
+
+// Message is a message sent to the server.
+type Message struct {
+	MessageID int
+	ChatID    string
+	ThreadID  int
+	// Method sent the message, e.g. "sendMessage" or "sendMediaGroup".
+	Method string
+	// Text or Caption as sent, with markup.
+	Text      string
+	Caption   string
+	ParseMode string
+	// Entities is the JSON of entities or caption_entities, if sent.
+	Entities string
+	// Media is the type of the attached file, e.g. "photo".
+	Media    string
+	FileID   string
+	FileName string
+	// Uploaded reports whether the file was uploaded rather than referenced by FileID.
+	Uploaded     bool
+	MediaGroupID string
+	ReplyTo      int
+	ReplyMarkup  string
+	Silent       bool
+	Edited       bool
+	Pinned       bool
+	// PinnedSilently reports whether the message was pinned without a notification.
+	PinnedSilently bool
+	Deleted        bool
+	// InlineMessageID is set for messages sent via the bot in inline mode.
+	InlineMessageID string
+}
+
+// Answer is an answer to a callback or inline query.
+type Answer struct {
+	// Method is answerCallbackQuery or answerInlineQuery.
+	Method  string
+	QueryID string
+	// Text is the notification shown for a callback query.
+	Text string
+	// Results is the JSON of inline query results.
+	Results   string
+	CacheTime int
+}
+
+// Fault is an error response returned instead of handling a request.
+type Fault struct {
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+	// MigrateToChatID reports that the group was upgraded to a supergroup.
+	MigrateToChatID int64
+}
+
+// TooManyRequests is a flood control error asking to retry after the delay.
+func TooManyRequests(retryAfter time.Duration) Fault {
+	return Fault{
+		Code:        http.StatusTooManyRequests,
+		Description: fmt.Sprintf("Too Many Requests: retry after %d", int(retryAfter.Seconds())),
+		RetryAfter:  retryAfter,
+	}
+}
+
+// BotBlocked is returned for chats where the bot was blocked or kicked.
+func BotBlocked() Fault {
+	return Fault{Code: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"}
+}
+
+func badRequest(problem string) *Fault {
+	return &Fault{Code: http.StatusBadRequest, Description: "Bad Request: " + problem}
+}
+
+// Server is a fake Bot API accepting any token.
+// Point a client at it with tg.WithAPIURL(server.URL).
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	chats      map[string][]*Message
+	calls      map[string]int
+	faults     []methodFault
+	blocked    map[string]bool
+	admins     map[string]map[int64]bool
+	inline     map[string]*Message
+	answers    []Answer
+	files      map[string]bool
+	updates    []json.RawMessage
+	updateIDs  []int
+	nextUpdate int
+	nextFile   int
+	nextGroup  int
+	offset     int
+	// queued is closed and replaced when an update is added.
+	queued chan struct{}
+}
+
+type methodFault struct {
+	method string
+	fault  Fault
+}
+
+// NewServer starts a server closed with the test.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{
+		chats:      map[string][]*Message{},
+		calls:      map[string]int{},
+		blocked:    map[string]bool{},
+		admins:     map[string]map[int64]bool{},
+		inline:     map[string]*Message{},
+		files:      map[string]bool{},
+		nextUpdate: 1,
+		queued:     make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Messages returns messages sent to the chat, including deleted ones, in order.
+func (s *Server) Messages(chatID string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]Message, 0, len(s.chats[chatID]))
+	for _, msg := range s.chats[chatID] {
+		messages = append(messages, *msg)
+	}
+	return messages
+}
+
+// Chats returns IDs of chats with sent messages, sorted.
+func (s *Server) Chats() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.chats))
+}
+
+// Calls returns the number of requests of the method, including failed ones.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+// Fail makes the next request of the method fail with the fault.
+// Empty method matches any method. Faults are used once, in order.
+func (s *Server) Fail(method string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, methodFault{method: method, fault: fault})
+}
+
+// Block makes every message sent to the chat fail with BotBlocked.
+func (s *Server) Block(chatID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocked[chatID] = true
+}
+
+// Promote makes the user an administrator of the chat in getChatMember,
+// other users are plain members.
+func (s *Server) Promote(chatID string, userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.admins[chatID] == nil {
+		s.admins[chatID] = map[int64]bool{}
+	}
+	s.admins[chatID][userID] = true
+}
+
+// AddInlineMessage adds a message sent by a user via the bot in inline mode,
+// so it can be edited by its inline message ID.
+func (s *Server) AddInlineMessage(inlineMessageID, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inline[inlineMessageID] = &Message{Method: "sendMessage", Text: text, InlineMessageID: inlineMessageID}
+}
+
+// InlineMessage returns the message sent in inline mode.
+func (s *Server) InlineMessage(inlineMessageID string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.inline[inlineMessageID]
+	if !ok {
+		return Message{}, false
+	}
+	return *msg, true
+}
+
+// Answers returns answers to callback and inline queries, in order.
+func (s *Server) Answers() []Answer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.answers)
+}
+
+// Delete marks the message as deleted by a user, so it can't be edited anymore.
+func (s *Server) Delete(chatID string, messageID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.message(chatID, messageID)
+	if msg == nil {
+		return false
+	}
+	msg.Deleted = true
+	return true
+}
+
+// AddUpdate queues an update for getUpdates and returns its update_id.
+// The update is encoded to JSON, e.g. a tg.Update or a map, and its
+// update_id is assigned by the server.
+func (s *Server) AddUpdate(update any) int {
+	raw, err := json.Marshal(update)
+	if err != nil {
+		panic(fmt.Sprintf("tgtest: marshal update: %v", err))
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		panic(fmt.Sprintf("tgtest: update must be a JSON object: %v", err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextUpdate
+	s.nextUpdate++
+	fields["update_id"] = json.RawMessage(strconv.Itoa(id))
+	raw, _ = json.Marshal(fields)
+
+	s.updates = append(s.updates, raw)
+	s.updateIDs = append(s.updateIDs, id)
+	close(s.queued)
+	s.queued = make(chan struct{})
+	return id
+}
+
+// Offset returns the last offset passed to getUpdates.
+// Updates before it are confirmed and dropped from the queue.
+func (s *Server) Offset() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.offset
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// paths are /bot<token>/<method>
+	bot, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok || !strings.HasPrefix(bot, "bot") || len(bot) == len("bot") {
+		writeFault(w, &Fault{Code: http.StatusNotFound, Description: "Not Found"})
+		return
+	}
+
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(maxUploadMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		writeFault(w, badRequest("can't parse request: "+err.Error()))
+		return
+	}
+	if r.MultipartForm != nil {
+		defer func() { _ = r.MultipartForm.RemoveAll() }()
+	}
+
+	if method == "getUpdates" {
+		s.getUpdates(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[method]++
+	if fault := s.takeFault(method); fault != nil {
+		writeFault(w, fault)
+		return
+	}
+
+	req := request{form: r.PostForm}
+	if r.MultipartForm != nil {
+		req.files = r.MultipartForm.File
+	}
+
+	var (
+		result any
+		fault  *Fault
+	)
+	switch method {
+	case "getMe":
+		result = map[string]any{"id": 1, "is_bot": true, "first_name": "Test Bot", "username": Username}
+	case "sendMessage":
+		result, fault = s.sendMessage(req)
+	case "sendMediaGroup":
+		result, fault = s.sendMediaGroup(req)
+	case "editMessageText":
+		result, fault = s.editMessageText(req)
+	case "pinChatMessage":
+		result, fault = s.pinChatMessage(req)
+	case "getChatMember":
+		result, fault = s.getChatMember(req)
+	case "answerCallbackQuery", "answerInlineQuery":
+		result, fault = s.answer(req, method)
+	default:
+		if media, ok := sendMethods[method]; ok {
+			result, fault = s.sendFile(req, method, media)
+			break
+		}
+		fault = &Fault{Code: http.StatusNotFound, Description: "Not Found"}
+	}
+	if fault != nil {
+		writeFault(w, fault)
+		return
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) takeFault(method string) *Fault {
+	for i, queued := range s.faults {
+		if queued.method == "" || queued.method == method {
+			s.faults = slices.Delete(s.faults, i, i+1)
+			return &queued.fault
+		}
+	}
+	return nil
+}
+
+// getUpdates answers with queued updates, waiting up to the timeout for new ones.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.PostForm.Get("offset"))
+	timeout, _ := strconv.Atoi(r.PostForm.Get("timeout"))
+	deadline := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer deadline.Stop()
+
+	s.mu.Lock()
+	s.calls["getUpdates"]++
+	fault := s.takeFault("getUpdates")
+	s.mu.Unlock()
+	if fault != nil {
+		writeFault(w, fault)
+		return
+	}
+
+	for {
+		s.mu.Lock()
+		s.offset = offset
+		for len(s.updateIDs) > 0 && s.updateIDs[0] < offset {
+			s.updates, s.updateIDs = s.updates[1:], s.updateIDs[1:]
+		}
+		updates := append([]json.RawMessage{}, s.updates...)
+		queued := s.queued
+		s.mu.Unlock()
+
+		if len(updates) > 0 || timeout <= 0 {
+			writeResult(w, updates)
+			return
+		}
+		select {
+		case <-queued:
+		case <-deadline.C:
+			writeResult(w, updates)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type request struct {
+	form  map[string][]string
+	files map[string][]*multipart.FileHeader
+}
+
+func (req request) get(name string) string {
+	if values := req.form[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// newMessage validates common parameters of sent messages and records a new message.
+func (s *Server) newMessage(req request, method string) (*Message, *Fault) {
+	chatID := req.get("chat_id")
+	switch {
+	case chatID == "":
+		return nil, badRequest("chat_id is empty")
+	case s.blocked[chatID]:
+		fault := BotBlocked()
+		return nil, &fault
+	}
+	threadID, _ := strconv.Atoi(req.get("message_thread_id"))
+	if markup := req.get("reply_markup"); markup != "" && !json.Valid([]byte(markup)) {
+		return nil, badRequest("can't parse reply keyboard markup JSON object")
+	}
+
+	var reply struct {
+		MessageID int `json:"message_id"`
+	}
+	if params := req.get("reply_parameters"); params != "" {
+		if err := json.Unmarshal([]byte(params), &reply); err != nil {
+			return nil, badRequest("can't parse reply parameters JSON object")
+		}
+	}
+
+	msg := &Message{
+		ChatID:      chatID,
+		ThreadID:    threadID,
+		Method:      method,
+		ReplyTo:     reply.MessageID,
+		ReplyMarkup: req.get("reply_markup"),
+		Silent:      req.get("disable_notification") == "true",
+	}
+	return msg, nil
+}
+
+func (s *Server) record(msg *Message) {
+	msg.MessageID = len(s.chats[msg.ChatID]) + 1
+	s.chats[msg.ChatID] = append(s.chats[msg.ChatID], msg)
+}
+
+func (s *Server) sendMessage(req request) (any, *Fault) {
+	msg, fault := s.newMessage(req, "sendMessage")
+	if fault != nil {
+		return nil, fault
+	}
+	msg.Text, msg.ParseMode, msg.Entities = req.get("text"), req.get("parse_mode"), req.get("entities")
+	if fault := validateText(msg.Text, msg.ParseMode, msg.Entities); fault != nil {
+		return nil, fault
+	}
+	s.record(msg)
+	return wireMessage(msg), nil
+}
+
+func (s *Server) sendFile(req request, method, media string) (any, *Fault) {
+	msg, fault := s.newMessage(req, method)
+	if fault != nil {
+		return nil, fault
+	}
+	msg.Caption, msg.ParseMode, msg.Entities = req.get("caption"), req.get("parse_mode"), req.get("caption_entities")
+	if fault := validateCaption(msg.Caption, msg.ParseMode, msg.Entities); fault != nil {
+		return nil, fault
+	}
+	if fault := s.attach(msg, req, media, media); fault != nil {
+		return nil, fault
+	}
+	s.record(msg)
+	return wireMessage(msg), nil
+}
+
+func (s *Server) sendMediaGroup(req request) (any, *Fault) {
+	var items []struct {
+		Type            string          `json:"type"`
+		Media           string          `json:"media"`
+		Caption         string          `json:"caption"`
+		ParseMode       string          `json:"parse_mode"`
+		CaptionEntities json.RawMessage `json:"caption_entities"`
+	}
+	if err := json.Unmarshal([]byte(req.get("media")), &items); err != nil {
+		return nil, badRequest("can't parse media JSON object")
+	}
+	if len(items) < MinMediaGroup || len(items) > MaxMediaGroup {
+		return nil, badRequest(fmt.Sprintf("wrong number of media: %d, must be %d-%d", len(items), MinMediaGroup, MaxMediaGroup))
+	}
+
+	s.nextGroup++
+	groupID := strconv.Itoa(s.nextGroup)
+	var sent []*Message
+	for i, item := range items {
+		group := mediaGroupOf(item.Type)
+		switch {
+		case group == "":
+			return nil, badRequest(fmt.Sprintf("media #%d has unsupported type %q", i+1, item.Type))
+		case group != mediaGroupOf(items[0].Type):
+			return nil, badRequest(fmt.Sprintf("%s can't be mixed with other media types", item.Type))
+		}
+
+		msg, fault := s.newMessage(req, "sendMediaGroup")
+		if fault != nil {
+			return nil, fault
+		}
+		msg.Caption, msg.ParseMode, msg.Entities, msg.MediaGroupID = item.Caption, item.ParseMode, string(item.CaptionEntities), groupID
+		if fault := validateCaption(msg.Caption, msg.ParseMode, msg.Entities); fault != nil {
+			return nil, fault
+		}
+		field, attached := strings.CutPrefix(item.Media, "attach://")
+		if !attached {
+			field = ""
+			msg.FileID = item.Media
+		}
+		if fault := s.attach(msg, req, field, item.Type); fault != nil {
+			return nil, fault
+		}
+		sent = append(sent, msg)
+	}
+
+	result := make([]map[string]any, 0, len(sent))
+	for _, msg := range sent {
+		s.record(msg)
+		result = append(result, wireMessage(msg))
+	}
+	return result, nil
+}
+
+// attach sets the file of the message: an upload in the field or a known file ID.
+func (s *Server) attach(msg *Message, req request, field, media string) *Fault {
+	msg.Media = media
+	if uploads := req.files[field]; field != "" && len(uploads) > 0 {
+		s.nextFile++
+		msg.FileID = "file-" + strconv.Itoa(s.nextFile)
+		msg.FileName = uploads[0].Filename
+		msg.Uploaded = true
+		s.files[msg.FileID] = true
+		return nil
+	}
+
+	if msg.FileID == "" {
+		msg.FileID = req.get(field)
+	}
+	if !s.files[msg.FileID] {
+		return badRequest("wrong file identifier/HTTP URL specified")
+	}
+	return nil
+}
+
+func mediaGroupOf(media string) string {
+	switch media {
+	case "photo", "video":
+		return "visual"
+	case "audio", "document":
+		return media
+	default:
+		return ""
+	}
+}
+
+func (s *Server) editMessageText(req request) (any, *Fault) {
+	var msg *Message
+	if id := req.get("inline_message_id"); id != "" {
+		msg = s.inline[id]
+	} else {
+		messageID, _ := strconv.Atoi(req.get("message_id"))
+		msg = s.message(req.get("chat_id"), messageID)
+	}
+	switch {
+	case msg == nil || msg.Deleted:
+		return nil, badRequest("message to edit not found")
+	case msg.Method != "sendMessage":
+		return nil, badRequest("there is no text in the message to edit")
+	}
+
+	text, parseMode, entities, markup := req.get("text"), req.get("parse_mode"), req.get("entities"), req.get("reply_markup")
+	if fault := validateText(text, parseMode, entities); fault != nil {
+		return nil, fault
+	}
+	if text == msg.Text && parseMode == msg.ParseMode && entities == msg.Entities && markup == msg.ReplyMarkup {
+		return nil, badRequest("message is not modified: specified new message content and reply markup are exactly the same as a current content and reply markup of the message")
+	}
+	msg.Text, msg.ParseMode, msg.Entities, msg.ReplyMarkup, msg.Edited = text, parseMode, entities, markup, true
+	if msg.InlineMessageID != "" {
+		// edits of inline messages return true
+		return true, nil
+	}
+	return wireMessage(msg), nil
+}
+
+func (s *Server) pinChatMessage(req request) (any, *Fault) {
+	messageID, _ := strconv.Atoi(req.get("message_id"))
+	msg := s.message(req.get("chat_id"), messageID)
+	if msg == nil || msg.Deleted {
+		return nil, badRequest("message to pin not found")
+	}
+	msg.Pinned = true
+	msg.PinnedSilently = req.get("disable_notification") == "true"
+	return true, nil
+}
+
+func (s *Server) getChatMember(req request) (any, *Fault) {
+	chatID := req.get("chat_id")
+	userID, err := strconv.ParseInt(req.get("user_id"), 10, 64)
+	if chatID == "" || err != nil {
+		return nil, badRequest("invalid user_id specified")
+	}
+	status := "member"
+	if s.admins[chatID][userID] {
+		status = "administrator"
+	}
+	return map[string]any{
+		"status": status,
+		"user":   map[string]any{"id": userID, "is_bot": false, "first_name": "User " + strconv.FormatInt(userID, 10)},
+	}, nil
+}
+
+func (s *Server) answer(req request, method string) (any, *Fault) {
+	field := "callback_query_id"
+	if method == "answerInlineQuery" {
+		field = "inline_query_id"
+	}
+	answer := Answer{
+		Method:  method,
+		QueryID: req.get(field),
+		Text:    req.get("text"),
+		Results: req.get("results"),
+	}
+	answer.CacheTime, _ = strconv.Atoi(req.get("cache_time"))
+	switch {
+	case answer.QueryID == "":
+		return nil, badRequest("query is too old and response timeout expired or query ID is invalid")
+	case method == "answerInlineQuery" && !json.Valid([]byte(answer.Results)):
+		return nil, badRequest("can't parse inline query results JSON object")
+	}
+	s.answers = append(s.answers, answer)
+	return true, nil
+}
+
+func (s *Server) message(chatID string, messageID int) *Message {
+	for _, msg := range s.chats[chatID] {
+		if msg.MessageID == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+func validateText(text, parseMode, entities string) *Fault {
+	plain, problem := formattedText(text, parseMode, entities)
+	switch n := utf16Len(plain); {
+	case problem != "":
+		return badRequest(problem)
+	case strings.TrimSpace(plain) == "":
+		return badRequest("message text is empty")
+	case n > MaxTextLength:
+		return badRequest("message is too long")
+	}
+	return nil
+}
+
+func validateCaption(caption, parseMode, entities string) *Fault {
+	plain, problem := formattedText(caption, parseMode, entities)
+	switch {
+	case problem != "":
+		return badRequest(problem)
+	case utf16Len(plain) > MaxCaptionLength:
+		return badRequest("message caption is too long")
+	}
+	return nil
+}
+
+// formattedText returns the text as shown to users. Entities take precedence
+// over the parse mode, like in the Bot API.
+func formattedText(text, parseMode, entities string) (plain, problem string) {
+	if entities == "" {
+		return plainText(text, parseMode)
+	}
+	return text, checkEntities(entities, text)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, ru := range s {
+		n += utf16.RuneLen(ru)
+	}
+	return n
+}
+
+// wireMessage encodes the message like the Bot API does.
+func wireMessage(msg *Message) map[string]any {
+	id, _ := strconv.ParseInt(msg.ChatID, 10, 64)
+	chatType := "private"
+	if id < 0 {
+		chatType = "supergroup"
+	}
+
+	wire := map[string]any{
+		"message_id": msg.MessageID,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]any{"id": id, "type": chatType},
+	}
+	if msg.ThreadID != 0 {
+		wire["message_thread_id"] = msg.ThreadID
+		wire["is_topic_message"] = true
+	}
+	if msg.Text != "" {
+		wire["text"] = msg.Text
+	}
+	if msg.Caption != "" {
+		wire["caption"] = msg.Caption
+	}
+	if msg.MediaGroupID != "" {
+		wire["media_group_id"] = msg.MediaGroupID
+	}
+	if msg.Edited {
+		wire["edit_date"] = time.Now().Unix()
+	}
+	if msg.FileID != "" {
+		file := map[string]any{"file_id": msg.FileID, "file_unique_id": "unique-" + msg.FileID}
+		if msg.Media == "photo" {
+			wire["photo"] = []any{file}
+		} else {
+			wire[msg.Media] = file
+		}
+	}
+	return wire
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeFault(w http.ResponseWriter, fault *Fault) {
+	body := map[string]any{
+		"ok":          false,
+		"error_code":  fault.Code,
+		"description": fault.Description,
+	}
+	parameters := map[string]any{}
+	if fault.RetryAfter > 0 {
+		parameters["retry_after"] = int(fault.RetryAfter.Seconds())
+	}
+	if fault.MigrateToChatID != 0 {
+		parameters["migrate_to_chat_id"] = fault.MigrateToChatID
+	}
+	if len(parameters) > 0 {
+		body["parameters"] = parameters
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(fault.Code)
+	_ = json.NewEncoder(w).Encode(body)
+}
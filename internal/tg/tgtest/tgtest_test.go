@@ -0,0 +1,247 @@
+package tgtest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ninedraft/daily-bacon/internal/tg"
+	"github.com/ninedraft/daily-bacon/internal/tg/tgtest"
+)
+
+func newClient(t *testing.T, srv *tgtest.Server, opts ...tg.Option) *tg.Client {
+	t.Helper()
+	return tg.New(append([]tg.Option{tg.WithToken("123:abc"), tg.WithAPIURL(srv.URL)}, opts...)...)
+}
+
+func TestServer_SendMessage(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+
+	sent, err := c.SendMessage(t.Context(), "-100", "hello", tg.InThread(7), tg.ReplyTo(3), tg.Silent(true))
+	require.NoError(t, err)
+	require.Equal(t, 1, sent.MessageID)
+	require.Equal(t, int64(-100), sent.Chat.ID)
+
+	_, err = c.SendMessage(t.Context(), "-100", "again")
+	require.NoError(t, err)
+
+	messages := srv.Messages("-100")
+	require.Len(t, messages, 2)
+	require.Equal(t, tgtest.Message{
+		MessageID: 1, ChatID: "-100", ThreadID: 7, Method: "sendMessage",
+		Text: "hello", ReplyTo: 3, Silent: true,
+	}, messages[0])
+	require.Equal(t, 2, messages[1].MessageID)
+	require.Equal(t, []string{"-100"}, srv.Chats())
+
+	_, err = c.SendMessage(t.Context(), "1", strings.Repeat("🥓", tgtest.MaxTextLength/2+1))
+	require.ErrorIs(t, err, tg.ErrMessageTooLong, "length is counted in UTF-16 units")
+	_, err = c.SendMessage(t.Context(), "1", " ")
+	require.ErrorContains(t, err, "message text is empty")
+	require.Empty(t, srv.Messages("1"))
+}
+
+func TestServer_ParseMode(t *testing.T) {
+	tests := []struct {
+		parseMode, text, problem string
+	}{
+		{tg.ParseModeHTML, `<b>bold</b> <a href="https://example.com">link</a> &lt;3`, ""},
+		{tg.ParseModeHTML, `<b><i>nested</i></b>`, ""},
+		{tg.ParseModeHTML, `<b>unclosed`, `Can't find end tag corresponding to start tag "b"`},
+		{tg.ParseModeHTML, `<b><i>crossed</b></i>`, `Unmatched end tag`},
+		{tg.ParseModeHTML, `<div>block</div>`, `Unsupported start tag "div"`},
+		{tg.ParseModeHTML, `1 < 2`, `Unclosed start tag`},
+		{tg.ParseModeMarkdownV2, `*bold* _italic_ __underline__ ~strike~ ||spoiler||`, ""},
+		{tg.ParseModeMarkdownV2, "`code` ```go\npre```", ""},
+		{tg.ParseModeMarkdownV2, `[link](https://example.com/\(x\)) 1\.5`, ""},
+		{tg.ParseModeMarkdownV2, `1.5`, `Character '.' is reserved`},
+		{tg.ParseModeMarkdownV2, `*bold`, `Can't find end of the entity starting at byte offset 0`},
+		{tg.ParseModeMarkdownV2, "`code", `Can't find end of Pre entity`},
+		{"Bold", `text`, `unsupported parse_mode`},
+	}
+
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+	for _, test := range tests {
+		_, err := c.SendPhoto(t.Context(), "1", tg.MediaUpload{
+			Reader: strings.NewReader("photo"), Caption: test.text, ParseMode: test.parseMode,
+		})
+		if test.problem == "" {
+			require.NoError(t, err, test.text)
+			continue
+		}
+		require.ErrorContains(t, err, test.problem, test.text)
+	}
+
+	// the limit applies to the text without markup
+	caption := "<b>" + strings.Repeat("&lt;", tgtest.MaxCaptionLength) + "</b>"
+	_, err := c.SendPhoto(t.Context(), "1", tg.MediaUpload{Reader: strings.NewReader("photo"), Caption: caption, ParseMode: tg.ParseModeHTML})
+	require.NoError(t, err)
+	_, err = c.SendPhoto(t.Context(), "1", tg.MediaUpload{Reader: strings.NewReader("photo"), Caption: caption + ".", ParseMode: tg.ParseModeHTML})
+	require.ErrorIs(t, err, tg.ErrMessageTooLong)
+}
+
+func TestServer_Media(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv, tg.WithFileCache(tg.NewFileCache()))
+
+	uploads := func() []tg.MediaUpload {
+		return []tg.MediaUpload{
+			{FileName: "a.png", ContentType: "image/png", Reader: strings.NewReader("a"), Caption: "album"},
+			{FileName: "b.png", ContentType: "image/png", Reader: strings.NewReader("b")},
+		}
+	}
+
+	sent, err := c.SendMediaGroup(t.Context(), "1", uploads())
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	require.NotEmpty(t, sent[0].FileID())
+
+	_, err = c.SendMediaGroup(t.Context(), "1", uploads())
+	require.NoError(t, err)
+
+	messages := srv.Messages("1")
+	require.Len(t, messages, 4)
+	require.Equal(t, "a.png", messages[0].FileName)
+	require.Equal(t, "album", messages[0].Caption)
+	require.Equal(t, messages[0].MediaGroupID, messages[1].MediaGroupID)
+	require.True(t, messages[0].Uploaded)
+	require.False(t, messages[2].Uploaded, "cached files are sent by file ID")
+	require.Equal(t, messages[0].FileID, messages[2].FileID)
+
+	_, err = c.SendPhoto(t.Context(), "1", tg.MediaUpload{FileID: "unknown"})
+	require.ErrorIs(t, err, tg.ErrWrongFileID)
+
+	long := uploads()
+	long[0].Caption = strings.Repeat("x", tgtest.MaxCaptionLength+1)
+	_, err = c.SendMediaGroup(t.Context(), "1", long)
+	require.ErrorIs(t, err, tg.ErrMessageTooLong)
+	require.Len(t, srv.Messages("1"), 4)
+}
+
+func TestServer_Edit(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+
+	sent, err := c.SendMessage(t.Context(), "1", "draft")
+	require.NoError(t, err)
+
+	_, err = c.EditMessageText(t.Context(), "1", sent.MessageID, "final")
+	require.NoError(t, err)
+	_, err = c.EditMessageText(t.Context(), "1", sent.MessageID, "final")
+	require.ErrorIs(t, err, tg.ErrMessageNotModified)
+
+	messages := srv.Messages("1")
+	require.Equal(t, "final", messages[0].Text)
+	require.True(t, messages[0].Edited)
+
+	require.NoError(t, c.PinChatMessage(t.Context(), "1", sent.MessageID, true))
+	require.True(t, srv.Messages("1")[0].Pinned)
+	require.True(t, srv.Messages("1")[0].PinnedSilently)
+
+	require.True(t, srv.Delete("1", sent.MessageID))
+	_, err = c.EditMessageText(t.Context(), "1", sent.MessageID, "gone")
+	require.ErrorIs(t, err, tg.ErrMessageNotFound)
+}
+
+func TestServer_Queries(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+
+	srv.Promote("-100", 1)
+	admin, err := c.GetChatMember(t.Context(), "-100", 1)
+	require.NoError(t, err)
+	require.True(t, admin.IsAdmin())
+	member, err := c.GetChatMember(t.Context(), "-100", 2)
+	require.NoError(t, err)
+	require.Equal(t, tg.MemberMember, member.Status)
+
+	require.NoError(t, c.AnswerCallbackQuery(t.Context(), "q", "done", false))
+	article := tg.NewArticle("1", "Title", "Description", "text")
+	require.NoError(t, c.AnswerInlineQuery(t.Context(), "iq", []tg.InlineQueryResultArticle{article}, tg.InlineAnswer{CacheTime: time.Minute}))
+
+	answers := srv.Answers()
+	require.Len(t, answers, 2)
+	require.Equal(t, tgtest.Answer{Method: "answerCallbackQuery", QueryID: "q", Text: "done"}, answers[0])
+	require.Equal(t, "iq", answers[1].QueryID)
+	require.Equal(t, 60, answers[1].CacheTime)
+	require.Contains(t, answers[1].Results, `"title":"Title"`)
+
+	require.Error(t, c.EditInlineMessageText(t.Context(), "inline-1", "edited"))
+	srv.AddInlineMessage("inline-1", "sent")
+	require.NoError(t, c.EditInlineMessageText(t.Context(), "inline-1", "edited"))
+	msg, ok := srv.InlineMessage("inline-1")
+	require.True(t, ok)
+	require.Equal(t, "edited", msg.Text)
+	require.True(t, msg.Edited)
+}
+
+func TestServer_Faults(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+
+	srv.Fail("sendMessage", tgtest.TooManyRequests(3*time.Second))
+	_, err := c.SendMessage(t.Context(), "1", "hi")
+	var apiErr *tg.APIError
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.Code)
+	require.Equal(t, 3*time.Second, apiErr.RetryAfter)
+
+	_, err = c.SendMessage(t.Context(), "1", "hi")
+	require.NoError(t, err, "faults are used once")
+
+	srv.Block("2")
+	_, err = c.SendMessage(t.Context(), "2", "hi")
+	require.ErrorIs(t, err, tg.ErrBotBlocked)
+	_, err = c.SendPhoto(t.Context(), "2", tg.MediaUpload{Reader: strings.NewReader("photo")})
+	require.ErrorIs(t, err, tg.ErrBotBlocked)
+
+	require.Equal(t, 3, srv.Calls("sendMessage"))
+	require.Len(t, srv.Messages("1"), 1)
+	require.Empty(t, srv.Messages("2"))
+}
+
+func TestServer_Updates(t *testing.T) {
+	srv := tgtest.NewServer(t)
+	c := newClient(t, srv)
+
+	me, err := c.GetMe(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, tgtest.Username, me.Username)
+
+	first := srv.AddUpdate(tg.Update{Message: &tg.Message{Text: "/now", Chat: tg.Chat{ID: 1}}})
+	srv.AddUpdate(map[string]any{"message": map[string]any{"text": "/pollen", "chat": map[string]any{"id": 1}}})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var texts []string
+	var commits []int
+	opts := tg.PollOptions{
+		Timeout: time.Second,
+		Commit: func(offset int) error {
+			commits = append(commits, offset)
+			return nil
+		},
+	}
+	err = c.Poll(ctx, opts, func(_ context.Context, update tg.Update) {
+		texts = append(texts, update.Message.Text)
+		if len(texts) == 2 {
+			cancel()
+		}
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/now", "/pollen"}, texts)
+	require.Equal(t, []int{first + 2}, commits)
+
+	updates, err := c.GetUpdates(t.Context(), first+2, 0, nil)
+	require.NoError(t, err)
+	require.Empty(t, updates)
+	require.Equal(t, first+2, srv.Offset())
+}
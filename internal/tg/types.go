@@ -0,0 +1,97 @@
+package tg
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// response is the envelope of every Bot API response.
+type response struct {
+	OK          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result"`
+	ErrorCode   int                 `json:"error_code"`
+	Description string              `json:"description"`
+	Parameters  *responseParameters `json:"parameters"`
+}
+
+type responseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	RetryAfter      int   `json:"retry_after"`
+}
+
+// Chat is a Telegram chat.
+type Chat struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// Message is a Telegram message.
+type Message struct {
+	MessageID       int    `json:"message_id"`
+	MessageThreadID int    `json:"message_thread_id,omitempty"`
+	IsTopicMessage  bool   `json:"is_topic_message,omitempty"`
+	Date            int64  `json:"date"`
+	Chat            Chat   `json:"chat"`
+	Text            string `json:"text,omitempty"`
+	Caption         string `json:"caption,omitempty"`
+	MediaGroupID    string `json:"media_group_id,omitempty"`
+
+	From *User `json:"from,omitempty"`
+	// SenderChat is set for messages sent on behalf of a chat, e.g. by anonymous group admins.
+	SenderChat     *Chat    `json:"sender_chat,omitempty"`
+	ReplyToMessage *Message `json:"reply_to_message,omitempty"`
+
+	Photo     []File `json:"photo,omitempty"`
+	Document  *File  `json:"document,omitempty"`
+	Video     *File  `json:"video,omitempty"`
+	Audio     *File  `json:"audio,omitempty"`
+	Animation *File  `json:"animation,omitempty"`
+
+	Location *Location `json:"location,omitempty"`
+	Venue    *Venue    `json:"venue,omitempty"`
+}
+
+// Location is a point on the map shared in a message.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Venue is a named place shared in a message.
+type Venue struct {
+	Location Location `json:"location"`
+	Title    string   `json:"title"`
+	Address  string   `json:"address,omitempty"`
+}
+
+// File is a file attached to a message.
+// Photos are represented by several files of different sizes.
+type File struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileSize     int64  `json:"file_size,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+}
+
+// FileID returns file_id of the media attached to the message,
+// which can be used to send the same file again without uploading it.
+// For photos, the largest size is returned.
+func (m Message) FileID() string {
+	if n := len(m.Photo); n > 0 {
+		return m.Photo[n-1].FileID
+	}
+	for _, file := range []*File{m.Animation, m.Video, m.Audio, m.Document} {
+		if file != nil {
+			return file.FileID
+		}
+	}
+	return ""
+}
+
+// Time returns the date the message was sent.
+func (m Message) Time() time.Time {
+	return time.Unix(m.Date, 0)
+}
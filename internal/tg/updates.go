@@ -0,0 +1,160 @@
+package tg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Update is an incoming update received with getUpdates or a webhook.
+type Update struct {
+	UpdateID      int            `json:"update_id"`
+	Message       *Message       `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+	InlineQuery   *InlineQuery   `json:"inline_query,omitempty"`
+}
+
+// User is a Telegram user or bot.
+type User struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username,omitempty"`
+}
+
+// GetMe returns the bot user.
+func (c *Client) GetMe(ctx context.Context) (User, error) {
+	var me User
+	if err := c.callForm(ctx, "getMe", url.Values{}, &me); err != nil {
+		return User{}, err
+	}
+	return me, nil
+}
+
+// GetUpdates receives updates starting from offset, waiting up to timeout
+// for new ones. Empty allowed keeps the update types of the previous call.
+func (c *Client) GetUpdates(ctx context.Context, offset int, timeout time.Duration, allowed []string) ([]Update, error) {
+	data := url.Values{}
+	if offset != 0 {
+		data.Set("offset", strconv.Itoa(offset))
+	}
+	data.Set("timeout", strconv.Itoa(int(timeout/time.Second)))
+	if len(allowed) > 0 {
+		data.Set("allowed_updates", mustJSON(allowed))
+	}
+
+	var updates []Update
+	if err := c.callForm(ctx, "getUpdates", data, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+const (
+	defaultPollTimeout    = 30 * time.Second
+	defaultHandlerTimeout = 30 * time.Second
+	minPollBackoff        = time.Second
+	maxPollBackoff        = time.Minute
+)
+
+// PollOptions configure Poll.
+type PollOptions struct {
+	// Offset is the identifier of the first update to receive.
+	Offset int
+	// Timeout of a single long polling request. Defaults to 30s.
+	Timeout time.Duration
+	// AllowedUpdates lists update types to receive, e.g. "message".
+	AllowedUpdates []string
+	// HandlerTimeout bounds handling of a single update. On shutdown
+	// the current batch is still handled within the timeout. Defaults to 30s.
+	HandlerTimeout time.Duration
+	// Commit is called with the offset of the next update after every
+	// handled batch, so it can be persisted between restarts.
+	Commit func(offset int) error
+}
+
+// Poll receives updates with getUpdates long polling and passes them
+// to handle one by one, until ctx is canceled. Failed requests are retried
+// with backoff. Poll returns nil on cancellation and an error
+// if the token is rejected or another consumer of updates is running.
+func (c *Client) Poll(ctx context.Context, opts PollOptions, handle func(context.Context, Update)) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultPollTimeout
+	}
+	if opts.HandlerTimeout <= 0 {
+		opts.HandlerTimeout = defaultHandlerTimeout
+	}
+
+	offset := opts.Offset
+	backoff := minPollBackoff
+	for ctx.Err() == nil {
+		updates, err := c.GetUpdates(ctx, offset, opts.Timeout, opts.AllowedUpdates)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case isFatalPollError(err):
+			return err
+		case err != nil:
+			delay := backoff
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+				delay = apiErr.RetryAfter
+			}
+			c.logger.WarnContext(ctx, "get updates failed", "err", err, "retry_in", delay)
+			if !sleep(ctx, delay) {
+				return nil
+			}
+			backoff = min(backoff*2, maxPollBackoff)
+			continue
+		}
+		backoff = minPollBackoff
+
+		if len(updates) == 0 {
+			continue
+		}
+		for _, update := range updates {
+			c.handleUpdate(ctx, opts.HandlerTimeout, update, handle)
+		}
+		offset = updates[len(updates)-1].UpdateID + 1
+		if opts.Commit != nil {
+			if err := opts.Commit(offset); err != nil {
+				c.logger.ErrorContext(ctx, "commit updates offset", "offset", offset, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// handleUpdate runs handle detached from the polling context,
+// so an update received before shutdown is not interrupted by it.
+func (c *Client) handleUpdate(ctx context.Context, timeout time.Duration, update Update, handle func(context.Context, Update)) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	handle(ctx, update)
+}
+
+// isFatalPollError reports whether retrying getUpdates is pointless:
+// the token is invalid or updates are consumed elsewhere (webhook or another poller).
+func isFatalPollError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusConflict
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,34 @@
+package timezones
+
+import "math"
+
+const earthRadiusKm = 6371.0
+
+// Nearest returns the IANA timezone with the representative point
+// closest to the coordinates, e.g. "Asia/Nicosia".
+// Zones are not matched by borders, so places close to a border may get a neighbouring zone.
+func Nearest(latitude, longitude float64) string {
+	var (
+		nearest string
+		minDist = math.Inf(1)
+	)
+	for _, zone := range geoTimezones {
+		for _, p := range zone.Points {
+			if dist := distanceKm(latitude, longitude, p.Latitude, p.Longitude); dist < minDist {
+				nearest, minDist = zone.Name, dist
+			}
+		}
+	}
+	return nearest
+}
+
+// distanceKm returns the great-circle distance between two points.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
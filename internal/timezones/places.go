@@ -0,0 +1,94 @@
+package timezones
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata" // zones must load on hosts without zoneinfo
+)
+
+// Place is the representative city of a timezone.
+type Place struct {
+	// Name is the city name, e.g. "Buenos Aires".
+	Name                string
+	Timezone            string
+	Latitude, Longitude float64
+}
+
+// Places returns places with names or timezones matching query:
+// exact matches first, then prefix and substring matches, alphabetically.
+func Places(query string) []Place {
+	query = normalizePlace(query)
+	if query == "" {
+		return nil
+	}
+
+	type match struct {
+		place Place
+		rank  int
+	}
+	var matches []match
+	for _, zone := range geoTimezones {
+		if len(zone.Points) == 0 {
+			continue
+		}
+		name := strings.ReplaceAll(path.Base(zone.Name), "_", " ")
+		rank := placeRank(query, normalizePlace(name), normalizePlace(zone.Name))
+		if rank < 0 {
+			continue
+		}
+		matches = append(matches, match{
+			place: Place{
+				Name:      name,
+				Timezone:  zone.Name,
+				Latitude:  zone.Points[0].Latitude,
+				Longitude: zone.Points[0].Longitude,
+			},
+			rank: rank,
+		})
+	}
+
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Or(cmp.Compare(a.rank, b.rank), strings.Compare(a.place.Name, b.place.Name))
+	})
+
+	places := make([]Place, 0, len(matches))
+	for _, m := range matches {
+		places = append(places, m.place)
+	}
+	return places
+}
+
+// placeRank returns 0 for exact, 1 for prefix and 2 for substring matches, -1 otherwise.
+func placeRank(query, name, zone string) int {
+	switch {
+	case query == name || query == zone:
+		return 0
+	case strings.HasPrefix(name, query):
+		return 1
+	case strings.Contains(zone, query):
+		return 2
+	default:
+		return -1
+	}
+}
+
+func normalizePlace(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, "_", " ")), " "))
+}
+
+// Location loads an IANA timezone, e.g. "Asia/Nicosia".
+// Unlike time.LoadLocation it rejects empty names and "Local".
+func Location(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("invalid timezone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("load timezone: %w", err)
+	}
+	return loc, nil
+}
@@ -15,3 +15,57 @@ func TestFind(t *testing.T) {
 	}
 	t.Log("got:", got)
 }
+
+func TestNearest(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		latitude, longitude float64
+		want                string
+	}{
+		{34.707130, 33.022617, "Asia/Nicosia"}, // Limassol
+		{51.5, -0.1, "Europe/London"},
+		{52.52, 13.4, "Europe/Berlin"},
+		{-33.9, 151.2, "Australia/Sydney"},
+	} {
+		if got := timezones.Nearest(tc.latitude, tc.longitude); got != tc.want {
+			t.Errorf("Nearest(%v, %v) = %q, want %q", tc.latitude, tc.longitude, got, tc.want)
+		}
+	}
+}
+
+func TestPlaces(t *testing.T) {
+	t.Parallel()
+
+	places := timezones.Places("  buenos_AIRES ")
+	if len(places) == 0 || places[0].Timezone != "America/Argentina/Buenos_Aires" || places[0].Name != "Buenos Aires" {
+		t.Fatalf("unexpected places: %+v", places)
+	}
+
+	places = timezones.Places("Ber")
+	if len(places) == 0 || places[0].Name != "Berlin" {
+		t.Fatalf("unexpected places: %+v", places)
+	}
+
+	if places := timezones.Places("no such place"); len(places) != 0 {
+		t.Fatalf("unexpected places: %+v", places)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	t.Parallel()
+
+	loc, err := timezones.Location("Asia/Nicosia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.String() != "Asia/Nicosia" {
+		t.Fatalf("got %s", loc)
+	}
+
+	for _, name := range []string{"", "Local", "Mars/Olympus"} {
+		if _, err := timezones.Location(name); err == nil {
+			t.Errorf("Location(%q): expected error", name)
+		}
+	}
+}
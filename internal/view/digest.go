@@ -0,0 +1,184 @@
+package view
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/ninedraft/daily-bacon/internal/digest"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+)
+
+type variable struct {
+	key, icon, label string
+}
+
+// variables lists known variables in display order.
+var variables = []variable{
+	{meteo.PM10, "🟤", "PM₁₀"},
+	{meteo.PM2_5, "🔴", "PM₂.₅"},
+	{meteo.CarbonMonoxide, "🛢️", "CO"},
+	{meteo.CarbonDioxide, "☁️", "CO₂"},
+	{meteo.NitrogenDioxide, "💨", "NO₂"},
+	{meteo.SulphurDioxide, "🛑", "SO₂"},
+	{meteo.Ozone, "🟢", "Ozone"},
+	{meteo.AerosolOpticalDepth, "🌫️", "Aerosol Opt. Depth"},
+	{meteo.Dust, "💨", "Dust"},
+	{meteo.UVIndex, "🔆", "UV Index"},
+	{meteo.UVIndexClearSky, "☀️", "UV Index Clear Sky"},
+	{meteo.Ammonia, "🧪", "Ammonia"},
+	{meteo.Methane, "🛢️", "Methane"},
+	{meteo.AlderPollen, "🌳", "Alder Pollen"},
+	{meteo.BirchPollen, "🌳", "Birch Pollen"},
+	{meteo.GrassPollen, "🌱", "Grass Pollen"},
+	{meteo.MugwortPollen, "🌾", "Mugwort Pollen"},
+	{meteo.OlivePollen, "🫒", "Olive Pollen"},
+	{meteo.RagweedPollen, "🍂", "Ragweed Pollen"},
+	{meteo.EuropeanAQI, "📊", "EU AQI"},
+	{meteo.EuropeanAQI_PM2_5, "📊", "EU AQI PM₂.₅"},
+	{meteo.EuropeanAQI_PM10, "📊", "EU AQI PM₁₀"},
+	{meteo.EuropeanAQI_NitrogenDioxide, "📊", "EU AQI NO₂"},
+	{meteo.EuropeanAQI_Ozone, "📊", "EU AQI Ozone"},
+	{meteo.EuropeanAQI_SulphurDioxide, "📊", "EU AQI SO₂"},
+	{meteo.USAQI, "📊", "US AQI"},
+	{meteo.USAQI_PM2_5, "📊", "US AQI PM₂.₅"},
+	{meteo.USAQI_PM10, "📊", "US AQI PM₁₀"},
+	{meteo.USAQI_NitrogenDioxide, "📊", "US AQI NO₂"},
+	{meteo.USAQI_Ozone, "📊", "US AQI Ozone"},
+	{meteo.USAQI_SulphurDioxide, "📊", "US AQI SO₂"},
+	{meteo.USAQI_CarbonMonoxide, "📊", "US AQI CO"},
+}
+
+func lookupVariable(key string) (variable, bool) {
+	i := slices.IndexFunc(variables, func(v variable) bool { return v.key == key })
+	if i < 0 {
+		return variable{}, false
+	}
+	return variables[i], true
+}
+
+func variableOf(key string) variable {
+	if v, ok := lookupVariable(key); ok {
+		return v
+	}
+	return variable{key: key, icon: "•", label: key}
+}
+
+// Label returns the display name of a variable and reports whether the variable is known.
+func Label(key string) (string, bool) {
+	v, ok := lookupVariable(key)
+	return v.label, ok
+}
+
+const (
+	dateLayout     = "Mon 02 Jan"
+	dateTimeLayout = "Mon 02 Jan 15:04"
+)
+
+// Digest writes a weekly or monthly summary.
+func Digest(dst io.Writer, summary digest.Summary) error {
+	title := "📅  Weekly digest"
+	if summary.Period == digest.Month {
+		title = "📅  Monthly digest"
+	}
+	fmt.Fprintf(dst, "%s: %s – %s\n", title,
+		summary.From.Format(dateLayout),
+		summary.To.AddDate(0, 0, -1).Format(dateLayout))
+
+	if len(summary.Days) == 0 {
+		fmt.Fprintln(dst, "no data")
+		return nil
+	}
+
+	wr := tabwriter.NewWriter(dst, 0, tabWidth, tabPad, ' ', 0)
+
+	fmt.Fprintln(wr)
+	for _, level := range []meteo.Level{meteo.LevelGood, meteo.LevelWatch, meteo.LevelLimitExceeded, meteo.LevelActNow} {
+		fmt.Fprintf(wr, "%s\t%s:\t%d\tdays\n", levelIcon(level), level, summary.Bands[level])
+	}
+
+	worst := summary.Worst
+	fmt.Fprintf(wr, "\n🏴  Worst day: %s, %s %s (%s %s %s)\n",
+		worst.Date.Format(dateLayout),
+		worst.Level, levelIcon(worst.Level),
+		variableOf(worst.Key).label, formatFloat(worst.Value), summary.Units[worst.Key])
+
+	if len(summary.Peaks) > 0 {
+		fmt.Fprintln(wr, "\n📈  Peaks")
+		for _, peak := range summary.Peaks {
+			v := variableOf(peak.Key)
+			fmt.Fprintf(wr, "%s\t%s:\t%s\t%s\t%s\t%s\n",
+				v.icon, v.label,
+				formatFloat(peak.Value), summary.Units[peak.Key],
+				peak.At.Format(dateTimeLayout),
+				levelIcon(peak.Level))
+		}
+	}
+
+	if len(summary.Pollen) > 0 {
+		fmt.Fprintln(wr, "\n🌼  Pollen season")
+		for _, season := range summary.Pollen {
+			v := variableOf(season.Key)
+			fmt.Fprintf(wr, "%s\t%s:\t%d/%d days,\tpeak %s %s on %s,\t%s\n",
+				v.icon, v.label,
+				season.ActiveDays, len(summary.Days),
+				formatFloat(season.Peak.Value), summary.Units[season.Key],
+				season.Peak.At.Format(dateLayout),
+				season.Trend)
+		}
+	}
+
+	if err := wr.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// ErrNoChartData is returned by DigestChart when the summary has no days.
+var ErrNoChartData = errors.New("no chart data")
+
+const (
+	chartBarWidth = 24
+	chartBarGap   = 6
+	chartHeight   = 160
+	chartPadding  = 10
+)
+
+var levelColors = map[meteo.Level]color.RGBA{
+	meteo.LevelGood:          {R: 0x4c, G: 0xaf, B: 0x50, A: 0xff},
+	meteo.LevelWatch:         {R: 0xff, G: 0xc1, B: 0x07, A: 0xff},
+	meteo.LevelLimitExceeded: {R: 0xff, G: 0x70, B: 0x43, A: 0xff},
+	meteo.LevelActNow:        {R: 0xc6, G: 0x28, B: 0x28, A: 0xff},
+}
+
+// DigestChart writes a PNG bar chart with the worst level of every day.
+func DigestChart(dst io.Writer, summary digest.Summary) error {
+	if len(summary.Days) == 0 {
+		return ErrNoChartData
+	}
+
+	width := 2*chartPadding + len(summary.Days)*(chartBarWidth+chartBarGap) - chartBarGap
+	img := image.NewRGBA(image.Rect(0, 0, width, chartHeight+2*chartPadding))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	const levels = int(meteo.LevelActNow) + 1
+	for i, day := range summary.Days {
+		height := chartHeight * (int(day.Level) + 1) / levels
+		x := chartPadding + i*(chartBarWidth+chartBarGap)
+		bar := image.Rect(x, chartPadding+chartHeight-height, x+chartBarWidth, chartPadding+chartHeight)
+		fill := cmp.Or(levelColors[day.Level], levelColors[meteo.LevelActNow])
+		draw.Draw(img, bar, image.NewUniform(fill), image.Point{}, draw.Src)
+	}
+
+	if err := png.Encode(dst, img); err != nil {
+		return fmt.Errorf("encode png: %w", err)
+	}
+	return nil
+}
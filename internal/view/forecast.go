@@ -0,0 +1,107 @@
+package view
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/ninedraft/daily-bacon/internal/digest"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
+)
+
+// ForecastVars lists variables shown by Forecast, in column order.
+var ForecastVars = []string{
+	meteo.PM2_5,
+	meteo.PM10,
+	meteo.Dust,
+	meteo.Ozone,
+	meteo.NitrogenDioxide,
+	meteo.EuropeanAQI,
+}
+
+const hourLayout = "15:04"
+
+// Forecast writes an hourly table of ForecastVars with the worst level of every hour.
+func Forecast(dst io.Writer, samples []digest.Sample, units map[string]string) error {
+	if len(samples) == 0 {
+		fmt.Fprintln(dst, "no data")
+		return nil
+	}
+	fmt.Fprintf(dst, "🔮  Forecast: %s – %s\n",
+		samples[0].Time.Format(dateTimeLayout),
+		samples[len(samples)-1].Time.Format(hourLayout))
+
+	keys := slices.DeleteFunc(slices.Clone(ForecastVars), func(key string) bool {
+		return !slices.ContainsFunc(samples, func(sample digest.Sample) bool {
+			_, ok := sample.Values[key]
+			return ok
+		})
+	})
+
+	wr := tabwriter.NewWriter(dst, 0, tabWidth, tabPad, ' ', 0)
+
+	fmt.Fprint(wr, "\t")
+	for _, key := range keys {
+		fmt.Fprintf(wr, "\t%s", variableOf(key).label)
+		if unit := units[key]; unit != "" {
+			fmt.Fprintf(wr, ", %s", unit)
+		}
+	}
+	fmt.Fprintln(wr)
+
+	for _, sample := range samples {
+		worst := meteo.LevelGood
+		for _, key := range keys {
+			worst = max(worst, meteo.LevelOf(key, sample.Values[key]))
+		}
+		fmt.Fprintf(wr, "%s\t%s", sample.Time.Format(hourLayout), levelIcon(worst))
+		for _, key := range keys {
+			fmt.Fprintf(wr, "\t%s", formatFloat(sample.Values[key]))
+		}
+		fmt.Fprintln(wr)
+	}
+
+	if err := wr.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// Pollen writes the first and the peak value of every pollen in the air.
+func Pollen(dst io.Writer, samples []digest.Sample, units map[string]string) error {
+	fmt.Fprintln(dst, "🌼  Pollen")
+
+	wr := tabwriter.NewWriter(dst, 0, tabWidth, tabPad, ' ', 0)
+
+	found := false
+	for _, key := range digest.Pollen {
+		var peak digest.Sample
+		for _, sample := range samples {
+			if sample.Values[key] > peak.Values[key] {
+				peak = sample
+			}
+		}
+		if peak.Values[key] == 0 {
+			continue
+		}
+		found = true
+
+		v := variableOf(key)
+		level := meteo.LevelOf(key, peak.Values[key])
+		fmt.Fprintf(wr, "%s\t%s:\tnow %s,\tpeak %s %s at %s\t%s\n",
+			v.icon, v.label,
+			formatFloat(samples[0].Values[key]),
+			formatFloat(peak.Values[key]), units[key],
+			peak.Time.Format(hourLayout),
+			levelIcon(level))
+	}
+	if !found {
+		fmt.Fprintln(wr, "no pollen in the air")
+	}
+
+	if err := wr.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
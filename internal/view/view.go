@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/ninedraft/daily-bacon/internal/meteo"
 	"github.com/ninedraft/daily-bacon/internal/models"
+	"github.com/ninedraft/daily-bacon/internal/tg"
 )
 
 const (
@@ -22,84 +24,126 @@ func AirQuality(dst io.Writer, data models.AirQualityResponse) error {
 		fmt.Fprintln(dst, "no data")
 		return nil
 	}
-	curr := data.Current
-	units := data.CurrentUnits
-
 	fmt.Fprintln(dst, "🕒  Current Air Quality")
 
-	type field struct {
-		icon, label string
-		value       float64
-		unit        string
+	for _, f := range airQualityFields(data) {
+		fmt.Fprintf(wr, "%s\t%s:\t%s\t%s\t%s\t%s\n",
+			f.icon,
+			f.label,
+			formatFloat(f.value),
+			f.unit,
+			f.level.String(),
+			levelIcon(f.level),
+		)
 	}
-	fields := []field{
-		{"🟤", "PM₁₀", curr.PM10, units.PM10},
-		{"🔴", "PM₂.₅", curr.PM25, units.PM25},
-		{"🛢️", "CO", curr.CarbonMonoxide, units.CarbonMonoxide},
-		{"☁️", "CO₂", curr.CarbonDioxide, units.CarbonDioxide},
-		{"💨", "NO₂", curr.NitrogenDioxide, units.NitrogenDioxide},
-		{"🛑", "SO₂", curr.SulphurDioxide, units.SulphurDioxide},
-		{"🟢", "Ozone", curr.Ozone, units.Ozone},
-		{"🌫️", "Aerosol Opt. Depth", curr.AerosolOpticalDepth, units.AerosolOpticalDepth},
-		{"💨", "Dust", curr.Dust, units.Dust},
-		{"🔆", "UV Index", curr.UVIndex, units.UVIndex},
-		{"☀️", "UV Index Clear Sky", curr.UVIndexClearSky, units.UVIndexClearSky},
-		{"🧪", "Ammonia", curr.Ammonia, units.Ammonia},
-		{"🛢️", "Methane", curr.Methane, units.Methane},
-		{"🌳", "Alder Pollen", curr.AlderPollen, units.AlderPollen},
-		{"🌳", "Birch Pollen", curr.BirchPollen, units.BirchPollen},
-		{"🌱", "Grass Pollen", curr.GrassPollen, units.GrassPollen},
-		{"🌾", "Mugwort Pollen", curr.MugwortPollen, units.MugwortPollen},
-		{"🫒", "Olive Pollen", curr.OlivePollen, units.OlivePollen},
-		{"🍂", "Ragweed Pollen", curr.RagweedPollen, units.RagweedPollen},
-		{"📊", "EU AQI", curr.EuropeanAQI, units.EuropeanAQI},
-		{"📊", "EU AQI PM₂.₅", curr.EuropeanAQIPM25, units.EuropeanAQIPM25},
-		{"📊", "EU AQI PM₁₀", curr.EuropeanAQIPM10, units.EuropeanAQIPM10},
-		{"📊", "EU AQI NO₂", curr.EuropeanAQINO2, units.EuropeanAQINO2},
-		{"📊", "EU AQI Ozone", curr.EuropeanAQIOzone, units.EuropeanAQIOzone},
-		{"📊", "EU AQI SO₂", curr.EuropeanAQISO2, units.EuropeanAQISO2},
-		{"📊", "US AQI", curr.USAQI, units.USAQI},
-		{"📊", "US AQI PM₂.₅", curr.USAQIPM25, units.USAQIPM25},
-		{"📊", "US AQI PM₁₀", curr.USAQIPM10, units.USAQIPM10},
-		{"📊", "US AQI NO₂", curr.USAQINO2, units.USAQINO2},
-		{"📊", "US AQI Ozone", curr.USAQIOzone, units.USAQIOzone},
-		{"📊", "US AQI SO₂", curr.USAQISO2, units.USAQISO2},
-		{"📊", "US AQI CO", curr.USAQICarbonMonoxide, units.USAQICarbonMonoxide},
+
+	if err := wr.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
 	}
+	return nil
+}
+
+// airQualityField is a current value shown by AirQuality.
+type airQualityField struct {
+	icon, label string
+	value       float64
+	unit        string
+	level       meteo.Level
+}
+
+// airQualityFields returns non-zero current values of data, in display order.
+func airQualityFields(data models.AirQualityResponse) []airQualityField {
+	values := meteo.Values(data.Current)
+	units := meteo.CurrentUnits(data.CurrentUnits)
 
-	for _, f := range fields {
-		if f.value != 0 {
-			level := meteo.LevelOf(f.label, f.value)
-			levelIcon := "‼️☠️"
-			switch level {
-			case meteo.LevelGood:
-				levelIcon = "✅"
-			case meteo.LevelWatch:
-				levelIcon = "😷"
-			case meteo.LevelLimitExceeded:
-				levelIcon = "⚠️"
-			case meteo.LevelActNow:
-				levelIcon = "‼️☠️"
-			default:
-				// pass
-			}
-			fmt.Fprintf(wr, "%s\t%s:\t%s\t%s\t%s\t%s\n",
-				f.icon,
-				f.label,
-				formatFloat(f.value),
-				f.unit,
-				level.String(),
-				levelIcon,
-			)
+	var shown []airQualityField
+	for _, v := range variables {
+		if value := values[v.key]; value != 0 {
+			shown = append(shown, airQualityField{
+				icon:  v.icon,
+				label: v.label,
+				value: value,
+				unit:  units[v.key],
+				level: meteo.LevelOf(v.key, value),
+			})
 		}
 	}
+	return shown
+}
 
-	if err := wr.Flush(); err != nil {
-		return fmt.Errorf("write: %w", err)
+// AirQualityText formats current air quality like AirQuality, with entities
+// instead of column alignment: labels are bold and values monospace.
+func AirQualityText(data models.AirQualityResponse) *tg.Text {
+	text := new(tg.Text)
+	if data.Current == nil {
+		return text.Plain("no data")
+	}
+
+	text.Bold("🕒 Current Air Quality").Plain("\n")
+	for _, f := range airQualityFields(data) {
+		value := formatFloat(f.value)
+		if f.unit != "" {
+			value += " " + f.unit
+		}
+		text.Plain("\n" + f.icon + " ").Bold(f.label).Plain(": ").Code(value).
+			Plain(" " + levelIcon(f.level) + " " + f.level.String())
+	}
+	return text
+}
+
+func levelIcon(level meteo.Level) string {
+	switch level {
+	case meteo.LevelGood:
+		return "✅"
+	case meteo.LevelWatch:
+		return "😷"
+	case meteo.LevelLimitExceeded:
+		return "⚠️"
+	case meteo.LevelActNow:
+		return "‼️☠️"
+	default:
+		return "‼️☠️"
 	}
-	return nil
 }
 
 func formatFloat(f float64) string {
 	return strconv.FormatFloat(f, 'f', -1, 64)
 }
+
+// summaryVars lists variables of Summary, in order.
+var summaryVars = []string{
+	meteo.EuropeanAQI,
+	meteo.PM2_5,
+	meteo.PM10,
+	meteo.Dust,
+	meteo.Ozone,
+}
+
+// Summary writes a one-line summary of current air quality: the worst level and key values.
+func Summary(dst io.Writer, data models.AirQualityResponse) error {
+	if data.Current == nil {
+		_, err := fmt.Fprint(dst, "no data")
+		return err
+	}
+	values := meteo.Values(data.Current)
+	units := meteo.CurrentUnits(data.CurrentUnits)
+
+	worst := meteo.Worst(values)
+
+	parts := []string{levelIcon(worst) + " " + worst.String()}
+	for _, key := range summaryVars {
+		if values[key] == 0 {
+			continue
+		}
+		part := variableOf(key).label + " " + formatFloat(values[key])
+		if unit := units[key]; unit != "" {
+			part += " " + unit
+		}
+		parts = append(parts, part)
+	}
+
+	if _, err := fmt.Fprint(dst, strings.Join(parts, " · ")); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
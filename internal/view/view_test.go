@@ -2,8 +2,13 @@ package view
 
 import (
 	"bytes"
+	"image/png"
 	"testing"
+	"time"
+	"unicode/utf16"
 
+	"github.com/ninedraft/daily-bacon/internal/digest"
+	"github.com/ninedraft/daily-bacon/internal/meteo"
 	"github.com/ninedraft/daily-bacon/internal/models"
 	"github.com/stretchr/testify/require"
 )
@@ -17,3 +22,115 @@ func TestAirQuality(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, b.String())
 }
+
+func TestAirQuality_Levels(t *testing.T) {
+	// levels are looked up by variable key, labels such as "PM₁₀" have no bands
+	tests := []struct {
+		name    string
+		current models.CurrentData
+		level   meteo.Level
+	}{
+		{name: "PM10 good", current: models.CurrentData{PM10: 10}, level: meteo.LevelGood},
+		{name: "PM10 watch", current: models.CurrentData{PM10: 30}, level: meteo.LevelWatch},
+		{name: "PM2.5 limit exceeded", current: models.CurrentData{PM25: 40}, level: meteo.LevelLimitExceeded},
+		{name: "birch pollen act now", current: models.CurrentData{BirchPollen: 150}, level: meteo.LevelActNow},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := models.AirQualityResponse{
+				Current:      &test.current,
+				CurrentUnits: &models.CurrentUnits{},
+			}
+
+			var b bytes.Buffer
+			require.NoError(t, AirQuality(&b, data))
+			require.Contains(t, b.String(), test.level.String())
+
+			require.Contains(t, AirQualityText(data).String(), test.level.String())
+		})
+	}
+}
+
+func TestAirQualityText(t *testing.T) {
+	text := AirQualityText(models.AirQualityResponse{
+		Current:      &models.CurrentData{PM10: 1, Dust: 2.5},
+		CurrentUnits: &models.CurrentUnits{PM10: "μg/m³", Dust: "μg/m³"},
+	})
+	require.Contains(t, text.String(), "PM₁₀: 1 μg/m³")
+
+	var formatted []string
+	for _, entity := range text.Entities() {
+		units := utf16.Encode([]rune(text.String()))
+		formatted = append(formatted, entity.Type+" "+string(utf16.Decode(units[entity.Offset:entity.Offset+entity.Length])))
+	}
+	require.Equal(t, []string{
+		"bold 🕒 Current Air Quality",
+		"bold PM₁₀", "code 1 μg/m³",
+		"bold Dust", "code 2.5 μg/m³",
+	}, formatted)
+
+	require.Equal(t, "no data", AirQualityText(models.AirQualityResponse{}).String())
+}
+
+func TestDigest(t *testing.T) {
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	summary := digest.Summary{
+		Period: digest.Week,
+		From:   day,
+		To:     day.AddDate(0, 0, 7),
+		Days:   []digest.Day{{Date: day, Level: meteo.LevelWatch, Key: meteo.PM10, Value: 30}},
+		Bands:  map[meteo.Level]int{meteo.LevelWatch: 1},
+		Worst:  digest.Day{Date: day, Level: meteo.LevelWatch, Key: meteo.PM10, Value: 30},
+		Peaks:  []digest.Peak{{Key: meteo.PM10, Value: 30, At: day, Level: meteo.LevelWatch}},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, Digest(&b, summary))
+	require.Contains(t, b.String(), "Worst day")
+	require.Contains(t, b.String(), "PM₁₀")
+
+	var chart bytes.Buffer
+	require.NoError(t, DigestChart(&chart, summary))
+	_, err := png.Decode(&chart)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, DigestChart(&chart, digest.Summary{}), ErrNoChartData)
+}
+
+func TestForecast(t *testing.T) {
+	at := time.Date(2025, 5, 1, 15, 0, 0, 0, time.UTC)
+	samples := []digest.Sample{
+		{Time: at, Values: map[string]float64{meteo.PM2_5: 5, meteo.BirchPollen: 10}},
+		{Time: at.Add(time.Hour), Values: map[string]float64{meteo.PM2_5: 60, meteo.BirchPollen: 120}},
+	}
+	units := map[string]string{meteo.PM2_5: "μg/m³", meteo.BirchPollen: "grains/m³"}
+
+	var b bytes.Buffer
+	require.NoError(t, Forecast(&b, samples, units))
+	require.Contains(t, b.String(), "PM₂.₅, μg/m³")
+	require.NotContains(t, b.String(), "PM₁₀")
+	require.Contains(t, b.String(), "16:00  ‼️☠️")
+
+	b.Reset()
+	require.NoError(t, Pollen(&b, samples, units))
+	require.Contains(t, b.String(), "Birch Pollen")
+	require.Contains(t, b.String(), "peak 120 grains/m³ at 16:00")
+
+	b.Reset()
+	require.NoError(t, Pollen(&b, samples[:0], units))
+	require.Contains(t, b.String(), "no pollen in the air")
+}
+
+func TestSummary(t *testing.T) {
+	var b bytes.Buffer
+	err := Summary(&b, models.AirQualityResponse{
+		Current:      &models.CurrentData{PM10: 12, PM25: 30, EuropeanAQI: 45},
+		CurrentUnits: &models.CurrentUnits{PM10: "μg/m³", PM25: "μg/m³", EuropeanAQI: "EAQI"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "⚠️ Limit Exceeded · EU AQI 45 EAQI · PM₂.₅ 30 μg/m³ · PM₁₀ 12 μg/m³", b.String())
+
+	b.Reset()
+	require.NoError(t, Summary(&b, models.AirQualityResponse{}))
+	require.Equal(t, "no data", b.String())
+}